@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+//icalSyncer is what the worker needs of icalsync.Syncer
+type icalSyncer interface {
+	SyncAll(ctx context.Context) error
+}
+
+//icalSyncWorker syncs the calendars of the other channels every interval, the first time right after it started
+type icalSyncWorker struct {
+	syncer   icalSyncer
+	interval time.Duration
+	// quit stops the worker and cancels a sync in progress, done is closed once the worker is finished
+	quit chan struct{}
+	done chan struct{}
+}
+
+//newICalSyncWorker creates the worker, with an interval of 0 it never syncs
+func newICalSyncWorker(syncer icalSyncer, interval time.Duration) *icalSyncWorker {
+	return &icalSyncWorker{
+		syncer:   syncer,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//start runs the worker in the background
+func (w *icalSyncWorker) start() {
+	if w.interval <= 0 {
+		close(w.done)
+		return
+	}
+	go w.run()
+}
+
+//run syncs until the worker is stopped
+func (w *icalSyncWorker) run() {
+	defer close(w.done)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.syncer.SyncAll(ctx); err != nil && ctx.Err() == nil {
+			errorLog.Println(err)
+		}
+		select {
+		case <-ticker.C:
+		case <-w.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//fakeSyncer counts its syncs, with block a sync runs until it is cancelled
+type fakeSyncer struct {
+	block bool
+	calls chan struct{}
+}
+
+func (s *fakeSyncer) SyncAll(ctx context.Context) error {
+	select {
+	case s.calls <- struct{}{}:
+	default:
+	}
+	if s.block {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return nil
+}
+
+func TestICalSyncWorker(t *testing.T) {
+	syncer := &fakeSyncer{calls: make(chan struct{}, 10)}
+	w := newICalSyncWorker(syncer, 10*time.Millisecond)
+	w.start()
+	// right away, then every interval
+	for i := 0; i < 3; i++ {
+		select {
+		case <-syncer.calls:
+		case <-time.After(time.Second):
+			t.Fatalf("expected sync %d", i+1)
+		}
+	}
+	close(w.quit)
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the worker to stop")
+	}
+}
+
+func TestICalSyncWorker_Disabled(t *testing.T) {
+	syncer := &fakeSyncer{calls: make(chan struct{}, 1)}
+	w := newICalSyncWorker(syncer, 0)
+	w.start()
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("expected a worker that never syncs to be done")
+	}
+	if len(syncer.calls) != 0 {
+		t.Error("expected no sync")
+	}
+}
@@ -2,23 +2,24 @@ package main
 
 import (
 	"encoding/gob"
-	"flag"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
 	"github.com/majedutd990/bookings/internal/config"
 	"github.com/majedutd990/bookings/internal/driver"
 	"github.com/majedutd990/bookings/internal/handlers"
 	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/icalsync"
+	"github.com/majedutd990/bookings/internal/mailer"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/payments"
 	"github.com/majedutd990/bookings/internal/render"
+	"github.com/majedutd990/bookings/internal/repository/dbrepo"
 	"log"
 	"net/http"
 	"os"
 	"time"
 )
 
-const portNumber = ":8080"
-
 // we made this app var package level, so we have access to it in middleware file
 var app config.AppConfig
 
@@ -34,16 +35,24 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
-	defer close(app.MailChan)
 	log.Println("Starting mail listener....")
-	listenForMail()
+	// mails go through the outbox table, so they are not lost when smtp is down or we restart
+	mw := newMailWorker(dbrepo.NewPostgresRepo(db.SQL, &app), app.SMTP)
+	listenForMail(mw)
+	log.Println("Starting calendar sync....")
+	// the bookings of the other channels block our rooms, see internal/icalsync
+	sw := newICalSyncWorker(icalsync.New(dbrepo.NewPostgresRepo(db.SQL, &app), nil), time.Duration(app.ICalSyncMinutes)*time.Minute)
+	sw.start()
+	log.Println("Starting trash purge....")
+	pw := newTrashPurgeWorker(dbrepo.NewPostgresRepo(db.SQL, &app), app.TrashDays, trashPurgeInterval)
+	pw.start()
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    fmt.Sprintf(":%d", app.Port),
 		Handler: routes(&app),
 	}
-	fmt.Println(fmt.Sprintf("Starting Application On Port %s.", portNumber))
-	err = srv.ListenAndServe()
+	fmt.Println(fmt.Sprintf("Starting Application On Port %d.", app.Port))
+	// serve returns once we are shut down by a signal, with the mail flushed and the database closed
+	err = serve(srv, mw, sw, pw, db.SQL)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -61,31 +70,12 @@ func run() (*driver.DB, error) {
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
-	//let's read some of our configuration atr values from command line as a flag with -
-	// change this to true when in production
-	inProduction := flag.Bool("production", true, "Application is in production!")
-	useCache := flag.Bool("cache", true, "Use template cache (in production true)!")
-	// let's specify database info because we don't want to put them in our code base we get it from command line
-	dbName := flag.String("dbname", "", "DataBase Name")
-	dbHost := flag.String("dbhost", "localhost", "DataBase Host")
-	dbUser := flag.String("dbuser", "", "DataBase username")
-	dbPass := flag.String("dbpass", "", "DataBase password")
-	dbPort := flag.String("dbport", "5432", "DataBase port")
-	dbSSL := flag.String("dbssl", "disable", "DataBase SSL settings (disable, prefer,require)")
-	// let's parse them
-	flag.Parse()
-	// prefer use ssl if it exists, require u must have it
-	// remember all of them are pointers so should use * indirect access operator
-	// we set all of them in run.sh
-	// also we need to check for the necessary flags
-	// we can also use .env file and also a package for it go check it out
-
-	if *dbName == "" || *dbUser == "" {
-		fmt.Println("Missing required flags!")
-		os.Exit(1)
+	// configuration comes from defaults, config.yml, the environment (and .env) and flags, in that order.
+	// keep passwords in the environment or .env, flags show up in ps
+	err := config.Load(&app, os.Args[1:])
+	if err != nil {
+		return nil, err
 	}
-
-	app.InProduction = *inProduction
 	//log in our std lib
 	infoLog = log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -110,13 +100,21 @@ func run() (*driver.DB, error) {
 	//connect to database
 	log.Println("Connecting to database")
 
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-
-	db, err := driver.ConnectSql(connectionString)
+	db, err := driver.ConnectSql(app.DB.ConnectionString())
 	if err != nil {
 		log.Fatal("Cannot connect to database. Dying!")
 	}
 	log.Println("Connected to database")
+
+	// mails wait in the outbox when smtp is down, so in development we only warn about it
+	log.Println("Checking smtp server")
+	err = checkSMTP(app.SMTP)
+	if err != nil {
+		if app.InProduction {
+			return db, err
+		}
+		log.Println(err)
+	}
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Println(err)
@@ -124,11 +122,24 @@ func run() (*driver.DB, error) {
 		return db, err
 	}
 	app.TemplateCache = tc
-	// the below code means we are in dev mode
-	app.UseCache = *useCache
 	//because it's type is pointer to AppConfig, here we send a reference
 	render.NewRenderer(&app)
 
+	// the mail templates get cached the same way
+	app.MailHTMLCache, app.MailTextCache, err = mailer.CreateTemplateCache("./email-templates")
+	if err != nil {
+		log.Println(err)
+		log.Fatal("cannot create mail template cache")
+		return db, err
+	}
+	mailer.NewMailer(&app)
+
+	// guests pay their deposit through this provider
+	app.Payments, err = payments.New(app.Payment.Provider, app.Payment.WebhookSecret)
+	if err != nil {
+		return db, err
+	}
+
 	// now let's create repo for our handlers and pass our app config here
 	repo := handlers.NewRepo(&app, db)
 	// now we pass it to handlers which uses it to make Repo Var
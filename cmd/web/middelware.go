@@ -1,9 +1,21 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
 	"github.com/justinas/nosurf"
+	"github.com/majedutd990/bookings/internal/handlers"
 	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
 	"net/http"
+	"strings"
+)
+
+const (
+	//maxFormBody is the biggest body of a browser form or a json request
+	maxFormBody = 1 << 20
+	//maxUploadBody is the biggest multipart body, a 5 MB photo or calendar and the rest of its form
+	maxUploadBody = 6 << 20
 )
 
 ////WriteToConsole next is a convention
@@ -31,6 +43,11 @@ func NoSurf(next http.Handler) http.Handler {
 		Secure:   app.InProduction,
 		SameSite: http.SameSiteLaxMode,
 	})
+	// browsers never add an Authorization header on their own, so token authenticated
+	// requests can't be forged and don't need a csrf token
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ")
+	})
 	return csrfHandler
 	//	cause it uses cookies to make sure
 	//	the token it generates is available on per page basis
@@ -39,20 +56,134 @@ func NoSurf(next http.Handler) http.Handler {
 	//	in production we change it to true
 }
 
+//LimitBody caps the size of request bodies. it has to run before NoSurf, which reads the whole
+// form looking for the csrf token before any handler sees it
+func LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit int64 = maxFormBody
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			limit = maxUploadBody
+		}
+		if r.ContentLength > limit {
+			http.Error(w, "the upload is too big, it may have 5 MB at most", http.StatusRequestEntityTooLarge)
+			return
+		}
+		// a body without a length is cut off at the limit while it is read
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
 //SessionLoad we should tell webserver that it has to use session using middleware
 // Load and save sessions on every requests
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+//Auth only lets logged-in users through. it has to run before RequirePermission, which relies on the
+// access level it keeps up to date in the session
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !helpers.IsAuthenticated(r) {
-			session.Put(r.Context(), "error", "log in first!")
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		if !checkUser(w, r) {
 			return
 		}
 		next.ServeHTTP(w, r)
 
 	})
 }
+
+//checkUser answers r with a redirect to the login page and returns false if nobody is logged in or the session
+// has ended. a session outlives a deactivation, a new password or a change of the access level, so we look the
+// user up again and store their current access level in the session. token users were looked up by TokenAuth already
+func checkUser(w http.ResponseWriter, r *http.Request) bool {
+	if !helpers.IsAuthenticated(r) {
+		session.Put(r.Context(), "error", "log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return false
+	}
+	if _, ok := helpers.AuthUserFromContext(r.Context()); ok {
+		return true
+	}
+	user, err := handlers.Repo.DB.GetUserByID(helpers.UserID(r))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return false
+	}
+	if err != nil || !user.Active || user.SessionVersion != session.GetInt(r.Context(), "session_version") {
+		_ = session.Destroy(r.Context())
+		_ = session.RenewToken(r.Context())
+		session.Put(r.Context(), "error", "your session has ended, log in again!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return false
+	}
+	session.Put(r.Context(), "access_level", user.AccessLevel)
+	return true
+}
+
+//RequirePermission only lets users through whose access level allows the given permission (see models.Permissions).
+// it runs after Auth or APIAuth. a session without an access level (from before they were kept in the session, or
+// a route that forgot Auth) gets it looked up like Auth does, instead of counting as no access at all
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, tokenUser := helpers.AuthUserFromContext(r.Context())
+			if !tokenUser && !session.Exists(r.Context(), "access_level") && !checkUser(w, r) {
+				return
+			}
+			if !models.Can(helpers.AccessLevel(r), permission) {
+				// token clients get a json answer, they have no session to put a message in
+				if _, ok := helpers.AuthUserFromContext(r.Context()); ok {
+					handlers.Repo.APIForbidden(w, r)
+					return
+				}
+				session.Put(r.Context(), "error", "you are not allowed to do that!")
+				http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//TokenAuth authenticates requests that carry an "Authorization: Bearer <token>" header.
+// requests without the header pass through untouched, a wrong or expired token is rejected right here.
+// the token gets the lower of its own access level and its user's one
+func TokenAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || token == "" {
+			handlers.Repo.APIUnauthorized(w, r)
+			return
+		}
+		t, err := handlers.Repo.DB.GetAPITokenByHash(helpers.HashToken(token))
+		if err != nil {
+			handlers.Repo.APIUnauthorized(w, r)
+			return
+		}
+		accessLevel := t.AccessLevel
+		if t.User.AccessLevel < accessLevel {
+			accessLevel = t.User.AccessLevel
+		}
+		ctx := helpers.WithAuthUser(r.Context(), helpers.AuthUser{
+			ID:          t.UserID,
+			AccessLevel: accessLevel,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+//APIAuth protects json api routes, there are no sessions there so only tokens count
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := helpers.AuthUserFromContext(r.Context()); !ok {
+			handlers.Repo.APIUnauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
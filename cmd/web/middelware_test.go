@@ -2,7 +2,13 @@ package main
 
 import (
 	"fmt"
+	"github.com/alexedwards/scs/v2"
+	"github.com/majedutd990/bookings/internal/handlers"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -31,3 +37,87 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http handler sessionLoad(). but is %T\n.", v))
 	}
 }
+
+func TestLimitBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		size        int
+		expected    int
+	}{
+		{"form", "application/x-www-form-urlencoded", 100, http.StatusOK},
+		{"form that is too big", "application/x-www-form-urlencoded", maxFormBody + 1, http.StatusRequestEntityTooLarge},
+		{"upload", "multipart/form-data; boundary=x", maxFormBody + 1, http.StatusOK},
+		{"upload that is too big", "multipart/form-data; boundary=x", maxUploadBody + 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, e := range tests {
+		read := false
+		h := LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			read = true
+		}))
+		req := httptest.NewRequest("POST", "/admin/rooms/1/images", strings.NewReader(strings.Repeat("x", e.size)))
+		req.Header.Set("Content-Type", e.contentType)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != e.expected {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expected, rr.Code)
+		}
+		if read != (e.expected == http.StatusOK) {
+			t.Errorf("%s: expected the handler to run %t", e.name, e.expected == http.StatusOK)
+		}
+	}
+
+	// without a length the body is cut off while it is read
+	h := LimitBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := ioutil.ReadAll(r.Body); err == nil {
+			t.Error("expected reading a body without a length past the limit to fail")
+		}
+	}))
+	req := httptest.NewRequest("POST", "/user/login", strings.NewReader(strings.Repeat("x", maxFormBody+1)))
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestRequirePermission(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelper(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
+	tests := []struct {
+		name               string
+		accessLevel        int
+		permission         string
+		expectedCode       int
+		expectedLocation   string
+		expectedLevelAfter int
+	}{
+		// sessions from before the access level was kept in them, user 1 is a manager
+		{"session without a level", 0, "reservations.delete", http.StatusOK, "", 3},
+		{"session without a level, not allowed", 0, "users.manage", http.StatusSeeOther, "/admin/dashboard", 3},
+		{"viewer", 1, "reservations.view", http.StatusOK, "", 1},
+		{"viewer can't delete", 1, "reservations.delete", http.StatusSeeOther, "/admin/dashboard", 1},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/reservations-all", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "session_version", 1)
+		if e.accessLevel > 0 {
+			session.Put(ctx, "access_level", e.accessLevel)
+		}
+		rr := httptest.NewRecorder()
+		RequirePermission(e.permission)(&myHandler{}).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+		}
+		if actual := rr.Header().Get("Location"); actual != e.expectedLocation {
+			t.Errorf("%s: expected location %q got %q", e.name, e.expectedLocation, actual)
+		}
+		if actual := session.GetInt(ctx, "access_level"); actual != e.expectedLevelAfter {
+			t.Errorf("%s: expected access level %d in the session got %d", e.name, e.expectedLevelAfter, actual)
+		}
+	}
+}
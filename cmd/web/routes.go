@@ -31,51 +31,158 @@ func routes(app *config.AppConfig) http.Handler {
 	//mux.Use(WriteToConsole)
 	// my middleware example
 
-	//CSRF attack middleWare
-	mux.Use(NoSurf)
-	// use session all the time
-	mux.Use(SessionLoad)
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suites", handlers.Repo.Majors)
-	//searching for availability we need both post and get here the only difference will be the handler
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJson)
-	//chose the room
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-
-	// book-room
-	mux.Get("/book-room", handlers.Repo.BookRoom)
-
-	mux.Get("/contact", handlers.Repo.Contact)
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
-
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.LogOut)
-	//============= static files=============
-	//we have to tell this router how to return our static files
-	// we have to create a file server a place that go gets these file from
-	fileServer := http.FileServer(http.Dir("./static/"))
-	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
-	// here we define routes that need to be protected or only be shown to registered users
-	// all the routes will be /admin/dashboard for example
-	mux.Route("/admin", func(mux chi.Router) {
-		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calender", handlers.Repo.AdminReservationsCalender)
-		mux.Post("/reservations-calender", handlers.Repo.PostAdminReservationsCalender)
-		// show reservation
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}/show", handlers.Repo.PostAdminShowReservation)
-		mux.Get("/process/reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/process/delete/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+	// scripts and partner systems can send an api token instead of logging in
+	mux.Use(TokenAuth)
+
+	// the json api is used by scripts and partner systems, so it lives outside
+	// the group below and gets neither csrf protection nor sessions
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(LimitBody)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APICreateReservation)
+		// existing reservations hold guest data, so they need an api token
+		mux.Group(func(mux chi.Router) {
+			mux.Use(APIAuth)
+			mux.With(RequirePermission("reservations.view")).Get("/reservations/{id}", handlers.Repo.APIGetReservation)
+			mux.With(RequirePermission("reservations.edit")).Put("/reservations/{id}", handlers.Repo.APIUpdateReservation)
+			mux.With(RequirePermission("reservations.delete")).Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+		})
+	})
+
+	// the payment provider tells us about payments here, it signs its requests instead of sending a csrf token
+	mux.With(LimitBody).Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+
+	// calendar feeds for google, outlook and the booking sites, they carry their own token
+	mux.Get("/ical/rooms/{id}.ics", handlers.Repo.ICalRoom)
+	mux.Get("/ical/property.ics", handlers.Repo.ICalProperty)
+
+	// everything the browser talks to
+	mux.Group(func(mux chi.Router) {
+		// before NoSurf, it reads the body
+		mux.Use(LimitBody)
+		//CSRF attack middleWare
+		mux.Use(NoSurf)
+		// use session all the time
+		mux.Use(SessionLoad)
+		mux.Get("/", handlers.Repo.Home)
+		mux.Get("/about", handlers.Repo.About)
+		mux.Get("/rooms", handlers.Repo.Rooms)
+		mux.Get("/rooms/{slug}", handlers.Repo.RoomPage)
+		// the rooms used to have their own pages, keep old links working
+		mux.Get("/generals-quarters", handlers.RedirectRoom("generals-quarters"))
+		mux.Get("/majors-suites", handlers.RedirectRoom("majors-suite"))
+		//searching for availability we need both post and get here the only difference will be the handler
+		mux.Get("/search-availability", handlers.Repo.Availability)
+		mux.Post("/search-availability", handlers.Repo.PostAvailability)
+		mux.Post("/search-availability-json", handlers.Repo.AvailabilityJson)
+		//chose the room
+		mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
+
+		// book-room
+		mux.Get("/book-room", handlers.Repo.BookRoom)
+
+		mux.Get("/contact", handlers.Repo.Contact)
+		mux.Get("/make-reservation", handlers.Repo.Reservation)
+		mux.Post("/make-reservation", handlers.Repo.PostReservation)
+		mux.Get("/make-reservation/payment", handlers.Repo.ReservationPayment)
+		mux.Post("/make-reservation/payment", handlers.Repo.PostReservationPayment)
+		mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+
+		// guests manage their own reservation through the link in their confirmation email
+		mux.Get("/reservations/manage/{token}", handlers.Repo.ManageReservation)
+		mux.Post("/reservations/manage/{token}/dates", handlers.Repo.PostManageReservationDates)
+		mux.Post("/reservations/manage/{token}/cancel", handlers.Repo.PostManageReservationCancel)
+
+		mux.Get("/user/login", handlers.Repo.ShowLogin)
+		mux.Post("/user/login", handlers.Repo.PostShowLogin)
+		mux.Get("/user/logout", handlers.Repo.LogOut)
+		// new staff choose their password through the link in their invite, the same page resets it
+		mux.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+		mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+		mux.Get("/user/password/{token}", handlers.Repo.ShowSetPassword)
+		mux.Post("/user/password/{token}", handlers.Repo.PostSetPassword)
+		//============= static files=============
+		//we have to tell this router how to return our static files
+		// we have to create a file server a place that go gets these file from
+		fileServer := http.FileServer(http.Dir("./static/"))
+		mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+		// here we define routes that need to be protected or only be shown to registered users
+		// all the routes will be /admin/dashboard for example
+		mux.Route("/admin", func(mux chi.Router) {
+			// Auth runs before the RequirePermission of every route below, Use always comes before With
+			mux.Use(Auth)
+			mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+			mux.Get("/profile", handlers.Repo.AdminProfile)
+			mux.Post("/profile/password", handlers.Repo.PostAdminProfilePassword)
+			// every route below checks the access level of the user, see models.Permissions
+			mux.With(RequirePermission("reservations.view")).Get("/reservations-new", handlers.Repo.AdminNewReservations)
+			mux.With(RequirePermission("reservations.view")).Get("/reservations-all", handlers.Repo.AdminAllReservations)
+			mux.With(RequirePermission("reservations.view")).Get("/reservations-calender", handlers.Repo.AdminReservationsCalender)
+			mux.With(RequirePermission("blocks.edit")).Post("/reservations-calender", handlers.Repo.PostAdminReservationsCalender)
+			// owner blocks over some days, with a type and a reason
+			mux.With(RequirePermission("blocks.edit")).Get("/blocks/{id}", handlers.Repo.AdminBlock)
+			mux.With(RequirePermission("blocks.edit")).Post("/blocks/{id}", handlers.Repo.PostAdminBlock)
+			mux.With(RequirePermission("blocks.edit")).Post("/blocks/{id}/delete", handlers.Repo.AdminDeleteBlock)
+			// the front desk books for guests on the phone or at the door
+			mux.With(RequirePermission("reservations.edit")).Get("/reservations/new", handlers.Repo.AdminBookReservation)
+			mux.With(RequirePermission("reservations.edit")).Post("/reservations/new", handlers.Repo.PostAdminBookReservation)
+			// show reservation
+			mux.With(RequirePermission("reservations.view")).Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
+			mux.With(RequirePermission("reservations.edit")).Post("/reservations/{src}/{id}/show", handlers.Repo.PostAdminShowReservation)
+			mux.With(RequirePermission("reservations.edit")).Post("/reservations/{src}/{id}/dates", handlers.Repo.PostAdminReservationDates)
+			mux.With(RequirePermission("reservations.process")).Post("/reservations/{src}/{id}/status", handlers.Repo.PostAdminReservationStatus)
+			mux.With(RequirePermission("reservations.delete")).Post("/process/delete/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+			// deleted reservations stay in the trash until they are purged
+			mux.With(RequirePermission("reservations.delete")).Get("/reservations-trash", handlers.Repo.AdminTrashReservations)
+			mux.With(RequirePermission("reservations.delete")).Post("/reservations-trash/{id}/restore", handlers.Repo.AdminRestoreReservation)
+			mux.With(RequirePermission("payments.manage")).Post("/reservations/{src}/{id}/payments/{paymentID}/capture", handlers.Repo.AdminCapturePayment)
+			mux.With(RequirePermission("payments.manage")).Post("/reservations/{src}/{id}/payments/{paymentID}/refund", handlers.Repo.AdminRefundPayment)
+			// api tokens of the logged-in user
+			mux.With(RequirePermission("api_tokens.manage")).Get("/api-tokens", handlers.Repo.AdminAPITokens)
+			mux.With(RequirePermission("api_tokens.manage")).Post("/api-tokens", handlers.Repo.PostAdminAPITokens)
+			mux.With(RequirePermission("api_tokens.manage")).Post("/api-tokens/{id}/delete", handlers.Repo.AdminDeleteAPIToken)
+
+			mux.With(RequirePermission("mail.manage")).Get("/failed-mail", handlers.Repo.AdminFailedMail)
+			mux.With(RequirePermission("mail.manage")).Post("/failed-mail/{id}/retry", handlers.Repo.AdminRetryMail)
+
+			// the kinds of unavailability, see models.Restriction
+			mux.With(RequirePermission("restrictions.manage")).Get("/restrictions", handlers.Repo.AdminRestrictions)
+			mux.With(RequirePermission("restrictions.manage")).Get("/restrictions/{id}", handlers.Repo.AdminRestriction)
+			mux.With(RequirePermission("restrictions.manage")).Post("/restrictions/{id}", handlers.Repo.PostAdminRestriction)
+			mux.With(RequirePermission("restrictions.manage")).Post("/restrictions/{id}/delete", handlers.Repo.AdminDeleteRestriction)
+
+			mux.With(RequirePermission("audit.view")).Get("/audit", handlers.Repo.AdminAudit)
+
+			// staff accounts, {id} is "new" to invite somebody
+			mux.With(RequirePermission("users.manage")).Get("/users", handlers.Repo.AdminUsers)
+			mux.With(RequirePermission("users.manage")).Get("/users/{id}", handlers.Repo.AdminUser)
+			mux.With(RequirePermission("users.manage")).Post("/users/{id}", handlers.Repo.PostAdminUser)
+			mux.With(RequirePermission("users.manage")).Post("/users/{id}/active", handlers.Repo.PostAdminUserActive)
+			mux.With(RequirePermission("users.manage")).Post("/users/{id}/password-link", handlers.Repo.PostAdminUserPasswordLink)
+			// login activity, and emails locked after too many failures
+			mux.With(RequirePermission("users.manage")).Get("/logins", handlers.Repo.AdminLogins)
+			mux.With(RequirePermission("users.manage")).Post("/logins/unlock", handlers.Repo.PostAdminUnlockLogin)
+
+			// rooms, {id} is "new" for a room that does not exist yet
+			mux.With(RequirePermission("rooms.manage")).Get("/rooms", handlers.Repo.AdminRooms)
+			mux.With(RequirePermission("rooms.manage")).Get("/rooms/{id}", handlers.Repo.AdminRoom)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}", handlers.Repo.PostAdminRoom)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/active", handlers.Repo.AdminRoomActive)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/move", handlers.Repo.AdminMoveRoom)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/images", handlers.Repo.PostAdminRoomImage)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/images/{imageID}/delete", handlers.Repo.AdminDeleteRoomImage)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/overrides", handlers.Repo.PostAdminRoomOverride)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/overrides/{overrideID}/delete", handlers.Repo.AdminDeleteRoomOverride)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/discounts", handlers.Repo.PostAdminRoomDiscount)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/discounts/{discountID}/delete", handlers.Repo.AdminDeleteRoomDiscount)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/ical-feeds", handlers.Repo.PostAdminRoomICalFeed)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/ical-feeds/{feedID}/sync", handlers.Repo.AdminSyncRoomICalFeed)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/ical-feeds/{feedID}/upload", handlers.Repo.PostAdminRoomICalFeedUpload)
+			mux.With(RequirePermission("rooms.manage")).Post("/rooms/{id}/ical-feeds/{feedID}/delete", handlers.Repo.AdminDeleteRoomICalFeed)
+		})
 	})
 	return mux
 }
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/majedutd990/bookings/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -19,3 +22,18 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.mux noSurf(). but is %T\n.", v))
 	}
 }
+
+//TestRoutes_LimitBody checks that the routes outside the browser group cap their bodies too
+func TestRoutes_LimitBody(t *testing.T) {
+	var app config.AppConfig
+	mux := routes(&app)
+	for _, url := range []string{"/api/v1/reservations", "/payments/webhook"} {
+		req := httptest.NewRequest("POST", url, strings.NewReader(strings.Repeat("x", maxFormBody+1)))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected code %d got %d", url, http.StatusRequestEntityTooLarge, rr.Code)
+		}
+	}
+}
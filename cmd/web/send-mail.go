@@ -2,69 +2,221 @@ package main
 
 import (
 	"fmt"
+	"github.com/majedutd990/bookings/internal/config"
 	"github.com/majedutd990/bookings/internal/models"
 	mail "github.com/xhit/go-simple-mail/v2"
-	"io/ioutil"
-	"strings"
 	"time"
 )
 
-func listenForMail() {
+//outboxStore is the part of the database the mail worker needs
+type outboxStore interface {
+	InsertOutboxMail(m models.MailData) error
+	GetDueOutboxMails(limit int) ([]models.OutboxMail, error)
+	UpdateOutboxMail(m models.OutboxMail) error
+}
+
+//mailWorker delivers the mails in the outbox. failed mails are tried again later, every time waiting
+// twice as long as before, and after maxAttempts they are marked dead and wait for an admin
+type mailWorker struct {
+	store        outboxStore
+	smtp         config.SMTPConfig
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	// wake makes the worker look at the outbox right away instead of waiting for the next poll
+	wake chan struct{}
+	// quit stops the worker, done is closed once the listener and the worker are finished
+	quit chan struct{}
+	done chan struct{}
+}
+
+//newMailWorker creates a mail worker with our default retry settings
+func newMailWorker(store outboxStore, smtp config.SMTPConfig) *mailWorker {
+	return &mailWorker{
+		store:        store,
+		smtp:         smtp,
+		pollInterval: 5 * time.Second,
+		batchSize:    20,
+		maxAttempts:  8,
+		baseBackoff:  30 * time.Second,
+		maxBackoff:   2 * time.Hour,
+		wake:         make(chan struct{}, 1),
+		quit:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+//listenForMail moves everything sent on app.MailChan into the outbox, so it survives restarts,
+// and starts the worker that delivers it. once app.MailChan is closed and drained the worker is stopped
+// too, and w.done is closed when both are finished
+func listenForMail(w *mailWorker) {
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		w.run()
+	}()
 
 	//	let's listen to our mail channel
-	//  m := <-app.MailChan
-	//  the above code is solution but a bad one
-	//	it is like calling it every single time we want to send a message
-	//	so what is the purpose of chan
-	//	we need some procedure to run in background and listen non-stop to catch an email and send it
-	//	a-synchronization
+	//	we need some procedure to run in background and listen non-stop to catch an email
 	//	 we fire an anonymous function in the background
 	//we want it to listen all the time for in-coming data
 	go func() {
-
-		for {
-			msg := <-app.MailChan
-			sendMailMsg(msg)
+		for msg := range app.MailChan {
+			err := w.store.InsertOutboxMail(msg)
+			if err != nil {
+				// nothing else we can do with it, at least leave a trace
+				errorLog.Printf("could not store mail to %s (%s) in the outbox: %s", msg.To, msg.Subject, err)
+				continue
+			}
+			w.notify()
 		}
+		// the channel is closed and everything in it is in the outbox, the worker can stop
+		close(w.quit)
+		<-workerDone
+		close(w.done)
 	}()
 }
 
-func sendMailMsg(m models.MailData) {
+//notify wakes the worker up, it never blocks
+func (w *mailWorker) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+//run delivers due mails until the worker is stopped, a delivery in progress is finished first
+func (w *mailWorker) run() {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+	for {
+		w.deliverDue()
+		select {
+		case <-ticker.C:
+		case <-w.wake:
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+//deliverDue tries to send every mail in the outbox that is due and stores the result
+func (w *mailWorker) deliverDue() {
+	mails, err := w.store.GetDueOutboxMails(w.batchSize)
+	if err != nil {
+		errorLog.Println(err)
+		return
+	}
+	for _, m := range mails {
+		err = sendMailMsg(w.smtp, m.Mail)
+		m = w.afterAttempt(m, err, time.Now())
+		if err != nil {
+			errorLog.Printf("sending mail %d failed (attempt %d): %s", m.ID, m.Attempts, err)
+		}
+		if updateErr := w.store.UpdateOutboxMail(m); updateErr != nil {
+			errorLog.Println(updateErr)
+		}
+	}
+}
+
+//afterAttempt returns the mail updated with the result of a delivery attempt made at now
+func (w *mailWorker) afterAttempt(m models.OutboxMail, sendErr error, now time.Time) models.OutboxMail {
+	m.Attempts++
+	if sendErr == nil {
+		m.Status = models.OutboxSent
+		m.SentAt = now
+		m.LastError = ""
+		return m
+	}
+	m.LastError = sendErr.Error()
+	if m.Attempts >= w.maxAttempts {
+		m.Status = models.OutboxDead
+		return m
+	}
+	m.Status = models.OutboxPending
+	m.NextAttemptAt = now.Add(w.backoff(m.Attempts))
+	return m
+}
+
+//backoff is how long to wait after the given number of failed attempts: base, 2*base, 4*base... up to maxBackoff
+func (w *mailWorker) backoff(attempts int) time.Duration {
+	d := w.baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= w.maxBackoff {
+			return w.maxBackoff
+		}
+	}
+	return d
+}
+
+//smtpEncryption turns the encryption setting of our config into the one of the mail package
+func smtpEncryption(encryption string) (mail.Encryption, error) {
+	switch encryption {
+	case config.SMTPEncryptionNone, "":
+		return mail.EncryptionNone, nil
+	case config.SMTPEncryptionSTARTTLS:
+		return mail.EncryptionSTARTTLS, nil
+	case config.SMTPEncryptionSSL:
+		return mail.EncryptionSSLTLS, nil
+	}
+	return mail.EncryptionNone, fmt.Errorf("unknown smtp encryption %q, use none, starttls or ssl", encryption)
+}
+
+//connectSMTP opens a connection to the smtp server, logging in if we have a username
+func connectSMTP(cfg config.SMTPConfig) (*mail.SMTPClient, error) {
+	encryption, err := smtpEncryption(cfg.Encryption)
+	if err != nil {
+		return nil, err
+	}
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
+	server.Host = cfg.Host
+	server.Port = cfg.Port
+	server.Encryption = encryption
+	server.Username = cfg.Username
+	server.Password = cfg.Password
+	server.Authentication = mail.AuthNone
+	if cfg.Username != "" {
+		server.Authentication = mail.AuthPlain
+	}
 	//we do not want it to be active all the time
 	server.KeepAlive = false
 	//	sensible time outs
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
-	//	 it is a dummy server if we use real one we need username and password too
-	//	 we have server now let's set the client
-	client, err := server.Connect()
+	return server.Connect()
+}
+
+//checkSMTP makes sure we can reach and log in to the smtp server, it is run once at startup
+func checkSMTP(cfg config.SMTPConfig) error {
+	client, err := connectSMTP(cfg)
 	if err != nil {
-		errorLog.Println(err)
-		return
+		return fmt.Errorf("cannot connect to smtp server %s:%d: %w", cfg.Host, cfg.Port, err)
+	}
+	return client.Quit()
+}
+
+//sendMailMsg sends one mail through the smtp server in cfg
+func sendMailMsg(cfg config.SMTPConfig, m models.MailData) error {
+	client, err := connectSMTP(cfg)
+	if err != nil {
+		return err
+	}
+	from := m.From
+	if from == "" {
+		from = cfg.From
 	}
 	email := mail.NewMSG()
-	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
-	if m.Template == "" {
+	email.SetFrom(from).AddTo(m.To).SetSubject(m.Subject)
+	if m.PlainContent == "" {
 		email.SetBody(mail.TextHTML, m.Content)
 	} else {
-		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
-		if err != nil {
-			app.ErrorLog.Println(err)
-		}
-
-		mailTemplate := string(data)
-		msgToSend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
-		email.SetBody(mail.TextHTML, msgToSend)
+		// multipart/alternative, mail clients pick the html part if they can show it
+		email.SetBody(mail.TextPlain, m.PlainContent)
+		email.AddAlternative(mail.TextHTML, m.Content)
 	}
 
-	err = email.Send(client)
-	if err != nil {
-		errorLog.Println(err)
-		return
-	} else {
-		errorLog.Println("Email Sent!")
-	}
+	return email.Send(client)
 }
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakeSMTPServer is a tiny smtp server that keeps what it receives, or rejects every recipient
+type fakeSMTPServer struct {
+	listener net.Listener
+	reject   bool
+
+	mu       sync.Mutex
+	messages []string
+}
+
+//newFakeSMTPServer starts a fake smtp server on a free local port
+func newFakeSMTPServer(t *testing.T, reject bool) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSMTPServer{listener: l, reject: reject}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = l.Close() })
+	return s
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) config() config.SMTPConfig {
+	return config.SMTPConfig{
+		Host:       "127.0.0.1",
+		Port:       s.port(),
+		Encryption: config.SMTPEncryptionNone,
+		From:       "me@here.com",
+	}
+}
+
+func (s *fakeSMTPServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost fake smtp")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT"):
+			if s.reject {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 ok")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var msg strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				msg.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, msg.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			// MAIL, RSET, NOOP
+			reply("250 ok")
+		}
+	}
+}
+
+//memoryOutbox is an outbox that lives in memory
+type memoryOutbox struct {
+	mu    sync.Mutex
+	mails []models.OutboxMail
+}
+
+func (o *memoryOutbox) InsertOutboxMail(m models.MailData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.mails = append(o.mails, models.OutboxMail{
+		ID:            len(o.mails) + 1,
+		Mail:          m,
+		Status:        models.OutboxPending,
+		NextAttemptAt: time.Now(),
+	})
+	return nil
+}
+
+func (o *memoryOutbox) GetDueOutboxMails(limit int) ([]models.OutboxMail, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var due []models.OutboxMail
+	for _, m := range o.mails {
+		if m.Status == models.OutboxPending && !m.NextAttemptAt.After(time.Now()) && len(due) < limit {
+			due = append(due, m)
+		}
+	}
+	return due, nil
+}
+
+func (o *memoryOutbox) UpdateOutboxMail(m models.OutboxMail) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i := range o.mails {
+		if o.mails[i].ID == m.ID {
+			o.mails[i] = m
+			return nil
+		}
+	}
+	return errors.New("no such mail")
+}
+
+func (o *memoryOutbox) get(id int) models.OutboxMail {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.mails[id-1]
+}
+
+var testMail = models.MailData{
+	To:           "john@smith.com",
+	Subject:      "Reservation Confirmation!",
+	Content:      "<strong>Hello</strong>",
+	PlainContent: "Hello",
+}
+
+func TestMailWorker_DeliverDue(t *testing.T) {
+	smtp := newFakeSMTPServer(t, false)
+	store := &memoryOutbox{}
+	_ = store.InsertOutboxMail(testMail)
+
+	w := newMailWorker(store, smtp.config())
+	w.deliverDue()
+
+	m := store.get(1)
+	if m.Status != models.OutboxSent {
+		t.Errorf("expected mail to be sent but it is %s (%s)", m.Status, m.LastError)
+	}
+	if m.SentAt.IsZero() {
+		t.Error("expected sent at to be set")
+	}
+	got := smtp.received()
+	if len(got) != 1 {
+		t.Fatalf("expected the smtp server to get 1 message, got %d", len(got))
+	}
+	if !strings.Contains(got[0], "Subject: Reservation Confirmation!") {
+		t.Errorf("expected the subject in the message, got %s", got[0])
+	}
+	if !strings.Contains(got[0], "From: <me@here.com>") {
+		t.Errorf("expected the default sender in the message, got %s", got[0])
+	}
+	if !strings.Contains(got[0], "multipart/alternative") {
+		t.Errorf("expected a text and an html part, got %s", got[0])
+	}
+}
+
+func TestMailWorker_RetriesThenDies(t *testing.T) {
+	smtp := newFakeSMTPServer(t, true)
+	store := &memoryOutbox{}
+	_ = store.InsertOutboxMail(testMail)
+
+	w := newMailWorker(store, smtp.config())
+	w.maxAttempts = 3
+	// no waiting between attempts, so every deliverDue tries again
+	w.baseBackoff = 0
+
+	for i := 1; i <= w.maxAttempts; i++ {
+		w.deliverDue()
+		m := store.get(1)
+		if m.Attempts != i {
+			t.Fatalf("expected %d attempts, got %d", i, m.Attempts)
+		}
+		if m.LastError == "" {
+			t.Error("expected the smtp error to be kept")
+		}
+	}
+	if m := store.get(1); m.Status != models.OutboxDead {
+		t.Errorf("expected mail to be dead after %d attempts, got %s", w.maxAttempts, m.Status)
+	}
+
+	// dead mails are left alone
+	w.deliverDue()
+	if m := store.get(1); m.Attempts != w.maxAttempts {
+		t.Errorf("expected dead mail not to be tried again, got %d attempts", m.Attempts)
+	}
+	if len(smtp.received()) != 0 {
+		t.Error("expected nothing to be delivered")
+	}
+}
+
+func TestMailWorker_SMTPDown(t *testing.T) {
+	// grab a free port and close it again, so nobody listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(strings.Split(l.Addr().String(), ":")[1])
+	_ = l.Close()
+
+	store := &memoryOutbox{}
+	_ = store.InsertOutboxMail(testMail)
+	w := newMailWorker(store, config.SMTPConfig{Host: "127.0.0.1", Port: port})
+	w.deliverDue()
+
+	m := store.get(1)
+	if m.Status != models.OutboxPending {
+		t.Errorf("expected mail to stay pending, got %s", m.Status)
+	}
+	if !m.NextAttemptAt.After(time.Now()) {
+		t.Error("expected the next attempt to be in the future")
+	}
+}
+
+var backoffTests = []struct {
+	attempts int
+	expected time.Duration
+}{
+	{1, 30 * time.Second},
+	{2, time.Minute},
+	{3, 2 * time.Minute},
+	{5, 8 * time.Minute},
+	{20, 2 * time.Hour},
+}
+
+func TestMailWorker_Backoff(t *testing.T) {
+	w := newMailWorker(&memoryOutbox{}, config.SMTPConfig{})
+	for _, e := range backoffTests {
+		if got := w.backoff(e.attempts); got != e.expected {
+			t.Errorf("backoff after %d attempts: expected %s got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+var smtpEncryptionTests = []struct {
+	encryption string
+	expected   mail.Encryption
+	valid      bool
+}{
+	{"", mail.EncryptionNone, true},
+	{config.SMTPEncryptionNone, mail.EncryptionNone, true},
+	{config.SMTPEncryptionSTARTTLS, mail.EncryptionSTARTTLS, true},
+	{config.SMTPEncryptionSSL, mail.EncryptionSSLTLS, true},
+	{"tls1.0", mail.EncryptionNone, false},
+}
+
+func TestSMTPEncryption(t *testing.T) {
+	for _, e := range smtpEncryptionTests {
+		got, err := smtpEncryption(e.encryption)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid to be %t, got error %v", e.encryption, e.valid, err)
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %s got %s", e.encryption, e.expected, got)
+		}
+	}
+}
+
+func TestCheckSMTP(t *testing.T) {
+	smtp := newFakeSMTPServer(t, false)
+	if err := checkSMTP(smtp.config()); err != nil {
+		t.Errorf("expected smtp check to pass, got %s", err)
+	}
+
+	cfg := smtp.config()
+	cfg.Encryption = "fish"
+	if err := checkSMTP(cfg); err == nil {
+		t.Error("expected smtp check to fail for unknown encryption")
+	}
+}
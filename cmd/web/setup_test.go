@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"os"
 	"testing"
@@ -12,6 +13,7 @@ import (
 func TestMain(m *testing.M) {
 
 	// do something run the test then exit
+	errorLog = log.New(os.Stdout, "Error:\t", log.Ldate|log.Ltime|log.Lshortfile)
 	os.Exit(m.Run())
 }
 
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+//shutdownTimeout is how long each phase of the shutdown may take
+const shutdownTimeout = 30 * time.Second
+
+//serve runs srv until we get SIGINT or SIGTERM, then shuts everything down in order
+func serve(srv *http.Server, w *mailWorker, sw *icalSyncWorker, pw *trashPurgeWorker, db io.Closer) error {
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	select {
+	case err := <-serverErr:
+		// the server did not even start (port in use...)
+		return err
+	case sig := <-quit:
+		log.Printf("Got %s, shutting down....", sig)
+	}
+	return shutdown(srv, w, sw, pw, db, shutdownTimeout)
+}
+
+//shutdown stops the application in this order, so nothing gets lost:
+//  1. stop accepting requests and wait for the ones in flight, they may still queue mail
+//  2. stop the calendar sync, a sync in progress is cancelled and rolled back, and the trash purge
+//  3. close app.MailChan, wait until the listener put everything in the outbox and the worker stopped.
+//     if requests are still running after timeout the channel stays open, they would panic sending on it
+//  4. close the database
+func shutdown(srv *http.Server, w *mailWorker, sw *icalSyncWorker, pw *trashPurgeWorker, db io.Closer, timeout time.Duration) error {
+	var errs []error
+
+	log.Println("Shutdown: waiting for requests in flight....")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	requestsDone := true
+	if err := srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http server: %w", err))
+		requestsDone = false
+	}
+	log.Println("Shutdown: http server stopped")
+
+	log.Println("Shutdown: stopping the calendar sync....")
+	close(sw.quit)
+	select {
+	case <-sw.done:
+		log.Println("Shutdown: calendar sync stopped")
+	case <-time.After(timeout):
+		errs = append(errs, errors.New("calendar sync: timed out"))
+	}
+	close(pw.quit)
+	select {
+	case <-pw.done:
+		log.Println("Shutdown: trash purge stopped")
+	case <-time.After(timeout):
+		errs = append(errs, errors.New("trash purge: timed out"))
+	}
+
+	if requestsDone {
+		log.Println("Shutdown: flushing queued mail to the outbox....")
+		close(app.MailChan)
+		select {
+		case <-w.done:
+			log.Println("Shutdown: mail listener stopped")
+		case <-time.After(timeout):
+			errs = append(errs, errors.New("mail listener: timed out"))
+		}
+	} else {
+		log.Println("Shutdown: requests are still running, mail they queue from now on may be lost")
+	}
+
+	log.Println("Shutdown: closing database....")
+	if err := db.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("database: %w", err))
+	}
+	log.Println("Shutdown: done")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown: %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/majedutd990/bookings/internal/models"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//fakeDB only remembers that it was closed
+type fakeDB struct {
+	closed bool
+}
+
+func (db *fakeDB) Close() error {
+	db.closed = true
+	return nil
+}
+
+func TestShutdown(t *testing.T) {
+	smtp := newFakeSMTPServer(t, false)
+	store := &memoryOutbox{}
+	app.MailChan = make(chan models.MailData)
+	mw := newMailWorker(store, smtp.config())
+	listenForMail(mw)
+
+	started := make(chan struct{})
+	// a booking that is still running when we shut down, it queues its mail at the very end
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		app.MailChan <- testMail
+		w.WriteHeader(http.StatusOK)
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	respCode := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err != nil {
+			respCode <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		respCode <- resp.StatusCode
+	}()
+	<-started
+
+	// a calendar sync that only ends when it is cancelled
+	syncer := &fakeSyncer{block: true, calls: make(chan struct{}, 1)}
+	sw := newICalSyncWorker(syncer, time.Hour)
+	sw.start()
+	<-syncer.calls
+
+	pw := newTrashPurgeWorker(&fakePurger{}, 30, time.Hour)
+	pw.start()
+
+	db := &fakeDB{}
+	err = shutdown(srv, mw, sw, pw, db, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := <-respCode; code != http.StatusOK {
+		t.Errorf("expected the request in flight to finish with 200, got %d", code)
+	}
+	if len(store.mails) != 1 {
+		t.Errorf("expected the mail of the request in flight in the outbox, got %d mails", len(store.mails))
+	}
+	select {
+	case <-mw.done:
+	default:
+		t.Error("expected the mail worker to be stopped")
+	}
+	select {
+	case <-sw.done:
+	default:
+		t.Error("expected the calendar sync to be stopped")
+	}
+	select {
+	case <-pw.done:
+	default:
+		t.Error("expected the trash purge to be stopped")
+	}
+	if !db.closed {
+		t.Error("expected the database to be closed")
+	}
+}
+
+func TestShutdown_RequestsStillRunning(t *testing.T) {
+	smtp := newFakeSMTPServer(t, false)
+	store := &memoryOutbox{}
+	app.MailChan = make(chan models.MailData)
+	mw := newMailWorker(store, smtp.config())
+	listenForMail(mw)
+
+	started := make(chan struct{})
+	sent := make(chan struct{})
+	// a request that outlives the shutdown timeout and queues mail afterwards
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(300 * time.Millisecond)
+		app.MailChan <- testMail
+		close(sent)
+	})}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	go func() {
+		resp, err := http.Get("http://" + l.Addr().String())
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+
+	sw := newICalSyncWorker(&fakeSyncer{calls: make(chan struct{}, 1)}, time.Hour)
+	sw.start()
+	pw := newTrashPurgeWorker(&fakePurger{}, 30, time.Hour)
+	pw.start()
+
+	err = shutdown(srv, mw, sw, pw, &fakeDB{}, 50*time.Millisecond)
+	if err == nil {
+		t.Error("expected the shutdown to report the request still running")
+	}
+	// sending on a closed channel would panic the request
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the request to queue its mail")
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//trashPurgeInterval is how often the trash is checked for reservations that were in it long enough
+const trashPurgeInterval = time.Hour
+
+//trashPurger is what the worker needs of the repository
+type trashPurger interface {
+	PurgeDeletedReservations(before time.Time) ([]int, []int, error)
+}
+
+//trashPurgeWorker removes reservations that are in the trash for more than days days,
+// every interval, the first time right after it started
+type trashPurgeWorker struct {
+	repo     trashPurger
+	days     int
+	interval time.Duration
+	// quit stops the worker, done is closed once the worker is finished
+	quit chan struct{}
+	done chan struct{}
+}
+
+//newTrashPurgeWorker creates the worker, with 0 days it never purges
+func newTrashPurgeWorker(repo trashPurger, days int, interval time.Duration) *trashPurgeWorker {
+	return &trashPurgeWorker{
+		repo:     repo,
+		days:     days,
+		interval: interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+//start runs the worker in the background
+func (w *trashPurgeWorker) start() {
+	if w.days <= 0 || w.interval <= 0 {
+		close(w.done)
+		return
+	}
+	go w.run()
+}
+
+//run purges until the worker is stopped
+func (w *trashPurgeWorker) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.purge()
+		select {
+		case <-ticker.C:
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+//purge removes what is in the trash for too long, the repository records it in the audit log.
+// reservations with payments stay in the trash
+func (w *trashPurgeWorker) purge() {
+	ids, kept, err := w.repo.PurgeDeletedReservations(time.Now().AddDate(0, 0, -w.days))
+	if err != nil {
+		log.Printf("Trash: purging failed: %s", err)
+		return
+	}
+	if len(ids) > 0 {
+		log.Printf("Trash: purged %d reservations", len(ids))
+	}
+	if len(kept) > 0 {
+		log.Printf("Trash: kept %d reservations with payments", len(kept))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//fakePurger purges reservation 7 once, keeps reservation 8 with its payments and remembers what it was asked
+type fakePurger struct {
+	mu     sync.Mutex
+	before []time.Time
+}
+
+func (p *fakePurger) PurgeDeletedReservations(before time.Time) ([]int, []int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.before = append(p.before, before)
+	if len(p.before) == 1 {
+		return []int{7}, []int{8}, nil
+	}
+	return nil, []int{8}, nil
+}
+
+//syncBuffer is a log output the worker can write to while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTrashPurgeWorker(t *testing.T) {
+	var out syncBuffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	purger := &fakePurger{}
+	w := newTrashPurgeWorker(purger, 30, 10*time.Millisecond)
+	w.start()
+	time.Sleep(50 * time.Millisecond)
+	close(w.quit)
+	select {
+	case <-w.done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the worker to stop")
+	}
+
+	purger.mu.Lock()
+	defer purger.mu.Unlock()
+	if len(purger.before) < 2 {
+		t.Fatalf("expected a purge right away and every interval, got %d", len(purger.before))
+	}
+	if age := time.Since(purger.before[0]); age < 30*24*time.Hour-time.Minute || age > 30*24*time.Hour+time.Minute {
+		t.Errorf("expected reservations deleted 30 days ago to be purged, got %s", age)
+	}
+	if strings.Count(out.String(), "purged 1 reservations") != 1 {
+		t.Errorf("expected reservation 7 to be purged once, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "kept 1 reservations with payments") {
+		t.Errorf("expected reservation 8 with its payments to stay, got %q", out.String())
+	}
+}
+
+func TestTrashPurgeWorker_Disabled(t *testing.T) {
+	purger := &fakePurger{}
+	w := newTrashPurgeWorker(purger, 0, time.Millisecond)
+	w.start()
+	select {
+	case <-w.done:
+	default:
+		t.Fatal("expected a worker that never purges to be done")
+	}
+	if len(purger.before) != 0 {
+		t.Error("expected no purge")
+	}
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"github.com/alexedwards/scs/v2"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/payments"
 	"html/template"
 	"log"
+	texttemplate "text/template"
 )
 
 //AppConfig Holds the Application config
@@ -16,4 +18,50 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	// Port is the port we listen on
+	Port int
+	// BaseURL is where the site is reachable from outside, used for links in emails
+	BaseURL string
+	DB      DBConfig
+	SMTP    SMTPConfig
+	// MailHTMLCache and MailTextCache hold the parsed mail templates, see the mailer package
+	MailHTMLCache map[string]*template.Template
+	MailTextCache map[string]*texttemplate.Template
+	Payment       PaymentConfig
+	// Payments is the provider guests pay their deposit with, made from Payment at startup
+	Payments payments.Provider
+	// ICalSecret signs the tokens of the calendar feeds, without it there are no feeds
+	ICalSecret string
+	// ICalSyncMinutes is how often the calendars of other channels are synced, 0 never
+	ICalSyncMinutes int
+	// TrashDays is how long deleted reservations stay in the trash before they are purged, 0 forever
+	TrashDays int
+}
+
+//these are the smtp encryption modes we support
+const (
+	SMTPEncryptionNone     = "none"
+	SMTPEncryptionSTARTTLS = "starttls"
+	SMTPEncryptionSSL      = "ssl"
+)
+
+//SMTPConfig holds the settings of the smtp server we send mail through
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Encryption is one of SMTPEncryptionNone, SMTPEncryptionSTARTTLS or SMTPEncryptionSSL
+	Encryption string `yaml:"encryption"`
+	// From is used for mails that don't set a sender themselves
+	From string `yaml:"from"`
+}
+
+//PaymentConfig holds the settings of the payment step of a booking
+type PaymentConfig struct {
+	// Provider is the name of the payment gateway, see payments.New
+	Provider string `yaml:"provider"`
+	// DepositPercent of the total is paid when booking, 0 books without payment
+	DepositPercent int    `yaml:"deposit_percent"`
+	WebhookSecret  string `yaml:"webhook_secret"`
 }
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/majedutd990/bookings/internal/payments"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// settings are loaded in layers, every layer overrides the one before:
+//  1. the defaults below
+//  2. a yaml file (-config or BOOKINGS_CONFIG, config.yml if it exists)
+//  3. environment variables, a .env file in the working directory is read into the environment first
+//  4. command line flags
+
+//DBConfig holds the settings of our postgres database
+type DBConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Name     string `yaml:"name"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// SSL is the postgres sslmode (disable, prefer, require...)
+	SSL string `yaml:"ssl"`
+}
+
+//ConnectionString returns the postgres dsn for these settings
+func (c DBConfig) ConnectionString() string {
+	return fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
+		c.Host, c.Port, c.Name, c.User, c.Password, c.SSL)
+}
+
+//settings is everything we can configure, as it is read from the yaml file
+type settings struct {
+	Port         int           `yaml:"port"`
+	InProduction bool          `yaml:"production"`
+	UseCache     bool          `yaml:"cache"`
+	BaseURL      string        `yaml:"base_url"`
+	DB           DBConfig      `yaml:"database"`
+	SMTP         SMTPConfig    `yaml:"smtp"`
+	Payment      PaymentConfig `yaml:"payment"`
+	ICalSecret   string        `yaml:"ical_secret"`
+	// ICalSyncMinutes is how often the calendars of other channels are synced, 0 never
+	ICalSyncMinutes int `yaml:"ical_sync_minutes"`
+	// TrashDays is how long deleted reservations stay in the trash, 0 forever
+	TrashDays int `yaml:"trash_days"`
+}
+
+//defaultSettings are used for everything that is not configured anywhere
+func defaultSettings() settings {
+	return settings{
+		Port:         8080,
+		InProduction: true,
+		UseCache:     true,
+		BaseURL:      "http://localhost:8080",
+		DB: DBConfig{
+			Host: "localhost",
+			Port: 5432,
+			SSL:  "disable",
+		},
+		SMTP: SMTPConfig{
+			Host:       "localhost",
+			Port:       1025,
+			Encryption: SMTPEncryptionNone,
+			From:       "majedutd@gmail.com",
+		},
+		Payment: PaymentConfig{
+			Provider:       "fake",
+			DepositPercent: 20,
+		},
+		ICalSyncMinutes: 15,
+		TrashDays:       30,
+	}
+}
+
+//defaultConfigFile is read if no config file is given and it exists
+const defaultConfigFile = "config.yml"
+
+//Problems lists everything that is wrong with the configuration
+type Problems []string
+
+func (p Problems) Error() string {
+	return "invalid configuration:\n - " + strings.Join(p, "\n - ")
+}
+
+//Load reads the configuration from all layers into a, args are the command line arguments without the program name.
+// if anything is missing or wrong, all problems are returned together as Problems
+func Load(a *AppConfig, args []string) error {
+	// the flags are parsed twice: first only to find the config file, then on top of file and environment
+	first := defaultSettings()
+	configFile := os.Getenv("BOOKINGS_CONFIG")
+	if err := newFlagSet(&first, &configFile).Parse(args); err != nil {
+		return err
+	}
+
+	s := defaultSettings()
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			configFile = defaultConfigFile
+		}
+	}
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return err
+		}
+		if err = yaml.UnmarshalStrict(data, &s); err != nil {
+			return fmt.Errorf("cannot read config file %s: %w", configFile, err)
+		}
+	}
+
+	// .env does not override variables that are already set
+	err := godotenv.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("cannot read .env: %w", err)
+	}
+	var problems Problems
+	problems = append(problems, applyEnv(&s)...)
+
+	if err := newFlagSet(&s, &configFile).Parse(args); err != nil {
+		return err
+	}
+
+	problems = append(problems, s.validate()...)
+	if len(problems) > 0 {
+		return problems
+	}
+
+	a.Port = s.Port
+	a.InProduction = s.InProduction
+	a.UseCache = s.UseCache
+	a.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	a.DB = s.DB
+	a.SMTP = s.SMTP
+	a.SMTP.Encryption = strings.ToLower(a.SMTP.Encryption)
+	a.Payment = s.Payment
+	a.ICalSecret = s.ICalSecret
+	a.ICalSyncMinutes = s.ICalSyncMinutes
+	a.TrashDays = s.TrashDays
+	return nil
+}
+
+//newFlagSet defines our flags on top of the current settings, so flags that are not given change nothing
+func newFlagSet(s *settings, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	fs.StringVar(configFile, "config", *configFile, "YAML config file")
+	fs.IntVar(&s.Port, "port", s.Port, "Port to listen on")
+	fs.BoolVar(&s.InProduction, "production", s.InProduction, "Application is in production!")
+	fs.BoolVar(&s.UseCache, "cache", s.UseCache, "Use template cache (in production true)!")
+	fs.StringVar(&s.BaseURL, "baseurl", s.BaseURL, "Public URL of the site, used in email links")
+	fs.StringVar(&s.DB.Name, "dbname", s.DB.Name, "DataBase Name")
+	fs.StringVar(&s.DB.Host, "dbhost", s.DB.Host, "DataBase Host")
+	fs.StringVar(&s.DB.User, "dbuser", s.DB.User, "DataBase username")
+	fs.StringVar(&s.DB.Password, "dbpass", s.DB.Password, "DataBase password (better use DB_PASSWORD)")
+	fs.IntVar(&s.DB.Port, "dbport", s.DB.Port, "DataBase port")
+	fs.StringVar(&s.DB.SSL, "dbssl", s.DB.SSL, "DataBase SSL settings (disable, prefer,require)")
+	fs.StringVar(&s.SMTP.Host, "smtphost", s.SMTP.Host, "SMTP host")
+	fs.IntVar(&s.SMTP.Port, "smtpport", s.SMTP.Port, "SMTP port")
+	fs.StringVar(&s.SMTP.Username, "smtpuser", s.SMTP.Username, "SMTP username, leave empty for no authentication")
+	fs.StringVar(&s.SMTP.Password, "smtppass", s.SMTP.Password, "SMTP password (better use SMTP_PASSWORD)")
+	fs.StringVar(&s.SMTP.Encryption, "smtpencryption", s.SMTP.Encryption, "SMTP encryption (none, starttls, ssl)")
+	fs.StringVar(&s.SMTP.From, "mailfrom", s.SMTP.From, "Default sender of our mails")
+	fs.StringVar(&s.Payment.Provider, "paymentprovider", s.Payment.Provider, "Payment provider (fake)")
+	fs.IntVar(&s.Payment.DepositPercent, "deposit", s.Payment.DepositPercent, "Percent of the total paid when booking, 0 for no payment")
+	fs.IntVar(&s.ICalSyncMinutes, "icalsync", s.ICalSyncMinutes, "Minutes between syncs of the calendars of other channels, 0 for never")
+	fs.IntVar(&s.TrashDays, "trashdays", s.TrashDays, "Days deleted reservations stay in the trash, 0 for forever")
+	return fs
+}
+
+//applyEnv overrides the settings with the environment variables that are set
+func applyEnv(s *settings) Problems {
+	var problems Problems
+	envString := func(key string, dst *string) {
+		if v, ok := os.LookupEnv(key); ok {
+			*dst = v
+		}
+	}
+	envInt := func(key string, dst *int) {
+		if v, ok := os.LookupEnv(key); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be a number, got %q", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	envBool := func(key string, dst *bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s must be true or false, got %q", key, v))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	envInt("PORT", &s.Port)
+	envBool("PRODUCTION", &s.InProduction)
+	envBool("USE_CACHE", &s.UseCache)
+	envString("BASE_URL", &s.BaseURL)
+	envString("DB_HOST", &s.DB.Host)
+	envInt("DB_PORT", &s.DB.Port)
+	envString("DB_NAME", &s.DB.Name)
+	envString("DB_USER", &s.DB.User)
+	envString("DB_PASSWORD", &s.DB.Password)
+	envString("DB_SSL", &s.DB.SSL)
+	envString("SMTP_HOST", &s.SMTP.Host)
+	envInt("SMTP_PORT", &s.SMTP.Port)
+	envString("SMTP_USERNAME", &s.SMTP.Username)
+	envString("SMTP_PASSWORD", &s.SMTP.Password)
+	envString("SMTP_ENCRYPTION", &s.SMTP.Encryption)
+	envString("MAIL_FROM", &s.SMTP.From)
+	envString("PAYMENT_PROVIDER", &s.Payment.Provider)
+	envInt("PAYMENT_DEPOSIT_PERCENT", &s.Payment.DepositPercent)
+	envString("PAYMENT_WEBHOOK_SECRET", &s.Payment.WebhookSecret)
+	envString("ICAL_SECRET", &s.ICalSecret)
+	envInt("ICAL_SYNC_MINUTES", &s.ICalSyncMinutes)
+	envInt("TRASH_DAYS", &s.TrashDays)
+	return problems
+}
+
+//validate returns everything that is missing or wrong in the settings
+func (s settings) validate() Problems {
+	var problems Problems
+	if s.DB.Name == "" {
+		problems = append(problems, "database name is required (-dbname, DB_NAME or database.name)")
+	}
+	if s.DB.User == "" {
+		problems = append(problems, "database user is required (-dbuser, DB_USER or database.user)")
+	}
+	if s.Port < 1 || s.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d is not a valid port", s.Port))
+	}
+	if s.DB.Port < 1 || s.DB.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("database port %d is not a valid port", s.DB.Port))
+	}
+	if s.SMTP.Host == "" {
+		problems = append(problems, "smtp host is required (-smtphost, SMTP_HOST or smtp.host)")
+	}
+	if s.SMTP.Port < 1 || s.SMTP.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("smtp port %d is not a valid port", s.SMTP.Port))
+	}
+	switch strings.ToLower(s.SMTP.Encryption) {
+	case SMTPEncryptionNone, SMTPEncryptionSTARTTLS, SMTPEncryptionSSL:
+	default:
+		problems = append(problems, fmt.Sprintf("smtp encryption %q is unknown, use none, starttls or ssl", s.SMTP.Encryption))
+	}
+	if s.SMTP.From == "" {
+		problems = append(problems, "mail sender is required (-mailfrom, MAIL_FROM or smtp.from)")
+	}
+	if !payments.Known(s.Payment.Provider) {
+		problems = append(problems, fmt.Sprintf("payment provider %q is unknown, use fake", s.Payment.Provider))
+	}
+	// the fake provider never charges anybody, in production it would take deposits that don't exist
+	if s.InProduction && s.Payment.Provider == "fake" && s.Payment.DepositPercent > 0 {
+		problems = append(problems, "the fake payment provider can't take deposits in production, "+
+			"set -paymentprovider, PAYMENT_PROVIDER or payment.provider, or -deposit=0 to book without payment")
+	}
+	if s.Payment.DepositPercent < 0 || s.Payment.DepositPercent > 100 {
+		problems = append(problems, fmt.Sprintf("deposit of %d%% must be between 0 and 100", s.Payment.DepositPercent))
+	}
+	if s.ICalSyncMinutes < 0 {
+		problems = append(problems, fmt.Sprintf("ical sync every %d minutes can't be, use 0 to never sync", s.ICalSyncMinutes))
+	}
+	if s.TrashDays < 0 {
+		problems = append(problems, fmt.Sprintf("keeping the trash for %d days can't be, use 0 to keep it forever", s.TrashDays))
+	}
+	if !strings.HasPrefix(s.BaseURL, "http://") && !strings.HasPrefix(s.BaseURL, "https://") {
+		problems = append(problems, fmt.Sprintf("base url %q must start with http:// or https://", s.BaseURL))
+	}
+	return problems
+}
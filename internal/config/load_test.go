@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//inTempDir runs the test in an empty directory, so no config.yml or .env of the developer gets read
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	return dir
+}
+
+func writeFile(t *testing.T, name, content string) {
+	if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	inTempDir(t)
+	var a AppConfig
+	// production is the default, and there the fake payment provider must not take deposits
+	err := Load(&a, []string{"-dbname=bookings", "-dbuser=postgres"})
+	if err == nil || !strings.Contains(err.Error(), "fake payment provider can't take deposits in production") {
+		t.Fatalf("expected the fake provider to be refused in production, got %v", err)
+	}
+	err = Load(&a, []string{"-dbname=bookings", "-dbuser=postgres", "-production=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Port != 8080 || a.InProduction || !a.UseCache {
+		t.Errorf("wrong defaults: port %d production %t cache %t", a.Port, a.InProduction, a.UseCache)
+	}
+	if a.DB.Host != "localhost" || a.DB.Port != 5432 || a.DB.SSL != "disable" {
+		t.Errorf("wrong database defaults: %+v", a.DB)
+	}
+	if a.SMTP.Host != "localhost" || a.SMTP.Port != 1025 || a.SMTP.Encryption != SMTPEncryptionNone {
+		t.Errorf("wrong smtp defaults: %+v", a.SMTP)
+	}
+	if a.Payment.Provider != "fake" || a.Payment.DepositPercent != 20 {
+		t.Errorf("wrong payment defaults: %+v", a.Payment)
+	}
+	if a.ICalSyncMinutes != 15 {
+		t.Errorf("wrong ical sync default: %d", a.ICalSyncMinutes)
+	}
+	if a.TrashDays != 30 {
+		t.Errorf("wrong trash default: %d", a.TrashDays)
+	}
+}
+
+func TestLoad_Layers(t *testing.T) {
+	dir := inTempDir(t)
+	writeFile(t, "bookings.yml", `
+port: 9000
+production: false
+base_url: https://file.example.com/
+database:
+  name: from_file
+  user: file_user
+  password: file_secret
+smtp:
+  host: smtp.file.example.com
+  port: 2525
+`)
+	writeFile(t, ".env", "DB_PASSWORD=dotenv_secret\nSMTP_PORT=587\nDB_USER=dotenv_user\n")
+	t.Setenv("BOOKINGS_CONFIG", filepath.Join(dir, "bookings.yml"))
+	// a real environment variable wins over .env
+	t.Setenv("DB_USER", "env_user")
+	t.Setenv("SMTP_ENCRYPTION", "STARTTLS")
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "hooks")
+	t.Setenv("ICAL_SECRET", "feeds")
+	t.Setenv("ICAL_SYNC_MINUTES", "60")
+	t.Setenv("TRASH_DAYS", "0")
+	t.Cleanup(func() {
+		_ = os.Unsetenv("DB_PASSWORD")
+		_ = os.Unsetenv("SMTP_PORT")
+	})
+
+	var a AppConfig
+	err := Load(&a, []string{"-port=9100", "-dbname=from_flag", "-deposit=0", "-icalsync=5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		got      interface{}
+		expected interface{}
+	}{
+		{"port from flag over file", a.Port, 9100},
+		{"production from file", a.InProduction, false},
+		{"base url from file without slash", a.BaseURL, "https://file.example.com"},
+		{"db name from flag over file", a.DB.Name, "from_flag"},
+		{"db user from env over .env and file", a.DB.User, "env_user"},
+		{"db password from .env over file", a.DB.Password, "dotenv_secret"},
+		{"db host default", a.DB.Host, "localhost"},
+		{"smtp host from file", a.SMTP.Host, "smtp.file.example.com"},
+		{"smtp port from .env over file", a.SMTP.Port, 587},
+		{"smtp encryption from env, lower cased", a.SMTP.Encryption, SMTPEncryptionSTARTTLS},
+		{"deposit from flag", a.Payment.DepositPercent, 0},
+		{"webhook secret from env", a.Payment.WebhookSecret, "hooks"},
+		{"ical secret from env", a.ICalSecret, "feeds"},
+		{"ical sync from flag over env", a.ICalSyncMinutes, 5},
+		{"trash kept forever from env", a.TrashDays, 0},
+	}
+	for _, e := range tests {
+		if e.got != e.expected {
+			t.Errorf("%s: expected %v got %v", e.name, e.expected, e.got)
+		}
+	}
+}
+
+func TestLoad_ReportsAllProblems(t *testing.T) {
+	inTempDir(t)
+	t.Setenv("SMTP_PORT", "fish")
+
+	var a AppConfig
+	err := Load(&a, []string{"-smtpencryption=tls1.0", "-port=0", "-paymentprovider=cash", "-deposit=120", "-icalsync=-1", "-trashdays=-7"})
+	var problems Problems
+	if !errors.As(err, &problems) {
+		t.Fatalf("expected Problems, got %v", err)
+	}
+	for _, expected := range []string{"SMTP_PORT must be a number", "database name is required", "database user is required",
+		"port 0 is not a valid port", `smtp encryption "tls1.0" is unknown`, `payment provider "cash" is unknown`,
+		"deposit of 120% must be between 0 and 100",
+		"ical sync every -1 minutes", "keeping the trash for -7 days"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in %s", expected, err)
+		}
+	}
+	if len(problems) != 9 {
+		t.Errorf("expected 9 problems, got %d: %s", len(problems), err)
+	}
+}
+
+func TestLoad_ProductionWithoutPayment(t *testing.T) {
+	inTempDir(t)
+	var a AppConfig
+	err := Load(&a, []string{"-dbname=bookings", "-dbuser=postgres", "-deposit=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.InProduction || a.Payment.DepositPercent != 0 {
+		t.Errorf("expected production without deposits, got production %t deposit %d", a.InProduction, a.Payment.DepositPercent)
+	}
+}
+
+func TestLoad_BadConfigFile(t *testing.T) {
+	inTempDir(t)
+	writeFile(t, "config.yml", "databse:\n  name: typo\n")
+	var a AppConfig
+	err := Load(&a, []string{"-dbname=bookings", "-dbuser=postgres"})
+	if err == nil {
+		t.Error("expected unknown keys in the config file to fail")
+	}
+
+	err = Load(&a, []string{"-config=missing.yml"})
+	if err == nil {
+		t.Error("expected a missing config file to fail")
+	}
+}
+
+func TestDBConfig_ConnectionString(t *testing.T) {
+	c := DBConfig{Host: "localhost", Port: 5432, Name: "bookings", User: "postgres", Password: "secret", SSL: "disable"}
+	expected := "host=localhost port=5432 dbname=bookings user=postgres password=secret sslmode=disable"
+	if c.ConnectionString() != expected {
+		t.Errorf("expected %s got %s", expected, c.ConnectionString())
+	}
+}
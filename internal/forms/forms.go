@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"github.com/asaskevich/govalidator"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Form struct {
@@ -61,3 +65,64 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+//slugRegexp matches lower case words joined by dashes, like generals-quarters
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+//IsSlug checks that a field can be used in an url, like generals-quarters
+func (f *Form) IsSlug(field string) {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lower case letters, numbers and dashes")
+	}
+}
+
+//colourRegexp matches colours like #17a2b8, what colour inputs send
+var colourRegexp = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+//IsColour checks that a field is a colour like #17a2b8
+func (f *Form) IsColour(field string) {
+	if !colourRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Choose a colour like #17a2b8")
+	}
+}
+
+//IsNumber checks that a field is a whole number of at least min
+func (f *Form) IsNumber(field string, min int) bool {
+	n, err := strconv.Atoi(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This field must be a number")
+		return false
+	}
+	if n < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be at least %d", min))
+		return false
+	}
+	return true
+}
+
+//MinPasswordLength is the shortest password IsPassword takes
+const MinPasswordLength = 10
+
+//maxPasswordBytes is what bcrypt can hash, it ignores or refuses everything after it
+const maxPasswordBytes = 72
+
+//IsPassword checks a new password against our policy: at least MinPasswordLength characters,
+// with letters and with numbers or symbols
+func (f *Form) IsPassword(field string) {
+	x := f.Get(field)
+	if len(x) > maxPasswordBytes {
+		f.Errors.Add(field, fmt.Sprintf("Use at most %d characters", maxPasswordBytes))
+		return
+	}
+	var letters, others bool
+	for _, r := range x {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else if !unicode.IsSpace(r) {
+			others = true
+		}
+	}
+	if utf8.RuneCountInString(x) < MinPasswordLength || !letters || !others {
+		f.Errors.Add(field, fmt.Sprintf("Use at least %d characters, with letters and with numbers or symbols", MinPasswordLength))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -112,3 +113,74 @@ func TestForm_Has(t *testing.T) {
 		t.Error("Has. form has field but return false")
 	}
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	for slug, valid := range map[string]bool{
+		"generals-quarters": true,
+		"room-2":            true,
+		"":                  false,
+		"Generals":          false,
+		"generals--suite":   false,
+		"-generals":         false,
+		"generals quarters": false,
+	} {
+		form := New(url.Values{"slug": {slug}})
+		form.IsSlug("slug")
+		if form.Valid() != valid {
+			t.Errorf("IsSlug(%q): expected valid %t", slug, valid)
+		}
+	}
+}
+
+func TestForm_IsColour(t *testing.T) {
+	for colour, valid := range map[string]bool{
+		"#17a2b8": true,
+		"#FFC107": true,
+		"":        false,
+		"17a2b8":  false,
+		"#17a2b":  false,
+		"red":     false,
+	} {
+		form := New(url.Values{"colour": {colour}})
+		form.IsColour("colour")
+		if form.Valid() != valid {
+			t.Errorf("IsColour(%q): expected valid %t", colour, valid)
+		}
+	}
+}
+
+func TestForm_IsNumber(t *testing.T) {
+	form := New(url.Values{"a": {"3"}, "b": {"0"}, "c": {"fish"}})
+	if !form.IsNumber("a", 1) {
+		t.Error("IsNumber: 3 should be a number of at least 1")
+	}
+	if form.IsNumber("b", 1) {
+		t.Error("IsNumber: 0 should be below 1")
+	}
+	if form.IsNumber("c", 1) {
+		t.Error("IsNumber: fish is not a number")
+	}
+	if form.Errors.Get("c") != "This field must be a number" {
+		t.Error("IsNumber: expected an error for c")
+	}
+}
+
+func TestForm_IsPassword(t *testing.T) {
+	for password, valid := range map[string]bool{
+		"correct-horse":          true,
+		"tr0ub4dor&3":            true,
+		"kürbis-2022":            true,
+		"":                       false,
+		"short-1":                false,
+		"onlyletterswithoutmore": false,
+		"12345678901234":         false,
+		"with spaces only":       false,
+		strings.Repeat("a1", 37): false,
+	} {
+		form := New(url.Values{"password": {password}})
+		form.IsPassword("password")
+		if form.Valid() != valid {
+			t.Errorf("IsPassword(%q): expected valid %t", password, valid)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"github.com/majedutd990/bookings/internal/render"
+	"github.com/majedutd990/bookings/internal/repository"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reservations the front desk books for guests on the phone or at the door, without going through the search
+
+//these are the choices for the confirmation mail of a reservation booked by the front desk
+const (
+	guestMailSend   = "send"
+	guestMailSkip   = "skip"
+	guestMailCustom = "custom"
+)
+
+//AdminBookReservation shows the form to book a reservation for a guest
+func (m *Repository) AdminBookReservation(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminBookReservation(w, r, forms.New(nil))
+}
+
+//renderAdminBookReservation renders the booking form with the rooms that can be booked
+func (m *Repository) renderAdminBookReservation(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.GetActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	render.Template(w, "admin-book-reservation.page.tmpl", r, &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//PostAdminBookReservation books a reservation for a guest. the guest gets the usual confirmation mail,
+// one with a note of the front desk or none at all, and the reservation may be confirmed right away
+func (m *Repository) PostAdminBookReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/admin/reservations/new", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start", "end", "firstName", "lastName")
+	form.MinLength("firstName", 3)
+	guestMail := r.Form.Get("guest_mail")
+	switch guestMail {
+	case "":
+		guestMail = guestMailSend
+	case guestMailSend, guestMailSkip:
+	case guestMailCustom:
+		form.Required("mail_note")
+	default:
+		form.Errors.Add("guest_mail", "Invalid choice")
+	}
+	// walk-ins without an email address don't get a mail
+	if guestMail != guestMailSkip {
+		form.Required("email")
+	}
+	if form.Has("email") {
+		form.IsEmail("email")
+	}
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	}
+	if form.Errors.Get("start") == "" && form.Errors.Get("end") == "" && !endDate.After(startDate) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	var room models.Room
+	if err == nil {
+		room, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	if !form.Valid() {
+		m.renderAdminBookReservation(w, r, form)
+		return
+	}
+
+	q, err := m.quote(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res := models.Reservation{
+		FirstName:  r.Form.Get("firstName"),
+		LastName:   r.Form.Get("lastName"),
+		Email:      r.Form.Get("email"),
+		Phone:      r.Form.Get("phone"),
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     roomID,
+		Room:       room,
+		TotalPrice: q.Total,
+		Status:     models.StatusPending,
+	}
+	// the status is stored with the reservation, a confirmed booking is never pending
+	if r.Form.Get("confirmed") == "1" {
+		res.Status = models.StatusConfirmed
+	}
+	var mails []models.MailData
+	if guestMail != guestMailSkip {
+		note := ""
+		if guestMail == guestMailCustom {
+			note = strings.TrimSpace(r.Form.Get("mail_note"))
+		}
+		mail, err := m.guestConfirmation(&res, note)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		mails = append(mails, mail)
+	}
+
+	restrictionID, err := m.restrictionID(models.RestrictionReservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.ID, err = m.DB.InsertReservationWithRestriction(res, restrictionID, helpers.UserID(r),
+		auditEntry(r, models.AuditCreate, models.AuditReservation, 0, nil, reservationAudit(res)), mails...)
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			form.Errors.Add("start", "The room is not available for these dates")
+			m.renderAdminBookReservation(w, r, form)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation booked for %s!", pricing.Format(q.Total)))
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/new/%d/show", res.ID), http.StatusSeeOther)
+}
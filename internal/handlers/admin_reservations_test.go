@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//bookingForm is a valid booking of the front desk, changed by the given values
+func bookingForm(changes url.Values) url.Values {
+	v := url.Values{
+		"room_id":   {"1"},
+		"start":     {"2040-01-02"},
+		"end":       {"2040-01-04"},
+		"firstName": {"John"},
+		"lastName":  {"Smith"},
+		"email":     {"john@smith.com"},
+		"phone":     {"555"},
+	}
+	for k, vals := range changes {
+		v[k] = vals
+	}
+	return v
+}
+
+var postAdminBookReservationTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{"book", bookingForm(nil), http.StatusSeeOther, "/admin/reservations/new/1/show", ""},
+	{"book confirmed", bookingForm(url.Values{"confirmed": {"1"}}), http.StatusSeeOther, "/admin/reservations/new/1/show", ""},
+	{"with a note", bookingForm(url.Values{"guest_mail": {"custom"}, "mail_note": {"Parking is behind the house."}}), http.StatusSeeOther, "/admin/reservations/new/1/show", ""},
+	{"walk-in without email", bookingForm(url.Values{"guest_mail": {"skip"}, "email": {""}}), http.StatusSeeOther, "/admin/reservations/new/1/show", ""},
+	{"mail without email", bookingForm(url.Values{"email": {""}}), http.StatusOK, "", "This field cannot be blank!"},
+	{"note missing", bookingForm(url.Values{"guest_mail": {"custom"}}), http.StatusOK, "", "This field cannot be blank!"},
+	{"unknown mail choice", bookingForm(url.Values{"guest_mail": {"pigeon"}}), http.StatusOK, "", "Invalid choice"},
+	{"invalid email", bookingForm(url.Values{"guest_mail": {"skip"}, "email": {"john"}}), http.StatusOK, "", "Invalid email address"},
+	{"departure before arrival", bookingForm(url.Values{"end": {"2040-01-01"}}), http.StatusOK, "", "Departure must be after arrival"},
+	{"invalid date", bookingForm(url.Values{"start": {"soon"}}), http.StatusOK, "", "Invalid date"},
+	{"no such room", bookingForm(url.Values{"room_id": {"5"}}), http.StatusOK, "", "Choose a room"},
+	{"room taken", bookingForm(url.Values{"room_id": {"2"}}), http.StatusOK, "", "The room is not available for these dates"},
+}
+
+func TestRepository_PostAdminBookReservation(t *testing.T) {
+	for _, e := range postAdminBookReservationTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/new", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostAdminBookReservation, req, nil)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+			if session.GetString(ctx, "flash") != "Reservation booked for $200.00!" {
+				t.Errorf("%s: expected flash got %q", e.name, session.GetString(ctx, "flash"))
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminBookReservation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/new", nil)
+	rr, _ := serveRoomRequest(Repo.AdminBookReservation, req, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "Major&#39;s Suite") {
+		t.Error("expected the rooms in the form")
+	}
+}
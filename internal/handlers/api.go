@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/repository"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// this file holds the versioned json api (/api/v1) used by our partners
+// it does not use sessions or csrf tokens, everything goes in and out as json
+
+//apiDateLayout is the date format the api accepts and returns
+const apiDateLayout = "2006-01-02"
+
+//apiRoom is how a room looks like in the api
+type apiRoom struct {
+	ID           int    `json:"id"`
+	RoomName     string `json:"room_name"`
+	Slug         string `json:"slug,omitempty"`
+	MaxOccupancy int    `json:"max_occupancy,omitempty"`
+}
+
+//apiReservation is how a reservation looks like in the api.
+// the total price is in cents, we quote it ourselves and ignore it in requests
+type apiReservation struct {
+	ID         int     `json:"id"`
+	FirstName  string  `json:"first_name"`
+	LastName   string  `json:"last_name"`
+	Email      string  `json:"email"`
+	Phone      string  `json:"phone"`
+	StartDate  string  `json:"start_date"`
+	EndDate    string  `json:"end_date"`
+	RoomID     int     `json:"room_id"`
+	Room       apiRoom `json:"room"`
+	Status     string  `json:"status"`
+	TotalPrice int     `json:"total_price"`
+}
+
+//apiError is the body of every failed api call
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+func newAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:           room.ID,
+		RoomName:     room.RoomName,
+		Slug:         room.Slug,
+		MaxOccupancy: room.MaxOccupancy,
+	}
+}
+
+func newAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:         res.ID,
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		StartDate:  res.StartDate.Format(apiDateLayout),
+		EndDate:    res.EndDate.Format(apiDateLayout),
+		RoomID:     res.RoomID,
+		Room:       newAPIRoom(res.Room),
+		Status:     res.Status,
+		TotalPrice: res.TotalPrice,
+	}
+}
+
+//writeJSON writes any value as json with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "     ")
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
+
+//writeJSONError writes a structured json error
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: apiErrorDetail{Status: status, Message: message}})
+}
+
+//writeJSONFormError writes the validation errors of a form as a structured json error
+func writeJSONFormError(w http.ResponseWriter, form *forms.Form) {
+	writeJSON(w, http.StatusUnprocessableEntity, apiError{Error: apiErrorDetail{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "invalid data",
+		Fields:  form.Errors,
+	}})
+}
+
+//writeJSONDBError turns a database error into not found or internal server error
+func (m *Repository) writeJSONDBError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	m.App.ErrorLog.Println(err)
+	writeJSONError(w, http.StatusInternalServerError, "internal server error")
+}
+
+//reservationForm validates the guest details of an api reservation with the same rules as the web forms
+func reservationForm(in apiReservation) *forms.Form {
+	form := forms.New(url.Values{
+		"firstName": {in.FirstName},
+		"lastName":  {in.LastName},
+		"email":     {in.Email},
+		"phone":     {in.Phone},
+	})
+	form.Required("firstName", "lastName", "email")
+	form.MinLength("firstName", 3)
+	form.IsEmail("email")
+	return form
+}
+
+//apiReservationID reads the reservation id from the url
+func apiReservationID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid reservation id")
+		return 0, false
+	}
+	return id, true
+}
+
+//APIRooms lists the rooms that are on the site
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.GetActiveRooms()
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	out := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+//APIAvailability searches availability, for all rooms or for one room when room_id is given
+// query: ?start=2050-01-01&end=2050-01-05[&room_id=1]
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	startDate, err := time.Parse(apiDateLayout, r.URL.Query().Get("start"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid start date")
+		return
+	}
+	endDate, err := time.Parse(apiDateLayout, r.URL.Query().Get("end"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid end date")
+		return
+	}
+	if !endDate.After(startDate) {
+		writeJSONError(w, http.StatusBadRequest, "end date must be after start date")
+		return
+	}
+
+	if r.URL.Query().Get("room_id") != "" {
+		roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid room id")
+			return
+		}
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+		if err != nil {
+			m.writeJSONDBError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"room_id":    roomID,
+			"start_date": startDate.Format(apiDateLayout),
+			"end_date":   endDate.Format(apiDateLayout),
+			"available":  available,
+		})
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	out := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		out = append(out, newAPIRoom(room))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"start_date": startDate.Format(apiDateLayout),
+		"end_date":   endDate.Format(apiDateLayout),
+		"rooms":      out,
+	})
+}
+
+//APICreateReservation creates a reservation from a json body. the api takes no payments, so while guests pay
+// a deposit on the website (see PostReservationPayment) only token users who may book for guests can create
+// reservations here, like the front desk they settle the payment with the guest themselves
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var in apiReservation
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	startDate, err := time.Parse(apiDateLayout, in.StartDate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid start date")
+		return
+	}
+	endDate, err := time.Parse(apiDateLayout, in.EndDate)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid end date")
+		return
+	}
+	if !endDate.After(startDate) {
+		writeJSONError(w, http.StatusBadRequest, "end date must be after start date")
+		return
+	}
+	form := reservationForm(in)
+	if !form.Valid() {
+		writeJSONFormError(w, form)
+		return
+	}
+	room, err := m.DB.GetRoomByID(in.RoomID)
+	if err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, "no such room")
+		return
+	}
+
+	q, err := m.quote(in.RoomID, startDate, endDate)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName:  in.FirstName,
+		LastName:   in.LastName,
+		Email:      in.Email,
+		Phone:      in.Phone,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     in.RoomID,
+		Room:       room,
+		TotalPrice: q.Total,
+	}
+	if m.deposit(res) > 0 {
+		user, ok := helpers.AuthUserFromContext(r.Context())
+		if !ok || !models.Can(user.AccessLevel, "reservations.edit") {
+			writeJSONError(w, http.StatusPaymentRequired, "bookings need a deposit, book on the website")
+			return
+		}
+	}
+	restrictionID, err := m.restrictionID(models.RestrictionReservation)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	res.Status = models.StatusPending
+	// like on the website the guest gets the confirmation with the link to manage the booking
+	mails, err := m.bookingMails(&res)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	res.ID, err = m.DB.InsertReservationWithRestriction(res, restrictionID, helpers.UserID(r),
+		auditEntry(r, models.AuditCreate, models.AuditReservation, 0, nil, reservationAudit(res)), mails...)
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			writeJSONError(w, http.StatusConflict, notAvailable.Error())
+			return
+		}
+		m.writeJSONDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPIReservation(res))
+}
+
+//APIGetReservation returns one reservation by id
+func (m *Repository) APIGetReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiReservationID(w, r)
+	if !ok {
+		return
+	}
+	res, err := m.DB.GetReservationById(id)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+//APIUpdateReservation updates the guest details of a reservation
+func (m *Repository) APIUpdateReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiReservationID(w, r)
+	if !ok {
+		return
+	}
+	var in apiReservation
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	res, err := m.DB.GetReservationById(id)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	form := reservationForm(in)
+	if !form.Valid() {
+		writeJSONFormError(w, form)
+		return
+	}
+	before := reservationAudit(res)
+	res.FirstName = in.FirstName
+	res.LastName = in.LastName
+	res.Email = in.Email
+	res.Phone = in.Phone
+	err = m.DB.UpdateReservation(res, auditEntry(r, models.AuditUpdate, models.AuditReservation, res.ID, before, reservationAudit(res)))
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIReservation(res))
+}
+
+//APICancelReservation cancels a reservation, which also frees its room restriction
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := apiReservationID(w, r)
+	if !ok {
+		return
+	}
+	res, err := m.DB.GetReservationById(id)
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	if !models.CanTransition(res.Status, models.StatusCancelled) {
+		writeJSONError(w, http.StatusConflict, "this reservation can't be cancelled anymore")
+		return
+	}
+	err = m.DB.ChangeReservationStatus(id, res.Status, models.StatusCancelled, helpers.UserID(r), auditEntry(r, models.AuditStatus,
+		models.AuditReservation, id, map[string]string{"status": res.Status}, map[string]string{"status": models.StatusCancelled}))
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusConflict, "the reservation was changed meanwhile, try again")
+		return
+	}
+	if err != nil {
+		m.writeJSONDBError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//APINotFound answers unknown api routes with json instead of the html 404 page
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusNotFound, "not found")
+}
+
+//APIMethodNotAllowed answers wrong http methods on api routes with json
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+//APIUnauthorized answers requests without a valid api token
+func (m *Repository) APIUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="bookings"`)
+	writeJSONError(w, http.StatusUnauthorized, "a valid api token is required")
+}
+
+//APIForbidden answers requests whose api token has a too low access level
+func (m *Repository) APIForbidden(w http.ResponseWriter, r *http.Request) {
+	writeJSONError(w, http.StatusForbidden, "your access level does not allow this")
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var apiTests = []struct {
+	name               string
+	method             string
+	url                string
+	body               string
+	accessLevel        int
+	expectedStatusCode int
+	expectedError      string
+}{
+	{
+		name:               "list rooms",
+		method:             "GET",
+		url:                "/api/v1/rooms",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "availability all rooms",
+		method:             "GET",
+		url:                "/api/v1/availability?start=2040-01-01&end=2040-01-02",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "availability one room",
+		method:             "GET",
+		url:                "/api/v1/availability?start=2040-01-01&end=2040-01-02&room_id=1",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "availability invalid start date",
+		method:             "GET",
+		url:                "/api/v1/availability?start=invalid&end=2040-01-02",
+		expectedStatusCode: http.StatusBadRequest,
+		expectedError:      "invalid start date",
+	},
+	{
+		name:               "availability end before start",
+		method:             "GET",
+		url:                "/api/v1/availability?start=2040-01-05&end=2040-01-02",
+		expectedStatusCode: http.StatusBadRequest,
+		expectedError:      "end date must be after start date",
+	},
+	{
+		name:               "availability db fails",
+		method:             "GET",
+		url:                "/api/v1/availability?start=2060-01-01&end=2060-01-02",
+		expectedStatusCode: http.StatusInternalServerError,
+		expectedError:      "internal server error",
+	},
+	{
+		name:        "create reservation",
+		method:      "POST",
+		url:         "/api/v1/reservations",
+		accessLevel: models.AccessLevelFrontDesk,
+		body: `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":1}`,
+		expectedStatusCode: http.StatusCreated,
+	},
+	{
+		name:               "create reservation invalid json",
+		method:             "POST",
+		url:                "/api/v1/reservations",
+		accessLevel:        models.AccessLevelFrontDesk,
+		body:               `{"first_name":`,
+		expectedStatusCode: http.StatusBadRequest,
+		expectedError:      "invalid json body",
+	},
+	{
+		name:        "create reservation invalid guest",
+		method:      "POST",
+		url:         "/api/v1/reservations",
+		accessLevel: models.AccessLevelFrontDesk,
+		body: `{"first_name":"J","last_name":"Smith","email":"john",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":1}`,
+		expectedStatusCode: http.StatusUnprocessableEntity,
+		expectedError:      "invalid data",
+	},
+	{
+		name:        "create reservation no such room",
+		method:      "POST",
+		url:         "/api/v1/reservations",
+		accessLevel: models.AccessLevelFrontDesk,
+		body: `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":5}`,
+		expectedStatusCode: http.StatusUnprocessableEntity,
+		expectedError:      "no such room",
+	},
+	{
+		name:        "create reservation room taken",
+		method:      "POST",
+		url:         "/api/v1/reservations",
+		accessLevel: models.AccessLevelFrontDesk,
+		body: `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":2}`,
+		expectedStatusCode: http.StatusConflict,
+	},
+	{
+		name:   "create reservation without token",
+		method: "POST",
+		url:    "/api/v1/reservations",
+		body: `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":1}`,
+		expectedStatusCode: http.StatusPaymentRequired,
+		expectedError:      "bookings need a deposit, book on the website",
+	},
+	{
+		name:   "create reservation as viewer",
+		method: "POST",
+		url:    "/api/v1/reservations",
+		body: `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+				"start_date":"2050-01-01","end_date":"2050-01-02","room_id":1}`,
+		accessLevel:        models.AccessLevelViewer,
+		expectedStatusCode: http.StatusPaymentRequired,
+		expectedError:      "bookings need a deposit, book on the website",
+	},
+	{
+		name:               "get reservation",
+		method:             "GET",
+		url:                "/api/v1/reservations/1",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "get reservation not found",
+		method:             "GET",
+		url:                "/api/v1/reservations/1001",
+		expectedStatusCode: http.StatusNotFound,
+		expectedError:      "not found",
+	},
+	{
+		name:               "get reservation invalid id",
+		method:             "GET",
+		url:                "/api/v1/reservations/fish",
+		expectedStatusCode: http.StatusBadRequest,
+		expectedError:      "invalid reservation id",
+	},
+	{
+		name:               "update reservation",
+		method:             "PUT",
+		url:                "/api/v1/reservations/1",
+		body:               `{"first_name":"John","last_name":"Smith","email":"john@smith.com","phone":"555"}`,
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "update reservation invalid guest",
+		method:             "PUT",
+		url:                "/api/v1/reservations/1",
+		body:               `{"first_name":"John","last_name":"","email":"john@smith.com"}`,
+		expectedStatusCode: http.StatusUnprocessableEntity,
+		expectedError:      "invalid data",
+	},
+	{
+		name:               "update reservation deleted in the meantime",
+		method:             "PUT",
+		url:                "/api/v1/reservations/1000",
+		body:               `{"first_name":"John","last_name":"Smith","email":"john@smith.com","phone":"555"}`,
+		expectedStatusCode: http.StatusNotFound,
+		expectedError:      "not found",
+	},
+	{
+		name:               "cancel reservation",
+		method:             "DELETE",
+		url:                "/api/v1/reservations/1",
+		expectedStatusCode: http.StatusNoContent,
+	},
+	{
+		name:               "cancel reservation that is checked out",
+		method:             "DELETE",
+		url:                "/api/v1/reservations/3",
+		expectedStatusCode: http.StatusConflict,
+		expectedError:      "this reservation can't be cancelled anymore",
+	},
+	{
+		name:               "cancel reservation not found",
+		method:             "DELETE",
+		url:                "/api/v1/reservations/1001",
+		expectedStatusCode: http.StatusNotFound,
+		expectedError:      "not found",
+	},
+	{
+		name:               "unknown api route",
+		method:             "GET",
+		url:                "/api/v1/green/eggs",
+		expectedStatusCode: http.StatusNotFound,
+		expectedError:      "not found",
+	},
+	{
+		name:               "wrong method",
+		method:             "PATCH",
+		url:                "/api/v1/rooms",
+		expectedStatusCode: http.StatusMethodNotAllowed,
+		expectedError:      "method not allowed",
+	},
+}
+
+//TestAPI tests the json api through the router
+func TestAPI(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range apiTests {
+		req := httptest.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		if e.accessLevel > 0 {
+			req = req.WithContext(helpers.WithAuthUser(req.Context(), helpers.AuthUser{ID: 1, AccessLevel: e.accessLevel}))
+		}
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("API %s: expected code %d got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusNoContent {
+			continue
+		}
+		if rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("API %s: expected json but got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+		if e.expectedError != "" {
+			var j apiError
+			err := json.Unmarshal(rr.Body.Bytes(), &j)
+			if err != nil {
+				t.Errorf("API %s: failed to parse json error: %s", e.name, err)
+				continue
+			}
+			if j.Error.Message != e.expectedError {
+				t.Errorf("API %s: expected error %q got %q", e.name, e.expectedError, j.Error.Message)
+			}
+			if j.Error.Status != e.expectedStatusCode {
+				t.Errorf("API %s: expected status %d in body got %d", e.name, e.expectedStatusCode, j.Error.Status)
+			}
+		}
+	}
+}
+
+func TestAPI_CreateReservationTotalPrice(t *testing.T) {
+	routes := getRoutes()
+	// 2050-01-01 is a saturday, then two week nights
+	body := `{"first_name":"John","last_name":"Smith","email":"john@smith.com",
+		"start_date":"2050-01-01","end_date":"2050-01-04","room_id":1,"total_price":1}`
+	req := httptest.NewRequest("POST", "/api/v1/reservations", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(helpers.WithAuthUser(req.Context(), helpers.AuthUser{ID: 1, AccessLevel: models.AccessLevelFrontDesk}))
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, req)
+
+	var res apiReservation
+	err := json.Unmarshal(rr.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatalf("failed to parse json: %s", err)
+	}
+	if res.TotalPrice != 32000 {
+		t.Errorf("expected total price 32000 got %d", res.TotalPrice)
+	}
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the audit log records who changed what in the admin area. before and after are json snapshots of the
+// thing, only with the fields worth reading, never with token hashes
+
+//auditEntry is the audit log entry of an action of the logged-in user, the repository writes it in the
+// transaction of the change. before is nil for creates, after is nil for deletes
+func auditEntry(r *http.Request, action, entity string, entityID int, before, after interface{}) *models.AuditEntry {
+	return &models.AuditEntry{
+		UserID:   helpers.UserID(r),
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Before:   auditJSON(before),
+		After:    auditJSON(after),
+		IP:       requestIP(r),
+	}
+}
+
+//auditJSON is v as json, "" for nil. the snapshots are plain maps and structs, should one not marshal
+// anyway the entry keeps it printed instead of failing the action
+func auditJSON(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return string(b)
+}
+
+//requestIP is the ip of the client without the port
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//reservationAudit is what the audit log keeps of a reservation
+func reservationAudit(res models.Reservation) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name":  res.FirstName,
+		"last_name":   res.LastName,
+		"email":       res.Email,
+		"phone":       res.Phone,
+		"start_date":  res.StartDate.Format("2006-01-02"),
+		"end_date":    res.EndDate.Format("2006-01-02"),
+		"room_id":     res.RoomID,
+		"status":      res.Status,
+		"total_price": res.TotalPrice,
+	}
+}
+
+//blockAudit is what the audit log keeps of a block
+func blockAudit(b models.RoomRestriction) map[string]interface{} {
+	return map[string]interface{}{
+		"room_id":        b.RoomID,
+		"start_date":     b.StartDate.Format("2006-01-02"),
+		"end_date":       b.EndDate.Format("2006-01-02"),
+		"restriction_id": b.RestrictionID,
+		"reason":         b.Reason,
+	}
+}
+
+//roomAudit is what the audit log keeps of a room
+func roomAudit(room models.Room) map[string]interface{} {
+	return map[string]interface{}{
+		"room_name":     room.RoomName,
+		"slug":          room.Slug,
+		"description":   room.Description,
+		"max_occupancy": room.MaxOccupancy,
+		"amenities":     room.Amenities,
+		"active":        room.Active,
+		"base_rate":     room.BaseRate,
+		"weekend_rate":  room.WeekendRate,
+	}
+}
+
+//restrictionAudit is what the audit log keeps of a restriction
+func restrictionAudit(restriction models.Restriction) map[string]interface{} {
+	return map[string]interface{}{
+		"restriction_name": restriction.RestrictionName,
+		"colour":           restriction.Colour,
+		"unavailable":      restriction.Unavailable,
+	}
+}
+
+//paymentAudit is what the audit log keeps of a payment
+func paymentAudit(pay models.Payment) map[string]interface{} {
+	return map[string]interface{}{
+		"reservation_id": pay.ReservationID,
+		"amount":         pay.Amount,
+		"refunded":       pay.Refunded,
+		"status":         pay.Status,
+	}
+}
+
+//AdminAudit shows the audit log, filtered by ?user_id=, ?action=, ?entity=, ?entity_id=, ?from= and ?to=
+func (m *Repository) AdminAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := forms.New(q)
+	var f models.AuditFilter
+	f.UserID, _ = strconv.Atoi(q.Get("user_id"))
+	f.EntityID, _ = strconv.Atoi(q.Get("entity_id"))
+	f.Action = q.Get("action")
+	f.Entity = q.Get("entity")
+	var err error
+	if q.Get("from") != "" {
+		if f.From, err = time.Parse("2006-01-02", q.Get("from")); err != nil {
+			form.Errors.Add("from", "Invalid date")
+		}
+	}
+	if q.Get("to") != "" {
+		if f.To, err = time.Parse("2006-01-02", q.Get("to")); err != nil {
+			form.Errors.Add("to", "Invalid date")
+		}
+	}
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+
+	var entries []models.AuditEntry
+	if form.Valid() {
+		entries, err = m.DB.GetAuditEntries(f)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	// the pager keeps the filters
+	q.Del("page")
+	stringMap := make(map[string]string)
+	stringMap["filters"] = q.Encode()
+	if f.Page > 1 {
+		stringMap["prev_page"] = strconv.Itoa(f.Page - 1)
+	}
+	if len(entries) == models.AuditPageSize {
+		stringMap["next_page"] = strconv.Itoa(f.Page + 1)
+	}
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["actions"] = models.AuditActions
+	data["entities"] = models.AuditEntities
+	render.Template(w, "admin-audit.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		StrMap: stringMap,
+	})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var adminAuditTests = []struct {
+	name         string
+	url          string
+	expectedHTML string
+	missingHTML  string
+}{
+	{"everything", "/admin/audit", "<del>Jon</del> John", "Nothing found."},
+	{"only deletes", "/admin/audit?action=delete", "<del>John</del>", "<del>Jon</del>"},
+	{"one reservation", "/admin/audit?entity=reservation&entity_id=1", "reservation 1", "Nothing found."},
+	{"a user who did nothing", "/admin/audit?user_id=99", "Nothing found.", "reservation 1"},
+	{"invalid date", "/admin/audit?from=soon", "Invalid date", "reservation 1"},
+	{"first page has no newer page", "/admin/audit?action=update", "Admin User", "Newer"},
+}
+
+func TestRepository_AdminAudit(t *testing.T) {
+	for _, e := range adminAuditTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr, _ := serveRoomRequest(Repo.AdminAudit, req, nil)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusOK, rr.Code)
+			continue
+		}
+		html := rr.Body.String()
+		if !strings.Contains(html, e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+		if strings.Contains(html, e.missingHTML) {
+			t.Errorf("%s: did not expect %q in the page", e.name, e.missingHTML)
+		}
+	}
+}
+
+func TestRepository_AdminShowReservation_History(t *testing.T) {
+	tests := []struct {
+		name        string
+		accessLevel int
+		expected    bool
+	}{
+		{"owner", 4, true},
+		{"front desk", 2, false},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "access_level", e.accessLevel)
+		req = req.WithContext(ctx)
+		req.RequestURI = "/admin/reservations/all/1/show"
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminShowReservation).ServeHTTP(rr, req)
+		if actual := strings.Contains(rr.Body.String(), "History"); actual != e.expected {
+			t.Errorf("%s: expected the history to be shown %t", e.name, e.expected)
+		}
+	}
+}
+
+func TestRequestIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"10.0.0.1:51234", "10.0.0.1"},
+		{"[::1]:51234", "::1"},
+		{"10.0.0.1", "10.0.0.1"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = e.remoteAddr
+		if actual := requestIP(req); actual != e.expected {
+			t.Errorf("expected %q got %q", e.expected, actual)
+		}
+	}
+}
+
+func TestRepository_AuditFailureStopsTheChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		url     string
+		params  map[string]string
+	}{
+		{"delete a block", Repo.AdminDeleteBlock, "/admin/blocks/2/delete", map[string]string{"id": "2"}},
+		{"delete a rate", Repo.AdminDeleteRoomOverride, "/admin/rooms/1/rates/1/delete", map[string]string{"id": "1", "overrideID": "1"}},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		// the test repo fails to write the audit entry for this address
+		req.RemoteAddr = "10.0.0.99:51234"
+		rr, ctx := serveRoomRequest(e.handler, req, e.params)
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusInternalServerError, rr.Code)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != "" {
+			t.Errorf("%s: expected no flash got %q", e.name, flash)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// owner blocks take a room off the market for some nights, like a reservation without a guest.
+// in the form the owner picks the first and the last blocked day, we store the day after the last one as
+// the end, like the departure of a reservation
+
+//blockTitle is what the calendar shows about a block, its type and its reason
+func blockTitle(b models.RoomRestriction) string {
+	title := b.Restrictions.RestrictionName
+	if title == "" {
+		title = "Blocked"
+	}
+	if b.Reason != "" {
+		title += ": " + b.Reason
+	}
+	return title
+}
+
+//calendarMonth is the calendar page of the month day is in
+func calendarMonth(day time.Time) string {
+	return fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", day.Format("2006"), day.Format("01"))
+}
+
+//AdminBlock shows the form to edit a block, id "new" shows an empty one for ?room_id= and ?start=
+func (m *Repository) AdminBlock(w http.ResponseWriter, r *http.Request) {
+	var block models.RoomRestriction
+	if chi.URLParam(r, "id") == "new" {
+		block.RoomID, _ = strconv.Atoi(r.URL.Query().Get("room_id"))
+		start, err := time.Parse("2006-01-02", r.URL.Query().Get("start"))
+		if err != nil {
+			start = time.Now().Truncate(24 * time.Hour)
+		}
+		block.StartDate = start
+		block.EndDate = start.AddDate(0, 0, 1)
+	} else {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+			return
+		}
+		block, err = m.DB.GetBlockByID(id)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				m.App.Session.Put(r.Context(), "error", "can't find the block!")
+				http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+				return
+			}
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	form := forms.New(url.Values{
+		"room_id":        {strconv.Itoa(block.RoomID)},
+		"start":          {block.StartDate.Format("2006-01-02")},
+		"last":           {block.EndDate.AddDate(0, 0, -1).Format("2006-01-02")},
+		"restriction_id": {strconv.Itoa(block.RestrictionID)},
+		"reason":         {block.Reason},
+	})
+	m.renderAdminBlock(w, r, block, form)
+}
+
+//renderAdminBlock renders the form of a block, the values come from form
+func (m *Repository) renderAdminBlock(w http.ResponseWriter, r *http.Request, block models.RoomRestriction, form *forms.Form) {
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	restrictions, err := m.DB.AllRestrictions()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["block"] = block
+	data["rooms"] = rooms
+	data["block_types"] = models.BlockTypes(restrictions)
+	render.Template(w, "admin-block.page.tmpl", r, &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//PostAdminBlock creates a block (id "new") or stores the changes of one
+func (m *Repository) PostAdminBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+		return
+	}
+	var block models.RoomRestriction
+	if chi.URLParam(r, "id") != "new" {
+		block.ID, err = strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start", "last", "restriction_id")
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	last, err := time.Parse(layout, r.Form.Get("last"))
+	if err != nil {
+		form.Errors.Add("last", "Invalid date")
+	}
+	if form.Errors.Get("start") == "" && form.Errors.Get("last") == "" && last.Before(start) {
+		form.Errors.Add("last", "The last day can't be before the first one")
+	}
+	block.RestrictionID, _ = strconv.Atoi(r.Form.Get("restriction_id"))
+	restriction, err := m.DB.GetRestrictionByID(block.RestrictionID)
+	if err != nil || !restriction.IsBlockType() {
+		form.Errors.Add("restriction_id", "Choose a type")
+	}
+	block.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(block.RoomID)
+	}
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	block.StartDate = start
+	block.EndDate = last.AddDate(0, 0, 1)
+	block.Reason = strings.TrimSpace(r.Form.Get("reason"))
+	if !form.Valid() {
+		m.renderAdminBlock(w, r, block, form)
+		return
+	}
+
+	if block.ID == 0 {
+		block.ID, err = m.DB.InsertBlockForRoom(block, auditEntry(r, models.AuditCreate, models.AuditBlock, 0, nil, blockAudit(block)))
+	} else {
+		var before models.RoomRestriction
+		if before, err = m.DB.GetBlockByID(block.ID); err == nil {
+			err = m.DB.UpdateBlock(block, auditEntry(r, models.AuditUpdate, models.AuditBlock, block.ID, blockAudit(before), blockAudit(block)))
+		}
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the block!")
+			http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Block saved!")
+	http.Redirect(w, r, calendarMonth(block.StartDate), http.StatusSeeOther)
+}
+
+//AdminDeleteBlock removes a block, its nights are free again
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+		return
+	}
+	// only blocks, this must not free the room of a reservation
+	block, err := m.DB.GetBlockByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the block!")
+			http.Redirect(w, r, "/admin/reservations-calender", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.DeleteBlockByID(id, auditEntry(r, models.AuditDelete, models.AuditBlock, id, blockAudit(block), nil))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Block removed!")
+	http.Redirect(w, r, calendarMonth(block.StartDate), http.StatusSeeOther)
+}
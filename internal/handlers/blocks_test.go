@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"github.com/majedutd990/bookings/internal/models"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//blockForm is a valid block of room 1, changed by the given values
+func blockForm(changes url.Values) url.Values {
+	v := url.Values{
+		"room_id":        {"1"},
+		"start":          {"2050-02-10"},
+		"last":           {"2050-02-12"},
+		"restriction_id": {"5"},
+		"reason":         {"family visit"},
+	}
+	for k, vals := range changes {
+		v[k] = vals
+	}
+	return v
+}
+
+var postAdminBlockTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{"new block", "new", blockForm(nil), http.StatusSeeOther, "/admin/reservations-calender?y=2050&m=02", ""},
+	{"one day", "new", blockForm(url.Values{"last": {"2050-02-10"}}), http.StatusSeeOther, "/admin/reservations-calender?y=2050&m=02", ""},
+	{"edit block", "2", blockForm(nil), http.StatusSeeOther, "/admin/reservations-calender?y=2050&m=02", ""},
+	{"no such block", "3", blockForm(nil), http.StatusSeeOther, "/admin/reservations-calender", ""},
+	{"bad id", "x", blockForm(nil), http.StatusSeeOther, "/admin/reservations-calender", ""},
+	{"last before first", "new", blockForm(url.Values{"last": {"2050-02-09"}}), http.StatusOK, "", "The last day can&#39;t be before the first one"},
+	{"invalid date", "new", blockForm(url.Values{"start": {"soon"}}), http.StatusOK, "", "Invalid date"},
+	{"reservation is no type", "new", blockForm(url.Values{"restriction_id": {"1"}}), http.StatusOK, "", "Choose a type"},
+	{"no such room", "new", blockForm(url.Values{"room_id": {"5"}}), http.StatusOK, "", "Choose a room"},
+}
+
+func TestRepository_PostAdminBlock(t *testing.T) {
+	for _, e := range postAdminBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, _ := serveRoomRequest(Repo.PostAdminBlock, req, map[string]string{"id": e.id})
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminBlockTests = []struct {
+	name         string
+	url          string
+	id           string
+	expectedCode int
+	expectedHTML string
+}{
+	{"new block", "/admin/blocks/new?room_id=2&start=2050-03-01", "new", http.StatusOK, `value="2050-03-01"`},
+	{"existing block", "/admin/blocks/2", "2", http.StatusOK, "new boiler"},
+	{"last day is inclusive", "/admin/blocks/2", "2", http.StatusOK, `value="2050-01-06"`},
+	{"no such block", "/admin/blocks/3", "3", http.StatusSeeOther, ""},
+}
+
+func TestRepository_AdminBlock(t *testing.T) {
+	for _, e := range adminBlockTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr, _ := serveRoomRequest(Repo.AdminBlock, req, map[string]string{"id": e.id})
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteBlock(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/blocks/2/delete", nil)
+	rr, ctx := serveRoomRequest(Repo.AdminDeleteBlock, req, map[string]string{"id": "2"})
+	if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/reservations-calender?y=2050&m=01" {
+		t.Errorf("expected the month of the block got %s", actualLoc.String())
+	}
+	if session.GetString(ctx, "flash") != "Block removed!" {
+		t.Errorf("expected flash got %q", session.GetString(ctx, "flash"))
+	}
+
+	// a reservation is no block
+	req, _ = http.NewRequest("POST", "/admin/blocks/1/delete", nil)
+	rr, ctx = serveRoomRequest(Repo.AdminDeleteBlock, req, map[string]string{"id": "1"})
+	if session.GetString(ctx, "error") != "can't find the block!" {
+		t.Errorf("expected error got %q", session.GetString(ctx, "error"))
+	}
+}
+
+func TestBlockTitle(t *testing.T) {
+	tests := []struct {
+		block    models.RoomRestriction
+		expected string
+	}{
+		{models.RoomRestriction{Restrictions: models.Restriction{RestrictionName: "Maintenance"}, Reason: "new boiler"}, "Maintenance: new boiler"},
+		{models.RoomRestriction{Restrictions: models.Restriction{RestrictionName: "Closed"}}, "Closed"},
+		{models.RoomRestriction{RestrictionID: 9}, "Blocked"},
+	}
+	for _, e := range tests {
+		if actual := blockTitle(e.block); actual != e.expected {
+			t.Errorf("expected %q got %q", e.expected, actual)
+		}
+	}
+}
@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/majedutd990/bookings/internal/config"
 	"github.com/majedutd990/bookings/internal/driver"
 	"github.com/majedutd990/bookings/internal/forms"
 	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/mailer"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
 	"github.com/majedutd990/bookings/internal/render"
 	"github.com/majedutd990/bookings/internal/repository"
 	"github.com/majedutd990/bookings/internal/repository/dbrepo"
@@ -90,9 +94,17 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	log.Println(sd)
 	log.Println(ed)
 	res.Room.RoomName = room.RoomName
+	q, err := m.quote(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get a price for this room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	res.TotalPrice = q.Total
 	m.App.Session.Put(r.Context(), "reservation", res)
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = q
 	var mj = &models.TemplateData{
 		Form:   forms.New(nil),
 		Data:   data,
@@ -151,15 +163,24 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the price is quoted again, the rates may have changed since the guest saw them
+	q, err := m.quote(roomID, startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get a price for this room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
 	reservations := models.Reservation{
-		FirstName: r.Form.Get("firstName"),
-		LastName:  r.Form.Get("lastName"),
-		Email:     r.Form.Get("email"),
-		Phone:     r.Form.Get("phone"),
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomID:    roomID,
-		Room:      room,
+		FirstName:  r.Form.Get("firstName"),
+		LastName:   r.Form.Get("lastName"),
+		Email:      r.Form.Get("email"),
+		Phone:      r.Form.Get("phone"),
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     roomID,
+		Room:       room,
+		TotalPrice: q.Total,
 	}
 
 	//postform has all of the url values and associated data
@@ -171,6 +192,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 		data := make(map[string]interface{})
 		data["reservation"] = reservations
+		data["quote"] = q
 		stringMap := make(map[string]string)
 		stringMap["start_date"] = sd
 		stringMap["end_date"] = ed
@@ -182,68 +204,90 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
-	newReservationID, err := m.DB.InsertReservation(reservations)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation to data base")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	// with a deposit the guest pays first, the room is booked once the payment is authorized
+	if m.deposit(reservations) > 0 {
+		m.App.Session.Put(r.Context(), "unpaid_reservation", reservations)
+		http.Redirect(w, r, "/make-reservation/payment", http.StatusSeeOther)
 		return
 	}
-	restrictions := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
-	err = m.DB.InsertRoomRestriction(restrictions)
+	m.bookUnpaid(w, r, reservations)
+}
+
+//bookUnpaid books a reservation that has no deposit to pay and shows the guest its summary
+func (m *Repository) bookUnpaid(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	res, err := m.bookReservation(res, nil)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.bookingFailed(w, r, err)
 		return
 	}
-	// send notification mails to user
-	htmlMsg := fmt.Sprintf(`
-	<strong>Reservation Confirmation</strong>
-	Dear %s:,</br>
-	This is to confirm your reservation from %s to %s`,
-		reservations.FirstName,
-		reservations.StartDate.Format("2006-01-02"),
-		reservations.StartDate.Format("2006-01-02"))
-	mail := models.MailData{
-		To:       reservations.Email,
-		From:     "majedutd@gmail.com",
-		Subject:  "Reservation Confirmation!",
-		Content:  htmlMsg,
-		Template: "basic.html",
-	}
-	m.App.MailChan <- mail
-	// send notification mails to owner
-	htmlMsg = fmt.Sprintf(`
-	<strong>Reservation Confirmation</strong> </br>
-	A Reservation has been made for %s from %s to %s`,
-		reservations.FirstName,
-		reservations.StartDate.Format("2006-01-02"),
-		reservations.StartDate.Format("2006-01-02"))
-	mail = models.MailData{
-		To:      "majedutd@gmail.com",
-		From:    "majedutd@gmail.com",
-		Subject: "Reservation Notification!",
-		Content: htmlMsg,
-	}
-	m.App.MailChan <- mail
-	m.App.Session.Put(r.Context(), "reservation", reservations)
+	m.App.Session.Put(r.Context(), "reservation", res)
 	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
 
+//bookReservation stores a reservation with its restriction, its mails and, if the guest paid, its payment.
+// they all go in together, so we never end up with half a booking and the mails are in the outbox
+// as soon as the booking is stored
+func (m *Repository) bookReservation(res models.Reservation, pay *models.Payment) (models.Reservation, error) {
+	if pay != nil {
+		res.Payments = []models.Payment{*pay}
+	}
+	mails, err := m.bookingMails(&res)
+	if err != nil {
+		return res, err
+	}
+
+	restrictionID, err := m.restrictionID(models.RestrictionReservation)
+	if err != nil {
+		return res, err
+	}
+	if pay == nil {
+		res.ID, err = m.DB.InsertReservationWithRestriction(res, restrictionID, 0, nil, mails...)
+		return res, err
+	}
+	res.ID, pay.ID, err = m.DB.InsertReservationWithPayment(res, restrictionID, *pay, mails...)
+	pay.ReservationID = res.ID
+	res.Payments = []models.Payment{*pay}
+	return res, err
 }
 
-// Generals renders the Generals page and displays form
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "generals.page.tmpl", r, &models.TemplateData{})
+//bookingMails makes the notification mails to the guest and the owner about a reservation the guest booked
+func (m *Repository) bookingMails(res *models.Reservation) ([]models.MailData, error) {
+	guestMail, err := m.guestConfirmation(res, "")
+	if err != nil {
+		return nil, err
+	}
+	ownerMail, err := mailer.Message(ownerEmail, "Reservation Notification!", "reservation-notification",
+		mailer.ReservationData{Reservation: *res})
+	if err != nil {
+		return nil, err
+	}
+	return []models.MailData{guestMail, ownerMail}, nil
+}
+
+//guestConfirmation makes the confirmation mail to the guest, with note as an extra paragraph if it is not "".
+// the guest gets a link with a new token to come back to the booking, we only keep its hash in res
+func (m *Repository) guestConfirmation(res *models.Reservation, note string) (models.MailData, error) {
+	manageToken, manageTokenHash, err := helpers.NewToken()
+	if err != nil {
+		return models.MailData{}, err
+	}
+	res.ManageTokenHash = manageTokenHash
+	return mailer.Message(res.Email, "Reservation Confirmation!", "reservation-confirmation",
+		mailer.ReservationData{Reservation: *res, ManageURL: m.manageURL(manageToken), Note: note})
 }
 
-// Majors renders the Majors page and displays form
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "majors.page.tmpl", r, &models.TemplateData{})
+//bookingFailed tells the guest why bookReservation failed
+func (m *Repository) bookingFailed(w http.ResponseWriter, r *http.Request, err error) {
+	var notAvailable *repository.RoomNotAvailableError
+	if errors.As(err, &notAvailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for the selected dates!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	m.App.ErrorLog.Println(err)
+	m.App.Session.Put(r.Context(), "error", "can't insert reservation to data base")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
 // Availability renders the Availability page and displays form
@@ -275,6 +319,11 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	if !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Departure must be after arrival")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
@@ -287,8 +336,18 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
+	// what every room costs for these dates, by room id
+	quotes := make(map[int]pricing.Quote)
+	for _, room := range rooms {
+		quotes[room.ID], err = m.quote(room.ID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["quotes"] = quotes
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
@@ -478,8 +537,30 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// too many failures turn the login away before the password is even checked, see logins.go
+	attemptID, wait, err := m.startLogin(r, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if wait > 0 {
+		m.finishLogin(attemptID, email, 0, models.LoginLocked)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("too many failed logins, try again in %s!", waitText(wait)))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
 	id, _, err := m.DB.Authenticate(email, password)
 
+	if err != nil {
+		log.Println(err)
+		// id is set if only the password was wrong
+		m.finishLogin(attemptID, email, id, models.LoginFailed)
+		m.App.Session.Put(r.Context(), "error", "invalid login credentials!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	user, err := m.DB.GetUserByID(id)
 	if err != nil {
 		log.Println(err)
 		m.App.Session.Put(r.Context(), "error", "invalid login credentials!")
@@ -487,6 +568,11 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	m.App.Session.Put(r.Context(), "user_id", id)
+	// a new password logs out the sessions of older versions, see the Auth middleware
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion)
+	// we keep the access level in the session for the permission checks, the Auth middleware keeps it up to date
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.finishLogin(attemptID, email, id, models.LoginSuccess)
 	m.App.Session.Put(r.Context(), "flash", "logged in successfully!")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -508,30 +594,39 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, "admin-dashboard.page.tmpl", r, &models.TemplateData{})
 }
 
-//AdminNewReservations Shows all new reservations in admin tools
+//AdminNewReservations shows the open reservations (pending, confirmed or checked in) in admin tools
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.NewReservation()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, "admin-reservations-new.page.tmpl", r, &models.TemplateData{
-		Data: data,
-	})
+	m.renderReservationList(w, r, "admin-reservations-new.page.tmpl", models.OpenStatuses, m.DB.NewReservation)
 }
 
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservation()
+	m.renderReservationList(w, r, "admin-reservations-all.page.tmpl", models.Statuses, m.DB.AllReservation)
+}
+
+//renderReservationList renders a list of reservations, filtered by ?status= if it is one of statuses
+func (m *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, tmpl string, statuses []string,
+	list func(status string) ([]models.Reservation, error)) {
+	status := r.URL.Query().Get("status")
+	known := false
+	for _, s := range statuses {
+		known = known || s == status
+	}
+	if !known {
+		status = ""
+	}
+	reservations, err := list(status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
-	render.Template(w, "admin-reservations-all.page.tmpl", r, &models.TemplateData{
-		Data: data,
+	data["statuses"] = statuses
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+	render.Template(w, tmpl, r, &models.TemplateData{
+		Data:   data,
+		StrMap: stringMap,
 	})
 }
 
@@ -593,11 +688,17 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 	for _, room := range rooms {
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		// what the blocks are for, shown as tooltips, and the colours of their restrictions
+		blockReasonMap := make(map[string]string)
+		blockColourMap := make(map[string]string)
+		// the days booked on other channels, these are changed by their calendars only
+		externalMap := make(map[string]int)
 
 		//	let's loop through month
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format("2006-01-2")] = 0
 			blockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
 		}
 		//	get all the restrictions for current room
 		restrictions, err := m.DB.GetRestrictionsFroRoomByDate(room.ID, firstOfMonth, lastOfMonth)
@@ -607,7 +708,12 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 		}
 
 		for _, y := range restrictions {
-			if y.ReservationID > 0 {
+			if y.ICalFeedID > 0 {
+				//	it is booked on another channel, the day it ends is free again
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					externalMap[d.Format("2006-01-2")] = y.ICalFeedID
+				}
+			} else if y.ReservationID > 0 {
 				//	it is a reservation
 
 				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
@@ -615,14 +721,23 @@ func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Re
 				}
 
 			} else {
-				//	 it is a block
-
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+				//	 it is a block, the day it ends is free again. only the days of this month go in the map,
+				//	 the post handler removes blocks whose days are missing in the form
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					if _, ok := blockMap[d.Format("2006-01-2")]; ok {
+						blockMap[d.Format("2006-01-2")] = y.ID
+						blockReasonMap[d.Format("2006-01-2")] = blockTitle(y)
+						blockColourMap[d.Format("2006-01-2")] = y.Restrictions.Colour
+					}
+				}
 			}
 		}
 		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
 
 		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+		data[fmt.Sprintf("block_reason_map_%d", room.ID)] = blockReasonMap
+		data[fmt.Sprintf("block_colour_map_%d", room.ID)] = blockColourMap
+		data[fmt.Sprintf("external_map_%d", room.ID)] = externalMap
 		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
 	}
 
@@ -655,6 +770,8 @@ func (m *Repository) PostAdminReservationsCalender(w http.ResponseWriter, r *htt
 	}
 
 	form := forms.New(r.PostForm)
+	// a block over some days is in the map once per day, unchecking any of them removes all of it
+	removed := make(map[int]bool)
 	for _, room := range rooms {
 		// remove blocks
 		//===========================
@@ -671,11 +788,21 @@ func (m *Repository) PostAdminReservationsCalender(w http.ResponseWriter, r *htt
 			if val, ok := blockMap[name]; ok {
 				//	only pay attention to values gt than zero, and that are not in the post form
 				//	the rest are just placeholders for days without blocks
-				if val > 0 {
+				if val > 0 && !removed[val] {
 					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
-						err = m.DB.DeleteBlockByID(value)
+						removed[val] = true
+						block, err := m.DB.GetBlockByID(value)
+						if errors.Is(err, sql.ErrNoRows) {
+							// somebody removed it meanwhile
+							continue
+						}
 						if err != nil {
-							log.Println(err)
+							helpers.ServerError(w, err)
+							return
+						}
+						err = m.DB.DeleteBlockByID(value, auditEntry(r, models.AuditDelete, models.AuditBlock, value, blockAudit(block), nil))
+						if err != nil {
+							helpers.ServerError(w, err)
 							return
 						}
 					}
@@ -686,6 +813,11 @@ func (m *Repository) PostAdminReservationsCalender(w http.ResponseWriter, r *htt
 	}
 	//	let's add blocks
 	// if the checkbox is checked it will be in posted data otherwise it is not
+	ownerBlockID, err := m.restrictionID(models.RestrictionOwnerBlock)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	for name := range r.PostForm {
 		if strings.HasPrefix(name, "add_block") {
 			exploded := strings.Split(name, "_")
@@ -698,12 +830,12 @@ func (m *Repository) PostAdminReservationsCalender(w http.ResponseWriter, r *htt
 				return
 			}
 			block := models.RoomRestriction{
-
-				StartDate: sd,
-				EndDate:   sd,
-				RoomID:    roomId,
+				StartDate:     sd,
+				EndDate:       sd.AddDate(0, 0, 1),
+				RoomID:        roomId,
+				RestrictionID: ownerBlockID,
 			}
-			err = m.DB.InsertBlockForRoom(block)
+			block.ID, err = m.DB.InsertBlockForRoom(block, auditEntry(r, models.AuditCreate, models.AuditBlock, 0, nil, blockAudit(block)))
 			if err != nil {
 				helpers.ServerError(w, err)
 				return
@@ -738,8 +870,31 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		helpers.ServerError(w, err)
 		return
 	}
+	res.Payments, err = m.DB.GetPaymentsForReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	res.StatusHistory, err = m.DB.GetReservationStatusChanges(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["rooms"] = rooms
+	if models.Can(helpers.AccessLevel(r), "audit.view") {
+		data["audit"], err = m.DB.GetAuditEntries(models.AuditFilter{Entity: models.AuditReservation, EntityID: id})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
 	render.Template(w, "admin-reservations-show.page.tmpl", r, &models.TemplateData{
 		StrMap: stringMap,
 		Data:   data,
@@ -790,13 +945,19 @@ func (m *Repository) PostAdminShowReservation(w http.ResponseWriter, r *http.Req
 		})
 		return
 	}
+	before := reservationAudit(res)
 	res.FirstName = r.Form.Get("firstName")
 	res.LastName = r.Form.Get("lastName")
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(res, auditEntry(r, models.AuditUpdate, models.AuditReservation, res.ID, before, reservationAudit(res)))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+			return
+		}
 		helpers.ServerError(w, err)
 		return
 	}
@@ -811,34 +972,153 @@ func (m *Repository) PostAdminShowReservation(w http.ResponseWriter, r *http.Req
 	}
 }
 
-//AdminProcessReservation makes a reservation processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+//adminReservationPage is the admin page of a reservation, it keeps the month of the calendar the form came from
+func adminReservationPage(r *http.Request, src string, id int) string {
+	page := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+	if year := r.Form.Get("year"); year != "" {
+		page += fmt.Sprintf("?y=%s&m=%s", year, r.Form.Get("month"))
+	}
+	return page
+}
+
+//PostAdminReservationDates moves a reservation to other dates and/or another room. availability is checked
+// again without the reservation's own restriction, and the stay is quoted again
+func (m *Repository) PostAdminReservationDates(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "error in url!")
-		http.Redirect(w, r, fmt.Sprintf("/admin/dashboard"), http.StatusSeeOther)
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
 		return
 	}
 	src := chi.URLParam(r, "src")
+	err = r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+	back := adminReservationPage(r, src, id)
 
-	err = m.DB.UpdateProcessedFroReservation(id, 1)
+	res, err := m.DB.GetReservationById(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+			return
+		}
 		helpers.ServerError(w, err)
 		return
 	}
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-	m.App.Session.Put(r.Context(), "flash", "Reservation Marked As Processed!")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+	// a closed reservation has no restriction anymore, there is nothing to move
+	if !models.IsOpen(res.Status) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("a %s reservation can't be moved",
+			strings.ToLower(models.StatusName(res.Status))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
 
+	form := forms.New(r.PostForm)
+	form.Required("start", "end", "room_id")
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	}
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err == nil {
+		_, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "can't move the reservation: "+formErrors(form))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	q, err := m.quote(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	before := reservationAudit(res)
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.RoomID = roomID
+	res.TotalPrice = q.Total
+	err = m.DB.UpdateReservationDates(res, auditEntry(r, models.AuditMove, models.AuditReservation, res.ID, before, reservationAudit(res)))
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			m.App.Session.Put(r.Context(), "error", "the room is not available for these dates!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation moved, the stay costs %s now!", pricing.Format(q.Total)))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//PostAdminReservationStatus moves a reservation to the status in the form, if its current status allows it
+func (m *Repository) PostAdminReservationStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+	src := chi.URLParam(r, "src")
+	err = r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
 	}
+	back := adminReservationPage(r, src, id)
 
+	res, err := m.DB.GetReservationById(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	to := r.Form.Get("status")
+	if !models.CanTransition(res.Status, to) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("a %s reservation can't become %s",
+			strings.ToLower(models.StatusName(res.Status)), strings.ToLower(models.StatusName(to))))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	err = m.DB.ChangeReservationStatus(id, res.Status, to, helpers.UserID(r), auditEntry(r, models.AuditStatus,
+		models.AuditReservation, id, map[string]string{"status": res.Status}, map[string]string{"status": to}))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "the reservation was changed meanwhile, try again")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation Marked As %s!", models.StatusName(to)))
+	http.Redirect(w, r, back, http.StatusSeeOther)
 }
 
-//AdminDeleteReservation makes a reservation gone
+//AdminDeleteReservation moves a reservation to the trash, which frees its room
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
@@ -849,15 +1129,32 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 	src := chi.URLParam(r, "src")
 
-	err = m.DB.DeleteReservationById(id)
+	// the audit log keeps what was deleted
+	res, err := m.DB.GetReservationById(id)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.DeleteReservationById(id, helpers.UserID(r),
+		auditEntry(r, models.AuditDelete, models.AuditReservation, id, reservationAudit(res), nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+			return
+		}
 		helpers.ServerError(w, err)
 		return
 	}
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	m.App.Session.Put(r.Context(), "error", "A Reservation Has Been Deleted!")
+	m.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash, it can be restored from there!")
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
 	} else {
@@ -865,3 +1162,309 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 
 }
+
+//AdminAPITokens lists the api tokens of the logged-in user and shows the form to issue a new one
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	m.renderAPITokens(w, r, forms.New(nil), "")
+}
+
+//PostAdminAPITokens issues a new api token for the logged-in user.
+// the token is shown once on the page that comes back, we only keep its hash
+func (m *Repository) PostAdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "access_level")
+	// a token can never do more than whoever issued it. that is the level of this request, a caller
+	// with a low level api token must not mint a token with the full level of its user
+	accessLevel, err := strconv.Atoi(r.Form.Get("access_level"))
+	if err != nil || accessLevel < 1 || accessLevel > helpers.AccessLevel(r) {
+		form.Errors.Add("access_level", "Invalid access level")
+	}
+	expiresInDays := 0
+	if form.Has("expires_in_days") {
+		expiresInDays, err = strconv.Atoi(r.Form.Get("expires_in_days"))
+		if err != nil || expiresInDays < 0 {
+			form.Errors.Add("expires_in_days", "Must be a number of days, 0 means never")
+		}
+	}
+	if !form.Valid() {
+		m.renderAPITokens(w, r, form, "")
+		return
+	}
+
+	token, hash, err := helpers.NewToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	t := models.APIToken{
+		UserID:      helpers.UserID(r),
+		Name:        r.Form.Get("name"),
+		TokenHash:   hash,
+		AccessLevel: accessLevel,
+	}
+	if expiresInDays > 0 {
+		t.ExpiresAt = time.Now().AddDate(0, 0, expiresInDays)
+	}
+	t.ID, err = m.DB.InsertAPIToken(t, auditEntry(r, models.AuditCreate, models.AuditAPIToken, 0, nil,
+		map[string]interface{}{"name": t.Name, "access_level": t.AccessLevel, "expires_at": t.ExpiresAt}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.renderAPITokens(w, r, forms.New(nil), token)
+}
+
+//AdminDeleteAPIToken revokes one of the api tokens of the logged-in user
+func (m *Repository) AdminDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.DeleteAPIToken(id, helpers.UserID(r), auditEntry(r, models.AuditDelete, models.AuditAPIToken, id, nil, nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the token!")
+			http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Token Revoked!")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+//renderAPITokens renders the api token page, newToken is only set right after a token was issued
+func (m *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form, newToken string) {
+	tokens, err := m.DB.GetAPITokensForUser(helpers.UserID(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var accessLevels []int
+	for i := 1; i <= helpers.AccessLevel(r); i++ {
+		accessLevels = append(accessLevels, i)
+	}
+	data := make(map[string]interface{})
+	data["tokens"] = tokens
+	data["access_levels"] = accessLevels
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = newToken
+	render.Template(w, "admin-api-tokens.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		StrMap: stringMap,
+	})
+}
+
+//manageURL is the link a guest uses to come back to their reservation
+func (m *Repository) manageURL(token string) string {
+	return fmt.Sprintf("%s/reservations/manage/%s", m.App.BaseURL, token)
+}
+
+//reservationFromManageToken looks up the reservation behind the token in the url.
+// it redirects home and returns false if there is none
+func (m *Repository) reservationFromManageToken(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByManageToken(helpers.HashToken(chi.URLParam(r, "token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "we could not find your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+	return res, true
+}
+
+//guestCanChange tells if a guest may still change or cancel the reservation on their own
+func guestCanChange(res models.Reservation) bool {
+	return models.CanTransition(res.Status, models.StatusCancelled) && res.StartDate.After(time.Now())
+}
+
+//ownerEmail is where notifications about reservations go
+const ownerEmail = "majedutd@gmail.com"
+
+//notifyOwner renders the mail template tmpl with data and sends it to the owner
+func (m *Repository) notifyOwner(subject, tmpl string, data interface{}) {
+	msg, err := mailer.Message(ownerEmail, subject, tmpl, data)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+	m.App.MailChan <- msg
+}
+
+//ManageReservation shows a guest their reservation, reached through the link in the confirmation email
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromManageToken(w, r)
+	if !ok {
+		return
+	}
+	m.renderManageReservation(w, r, res, forms.New(nil))
+}
+
+//PostManageReservationDates lets a guest move their stay to other dates in the same room
+func (m *Repository) PostManageReservationDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromManageToken(w, r)
+	if !ok {
+		return
+	}
+	token := chi.URLParam(r, "token")
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "this reservation can't be changed anymore")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	}
+	if form.Valid() {
+		if !startDate.After(time.Now()) {
+			form.Errors.Add("start", "Arrival must be in the future")
+		}
+		if !endDate.After(startDate) {
+			form.Errors.Add("end", "Departure must be after arrival")
+		}
+	}
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, form)
+		return
+	}
+
+	q, err := m.quote(res.RoomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	oldStart, oldEnd := res.StartDate, res.EndDate
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.TotalPrice = q.Total
+	// this checks availability again, ignoring the guest's own booking
+	err = m.DB.UpdateReservationDates(res, nil)
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		if errors.As(err, &notAvailable) {
+			m.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for these dates!")
+			http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.notifyOwner("Reservation Changed!", "reservation-changed", mailer.ReservationChangedData{
+		Reservation:  res,
+		OldStartDate: oldStart,
+		OldEndDate:   oldEnd,
+	})
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed!")
+	http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+}
+
+//PostManageReservationCancel lets a guest cancel their reservation, which frees the room again
+func (m *Repository) PostManageReservationCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromManageToken(w, r)
+	if !ok {
+		return
+	}
+	token := chi.URLParam(r, "token")
+	if !guestCanChange(res) {
+		m.App.Session.Put(r.Context(), "error", "this reservation can't be cancelled anymore")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+		return
+	}
+	err := m.DB.ChangeReservationStatus(res.ID, res.Status, models.StatusCancelled, 0, nil)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "this reservation can't be cancelled anymore")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.notifyOwner("Reservation Cancelled!", "reservation-cancelled", mailer.ReservationData{Reservation: res})
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled!")
+	http.Redirect(w, r, fmt.Sprintf("/reservations/manage/%s", token), http.StatusSeeOther)
+}
+
+//renderManageReservation renders the guest's manage page
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	intMap := make(map[string]int)
+	if guestCanChange(res) {
+		intMap["can_change"] = 1
+	}
+	render.Template(w, "manage-reservation.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		StrMap: stringMap,
+		IntMap: intMap,
+	})
+}
+
+//AdminFailedMail lists the mails the mail worker gave up on
+func (m *Repository) AdminFailedMail(w http.ResponseWriter, r *http.Request) {
+	mails, err := m.DB.GetFailedOutboxMails()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["mails"] = mails
+	render.Template(w, "admin-failed-mail.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminRetryMail puts a failed mail back in the outbox, so the mail worker tries it again
+func (m *Repository) AdminRetryMail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/failed-mail", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.RetryOutboxMail(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "this mail is not waiting for a retry")
+			http.Redirect(w, r, "/admin/failed-mail", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Mail queued again!")
+	http.Redirect(w, r, "/admin/failed-mail", http.StatusSeeOther)
+}
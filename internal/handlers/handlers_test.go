@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/majedutd990/bookings/internal/driver"
+	"github.com/majedutd990/bookings/internal/helpers"
 	"github.com/majedutd990/bookings/internal/models"
 	"log"
 	"net/http"
@@ -47,6 +49,36 @@ var theTests = []struct {
 		url:                "/majors-suites",
 		method:             "GET",
 		expectedStatusCode: http.StatusOK,
+	}, {
+		name:               "rooms",
+		url:                "/rooms",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	}, {
+		name:               "room page",
+		url:                "/rooms/majors-suite",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	}, {
+		name:               "room page of unknown room",
+		url:                "/rooms/old-cabin",
+		method:             "GET",
+		expectedStatusCode: http.StatusNotFound,
+	}, {
+		name:               "admin rooms",
+		url:                "/admin/rooms",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	}, {
+		name:               "admin room",
+		url:                "/admin/rooms/1",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	}, {
+		name:               "admin new room",
+		url:                "/admin/rooms/new",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
 	},
 	{
 		name:               "search-availability",
@@ -110,6 +142,18 @@ var theTests = []struct {
 		method:             "GET",
 		expectedStatusCode: http.StatusOK,
 	},
+	{
+		name:               "api tokens",
+		url:                "/admin/api-tokens",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "failed mail",
+		url:                "/admin/failed-mail",
+		method:             "GET",
+		expectedStatusCode: http.StatusOK,
+	},
 }
 
 //TestHandlers tests all routes that are only get requests
@@ -144,7 +188,9 @@ var reservationTests = []struct {
 	{
 		name: "reservation in session",
 		reservation: models.Reservation{
-			RoomID: 1,
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC),
 			Room: models.Room{
 				ID:       0,
 				RoomName: "General's Quarters",
@@ -154,6 +200,28 @@ var reservationTests = []struct {
 		expectedLocation:   "",
 		expectedHtml:       `action="/make-reservation"`,
 	},
+	{
+		name: "quote of two weekdays",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC),
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedLocation:   "",
+		expectedHtml:       `$200.00`,
+	},
+	{
+		name: "no nights to quote",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+		expectedHtml:       "",
+	},
 	{
 		name:               "reservation not in session",
 		reservation:        models.Reservation{},
@@ -208,13 +276,31 @@ func TestRepository_Reservation(t *testing.T) {
 	}
 }
 
+// without a deposit the room is booked right away, with one the guest goes on to the payment
 var postReservationTests = []struct {
 	name                 string
+	depositPercent       int
 	postedData           url.Values
 	expectedResponseCode int
 	expectedLocation     string
 	expectedHtml         string
 }{
+	{
+		name:           "valid data with deposit",
+		depositPercent: 20,
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
+			"firstName":  {"John"},
+			"lastName":   {"Smith"},
+			"email":      {"Smith@John.com"},
+			"phone":      {"55-555-55"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/make-reservation/payment",
+		expectedHtml:         "",
+	},
 	{
 		name: "valid data",
 		postedData: url.Values{
@@ -328,10 +414,28 @@ var postReservationTests = []struct {
 		expectedLocation:     "/",
 		expectedHtml:         "",
 	},
+	{
+		name: "room booked in the meantime",
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
+			"firstName":  {"John"},
+			"lastName":   {"Smith"},
+			"email":      {"Smith@John.com"},
+			"phone":      {"55-555-55"},
+			//2 is always taken in test db repo
+			"room_id": {"2"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search-availability",
+		expectedHtml:         "",
+	},
 }
 
 func TestRepository_PostReservation(t *testing.T) {
+	defer func(percent int) { app.Payment.DepositPercent = percent }(app.Payment.DepositPercent)
 	for _, e := range postReservationTests {
+		app.Payment.DepositPercent = e.depositPercent
 		var req *http.Request
 		if e.postedData != nil {
 			req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(e.postedData.Encode()))
@@ -829,6 +933,19 @@ var adminPostReservationTest = []struct {
 		expectedLocation:     "/admin/reservations-calender?y=2022&m=01",
 		expectedHTML:         "",
 	},
+	{
+		name: "deleted in the meantime",
+		url:  "/admin/reservations/all/1000/show",
+		postedData: url.Values{
+			"firstName": {"John"},
+			"lastName":  {"Smith"},
+			"email":     {"Smith@John.com"},
+			"phone":     {"55-555-55"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedHTML:         "",
+	},
 }
 
 func TestRepository_PostAdminShowReservation(t *testing.T) {
@@ -1022,57 +1139,359 @@ func TestAdminDeleteReservation(t *testing.T) {
 	}
 }
 
-var adminProcessReservationTests = []struct {
+var postAdminReservationDatesTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedLocation string
+	expectedFlash    string
+	expectedError    string
+}{
+	{"move to room 2", "1", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "room_id": {"2"}}, "/admin/reservations/all/1/show", "Reservation moved, the stay costs $200.00 now!", ""},
+	{"back to the calendar", "1", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "room_id": {"1"}, "year": {"2040"}, "month": {"01"}}, "/admin/reservations/all/1/show?y=2040&m=01", "", ""},
+	{"room taken", "1", url.Values{"start": {"2050-01-05"}, "end": {"2050-01-07"}, "room_id": {"1"}}, "/admin/reservations/all/1/show", "", "the room is not available for these dates!"},
+	{"departure before arrival", "1", url.Values{"start": {"2040-01-04"}, "end": {"2040-01-02"}, "room_id": {"1"}}, "/admin/reservations/all/1/show", "", "can't move the reservation: end: Departure must be after arrival"},
+	{"no such room", "1", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "room_id": {"5"}}, "/admin/reservations/all/1/show", "", "can't move the reservation: room_id: Choose a room"},
+	{"invalid date", "1", url.Values{"start": {"tomorrow"}, "end": {"2040-01-04"}, "room_id": {"1"}}, "/admin/reservations/all/1/show", "", "can't move the reservation: start: Invalid date"},
+	{"checked out", "3", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "room_id": {"1"}}, "/admin/reservations/all/3/show", "", "a checked out reservation can't be moved"},
+	{"unknown reservation", "1001", url.Values{"start": {"2040-01-02"}, "end": {"2040-01-04"}, "room_id": {"1"}}, "/admin/reservations-all", "", "can't find the reservation!"},
+	{"incorrect url", "ij", url.Values{}, "/admin/dashboard", "", "error in url!"},
+}
+
+func TestRepository_PostAdminReservationDates(t *testing.T) {
+	for _, e := range postAdminReservationDatesTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/dates", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostAdminReservationDates, req, map[string]string{"src": "all", "id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var postAdminReservationStatusTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedLocation string
+	expectedFlash    string
+	expectedError    string
+}{
+	{"confirm", "1", url.Values{"status": {"confirmed"}}, "/admin/reservations/new/1/show", "Reservation Marked As Confirmed!", ""},
+	{"cancel back to the calendar", "1", url.Values{"status": {"cancelled"}, "year": {"2050"}, "month": {"01"}}, "/admin/reservations/new/1/show?y=2050&m=01", "Reservation Marked As Cancelled!", ""},
+	{"skip confirming", "1", url.Values{"status": {"checked_in"}}, "/admin/reservations/new/1/show", "", "a pending reservation can't become checked in"},
+	{"unknown status", "1", url.Values{"status": {"lost"}}, "/admin/reservations/new/1/show", "", "a pending reservation can't become unknown"},
+	{"checked out is final", "3", url.Values{"status": {"cancelled"}}, "/admin/reservations/new/3/show", "", "a checked out reservation can't become cancelled"},
+	{"changed meanwhile", "2", url.Values{"status": {"confirmed"}}, "/admin/reservations/new/2/show", "", "the reservation was changed meanwhile, try again"},
+	{"unknown reservation", "1001", url.Values{"status": {"confirmed"}}, "/admin/reservations-new", "", "can't find the reservation!"},
+	{"incorrect url", "ij", url.Values{"status": {"confirmed"}}, "/admin/dashboard", "", "error in url!"},
+}
+
+func TestRepository_PostAdminReservationStatus(t *testing.T) {
+	for _, e := range postAdminReservationStatusTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/new/"+e.id+"/status", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostAdminReservationStatus, req, map[string]string{"src": "new", "id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var postAdminAPITokensTests = []struct {
 	name                 string
-	queryParams          string
-	url                  string
+	postedData           url.Values
+	tokenAccessLevel     int
 	expectedResponseCode int
-	expectedLocation     string
+	expectedHTML         string
 }{
 	{
-		name:                 "incorrect url",
-		queryParams:          "",
-		url:                  "/admin/process/reservation/cal/ij/do",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/dashboard",
+		name: "valid token",
+		postedData: url.Values{
+			"name":            {"channel manager"},
+			"access_level":    {"2"},
+			"expires_in_days": {"30"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "it will not be shown again",
 	},
 	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		url:                  "/admin/process/reservation/cal/1/do",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name: "missing name",
+		postedData: url.Values{
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field cannot be blank!",
 	},
 	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		url:                  "/admin/process/reservation/cal/1/do",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name: "access level above the user's",
+		postedData: url.Values{
+			"name":         {"channel manager"},
+			"access_level": {"4"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid access level",
+	},
+	{
+		name: "token can mint a token of its own level",
+		postedData: url.Values{
+			"name":         {"channel manager"},
+			"access_level": {"1"},
+		},
+		tokenAccessLevel:     1,
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "it will not be shown again",
+	},
+	{
+		name: "token can't mint a token above its own level",
+		postedData: url.Values{
+			"name":         {"channel manager"},
+			"access_level": {"2"},
+		},
+		tokenAccessLevel:     1,
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid access level",
 	},
 }
 
-func TestRepository_AdminProcessReservation(t *testing.T) {
-
-	for _, e := range adminProcessReservationTests {
-
-		var req *http.Request
-		eurl := e.url + "%s"
-		req, _ = http.NewRequest("GET", fmt.Sprintf(eurl, e.queryParams), nil)
+func TestRepository_PostAdminAPITokens(t *testing.T) {
+	for _, e := range postAdminAPITokensTests {
+		req, _ := http.NewRequest("POST", "/admin/api-tokens", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", 3)
+		if e.tokenAccessLevel > 0 {
+			req.Header.Set("Authorization", "Bearer some-token")
+			req = req.WithContext(helpers.WithAuthUser(ctx, helpers.AuthUser{ID: 1, AccessLevel: e.tokenAccessLevel}))
+		}
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+
+		handler := http.HandlerFunc(Repo.PostAdminAPITokens)
 		handler.ServeHTTP(rr, req)
+
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteAPIToken(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"revoke", "1", "Token Revoked!", ""},
+		{"unknown token", "1001", "", "can't find the token!"},
+		{"bad id", "x", "", "error in url!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-tokens/"+e.id+"/delete", nil)
+		rr, ctx := serveRoomRequest(Repo.AdminDeleteAPIToken, req, map[string]string{"id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var manageReservationTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name:               "show",
+		method:             "GET",
+		url:                "/reservations/manage/valid-manage-token",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Cancel Reservation",
+	},
+	{
+		name:               "show cancelled",
+		method:             "GET",
+		url:                "/reservations/manage/cancelled-manage-token",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Cancelled",
+	},
+	{
+		name:               "unknown token",
+		method:             "GET",
+		url:                "/reservations/manage/fish",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:   "change dates",
+		method: "POST",
+		url:    "/reservations/manage/valid-manage-token/dates",
+		postedData: url.Values{
+			"start": {"2040-02-01"},
+			"end":   {"2040-02-03"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/manage/valid-manage-token",
+	},
+	{
+		name:   "change dates room not available",
+		method: "POST",
+		url:    "/reservations/manage/valid-manage-token/dates",
+		postedData: url.Values{
+			"start": {"2050-02-01"},
+			"end":   {"2050-02-03"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/manage/valid-manage-token",
+	},
+	{
+		name:   "change dates end before start",
+		method: "POST",
+		url:    "/reservations/manage/valid-manage-token/dates",
+		postedData: url.Values{
+			"start": {"2040-02-03"},
+			"end":   {"2040-02-01"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Departure must be after arrival",
+	},
+	{
+		name:   "change dates of cancelled reservation",
+		method: "POST",
+		url:    "/reservations/manage/cancelled-manage-token/dates",
+		postedData: url.Values{
+			"start": {"2040-02-01"},
+			"end":   {"2040-02-03"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/manage/cancelled-manage-token",
+	},
+	{
+		name:               "cancel",
+		method:             "POST",
+		url:                "/reservations/manage/valid-manage-token/cancel",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/manage/valid-manage-token",
+	},
+	{
+		name:               "cancel unknown token",
+		method:             "POST",
+		url:                "/reservations/manage/fish/cancel",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+}
+
+//TestRepository_ManageReservation tests the guest self service pages through the router, since they need the token url param
+func TestRepository_ManageReservation(t *testing.T) {
+	routes := getRoutes()
+
+	for _, e := range manageReservationTests {
+		req := httptest.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
 		if e.expectedLocation != "" {
-			actualLocation, _ := rr.Result().Location()
-			if actualLocation.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but went %s", e.name, e.expectedLocation, actualLocation.String())
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminRetryMailTests = []struct {
+	name             string
+	url              string
+	expectedLocation string
+	expectedFlash    string
+	expectedError    string
+}{
+	{
+		name:             "retry",
+		url:              "/admin/failed-mail/1/retry",
+		expectedLocation: "/admin/failed-mail",
+		expectedFlash:    "Mail queued again!",
+	},
+	{
+		name:             "not a dead mail",
+		url:              "/admin/failed-mail/1001/retry",
+		expectedLocation: "/admin/failed-mail",
+		expectedError:    "this mail is not waiting for a retry",
+	},
+}
+
+func TestRepository_AdminRetryMail(t *testing.T) {
+	for _, e := range adminRetryMailTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strings.Split(e.url, "/")[3])
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminRetryMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q", e.name, e.expectedFlash)
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q", e.name, e.expectedError)
+		}
 	}
 }
 
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/ical"
+	"github.com/majedutd990/bookings/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// the calendar feeds are read by google, outlook and the booking sites, which can't log in.
+// instead every feed has its own token in the url, an hmac of the feed name with the ical secret
+
+//these are how far back and ahead the feeds go
+const (
+	icalDaysBack    = 60
+	icalDaysForward = 2 * 365
+)
+
+//icalToken returns the token of a feed, "" if there is no ical secret
+func (m *Repository) icalToken(feed string) string {
+	if m.App.ICalSecret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(m.App.ICalSecret))
+	mac.Write([]byte(feed))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//icalURL returns the url of a feed with its token, "" if there are no feeds
+func (m *Repository) icalURL(feed, path string) string {
+	token := m.icalToken(feed)
+	if token == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s%s?token=%s", m.App.BaseURL, path, token)
+}
+
+//validICalToken checks the token of a request, when it is wrong the feed does not exist as far as the client knows
+func (m *Repository) validICalToken(w http.ResponseWriter, r *http.Request, feed string) bool {
+	expected := m.icalToken(feed)
+	if expected == "" || !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(expected)) {
+		http.NotFound(w, r)
+		return false
+	}
+	return true
+}
+
+//roomFeed is the name of the feed of a room, as it goes into its token
+func roomFeed(roomID int) string {
+	return fmt.Sprintf("room-%d", roomID)
+}
+
+//ICalRoom is the calendar feed of one room, /ical/rooms/{id}.ics
+func (m *Repository) ICalRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !m.validICalToken(w, r, roomFeed(id)) {
+		return
+	}
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	events, err := m.icalEvents(room, false)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	writeCalendar(w, fmt.Sprintf("room-%d.ics", room.ID), ical.Calendar{Name: room.RoomName, Events: events, Stamp: time.Now()})
+}
+
+//ICalProperty is the calendar feed of all rooms, /ical/property.ics
+func (m *Repository) ICalProperty(w http.ResponseWriter, r *http.Request) {
+	if !m.validICalToken(w, r, "property") {
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var events []ical.Event
+	for _, room := range rooms {
+		roomEvents, err := m.icalEvents(room, true)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		events = append(events, roomEvents...)
+	}
+	writeCalendar(w, "property.ics", ical.Calendar{Name: "All rooms", Events: events, Stamp: time.Now()})
+}
+
+//icalEvents turns the restrictions of a room into events, reservations and owner blocks look different.
+// guest names stay out, the feeds end up at other companies
+func (m *Repository) icalEvents(room models.Room, withRoomName bool) ([]ical.Event, error) {
+	today := time.Now().Truncate(24 * time.Hour)
+	restrictions, err := m.DB.GetRestrictionsFroRoomByDate(room.ID, today.AddDate(0, 0, -icalDaysBack), today.AddDate(0, 0, icalDaysForward))
+	if err != nil {
+		return nil, err
+	}
+	host := "bookings"
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	events := make([]ical.Event, 0, len(restrictions))
+	for _, restriction := range restrictions {
+		// notes on the calendar don't keep anybody from booking the room
+		if !restriction.Restrictions.Unavailable {
+			continue
+		}
+		e := ical.Event{Start: restriction.StartDate, End: restriction.EndDate}
+		// the ids never change, so clients update an event that changed instead of adding it again
+		switch {
+		case restriction.ReservationID > 0:
+			e.UID = fmt.Sprintf("reservation-%d@%s", restriction.ReservationID, host)
+			e.Summary = "Reserved"
+			e.Categories = "RESERVATION"
+		case restriction.ICalFeedID > 0:
+			// booked on another channel, the other channels need to know too
+			e.UID = fmt.Sprintf("external-%d@%s", restriction.ID, host)
+			e.Summary = "Booked elsewhere"
+			e.Categories = "EXTERNAL"
+		default:
+			e.UID = fmt.Sprintf("block-%d@%s", restriction.ID, host)
+			e.Summary = "Blocked by owner"
+			e.Categories = "BLOCK"
+		}
+		if withRoomName {
+			e.Summary = room.RoomName + ": " + e.Summary
+		}
+		e.Description = room.RoomName
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+//writeCalendar sends a calendar as an ics file
+func writeCalendar(w http.ResponseWriter, filename string, c ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	_ = c.Write(w)
+}
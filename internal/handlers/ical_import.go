@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/icalsync"
+	"github.com/majedutd990/bookings/internal/models"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// the calendars of the other booking channels a room is listed on. a calendar with an address is
+// synced in the background (see cmd/web), the ones without are uploaded as .ics files by hand
+
+//icalFeedURL checks the address of a calendar, webcal:// is https:// for us
+func icalFeedURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(strings.ToLower(raw), "webcal://") {
+		raw = "https://" + raw[len("webcal://"):]
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", errors.New("Enter an address like https://www.airbnb.com/calendar/ical/123.ics")
+	}
+	return u.String(), nil
+}
+
+//parseICalUpload reads a multipart form with an optional .ics file (field "calendar"),
+// on error the user is sent back and ok is false. the caller closes the file if there is one.
+// the size of the body is limited by the LimitBody middleware
+func (m *Repository) parseICalUpload(w http.ResponseWriter, r *http.Request, back string) (io.ReadCloser, bool) {
+	err := r.ParseMultipartForm(icalsync.MaxFeedSize)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't read the upload: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return nil, false
+	}
+	file, header, err := r.FormFile("calendar")
+	if err != nil {
+		return nil, true
+	}
+	if header.Size > icalsync.MaxFeedSize {
+		_ = file.Close()
+		m.App.Session.Put(r.Context(), "error", "the calendar is too big, it may have 5 MB at most")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return nil, false
+	}
+	return file, true
+}
+
+//icalSynced tells the user how the sync or the upload of a calendar went
+func (m *Repository) icalSynced(w http.ResponseWriter, r *http.Request, back string, blocks int, err error) {
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't read the calendar: "+err.Error())
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar synced, %d bookings blocked!", blocks))
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//PostAdminRoomICalFeed adds the calendar of another channel to a room: an address that is synced,
+// or an uploaded .ics file. it is read right away
+func (m *Repository) PostAdminRoomICalFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, _, ok := m.roomRateIDs(w, r, "")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	file, ok := m.parseICalUpload(w, r, back)
+	if !ok {
+		return
+	}
+	if file != nil {
+		defer file.Close()
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	feedURL, err := icalFeedURL(r.Form.Get("url"))
+	if err != nil {
+		form.Errors.Add("url", err.Error())
+	} else if feedURL == "" && file == nil {
+		form.Errors.Add("url", "Enter the address of the calendar or choose an .ics file")
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "can't add the calendar: "+formErrors(form))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find the room!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	feed := models.ICalFeed{
+		RoomID: roomID,
+		Name:   strings.TrimSpace(r.Form.Get("name")),
+		URL:    feedURL,
+	}
+	feed.ID, err = m.DB.InsertICalFeed(feed, auditEntry(r, models.AuditCreate, models.AuditICalFeed, 0, nil,
+		map[string]interface{}{"room_id": feed.RoomID, "name": feed.Name, "url": feed.URL}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	syncer := icalsync.New(m.DB, nil)
+	var blocks int
+	if feed.URL != "" {
+		blocks, err = syncer.SyncFeed(r.Context(), feed)
+	} else {
+		blocks, err = syncer.Import(feed, file)
+	}
+	m.icalSynced(w, r, back, blocks, err)
+}
+
+//adminICalFeed finds the feed of a room in the url, on error the user is sent back and ok is false
+func (m *Repository) adminICalFeed(w http.ResponseWriter, r *http.Request) (models.ICalFeed, string, bool) {
+	roomID, feedID, ok := m.roomRateIDs(w, r, "feedID")
+	if !ok {
+		return models.ICalFeed{}, "", false
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	feed, err := m.DB.GetICalFeedByID(feedID, roomID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the calendar!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return feed, back, false
+		}
+		helpers.ServerError(w, err)
+		return feed, back, false
+	}
+	return feed, back, true
+}
+
+//AdminSyncRoomICalFeed syncs the calendar of a room now, instead of waiting for the background sync
+func (m *Repository) AdminSyncRoomICalFeed(w http.ResponseWriter, r *http.Request) {
+	feed, back, ok := m.adminICalFeed(w, r)
+	if !ok {
+		return
+	}
+	blocks, err := icalsync.New(m.DB, nil).SyncFeed(r.Context(), feed)
+	m.icalSynced(w, r, back, blocks, err)
+}
+
+//PostAdminRoomICalFeedUpload replaces the bookings of a calendar with the ones of an uploaded .ics file
+func (m *Repository) PostAdminRoomICalFeedUpload(w http.ResponseWriter, r *http.Request) {
+	feed, back, ok := m.adminICalFeed(w, r)
+	if !ok {
+		return
+	}
+	// the background sync would overwrite it with what the address says
+	if feed.URL != "" {
+		m.App.Session.Put(r.Context(), "error", "this calendar is synced from its address, use sync instead")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	file, ok := m.parseICalUpload(w, r, back)
+	if !ok {
+		return
+	}
+	if file == nil {
+		m.App.Session.Put(r.Context(), "error", "choose an .ics file to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+	blocks, err := icalsync.New(m.DB, nil).Import(feed, file)
+	m.icalSynced(w, r, back, blocks, err)
+}
+
+//AdminDeleteRoomICalFeed removes the calendar of a room, the days it blocked are free again
+func (m *Repository) AdminDeleteRoomICalFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, feedID, ok := m.roomRateIDs(w, r, "feedID")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	err := m.DB.DeleteICalFeed(feedID, roomID,
+		auditEntry(r, models.AuditDelete, models.AuditICalFeed, feedID, map[string]int{"room_id": roomID}, nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the calendar!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/majedutd990/bookings/internal/icalsync"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//testCalendar is a calendar with one booking
+const testCalendar = "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:abc@airbnb.com\r\nDTSTART;VALUE=DATE:20500110\r\n" +
+	"DTEND;VALUE=DATE:20500112\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+//icalForm makes the multipart body of the calendar forms, without a file if calendar is ""
+func icalForm(fields map[string]string, calendar string) (*bytes.Buffer, string) {
+	body := new(bytes.Buffer)
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		_ = mw.WriteField(k, v)
+	}
+	if calendar != "" {
+		fw, _ := mw.CreateFormFile("calendar", "calendar.ics")
+		_, _ = fw.Write([]byte(calendar))
+	}
+	_ = mw.Close()
+	return body, mw.FormDataContentType()
+}
+
+func TestRepository_PostAdminRoomICalFeed(t *testing.T) {
+	// a stand-in for the calendar of another channel
+	channel := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/calendar.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		_, _ = w.Write([]byte(testCalendar))
+	}))
+	defer channel.Close()
+
+	var tests = []struct {
+		name             string
+		id               string
+		fields           map[string]string
+		calendar         string
+		expectedLocation string
+		expectedFlash    string
+		expectedError    string
+	}{
+		{"address", "1", map[string]string{"name": "Airbnb", "url": channel.URL + "/calendar.ics"}, "", "/admin/rooms/1", "Calendar synced, 1 bookings blocked!", ""},
+		{"upload", "1", map[string]string{"name": "Vrbo"}, testCalendar, "/admin/rooms/1", "Calendar synced, 1 bookings blocked!", ""},
+		{"address that is gone", "1", map[string]string{"name": "Airbnb", "url": channel.URL + "/gone.ics"}, "", "/admin/rooms/1", "", "can't read the calendar: got 404 Not Found from " + channel.URL + "/gone.ics"},
+		{"upload that is no calendar", "1", map[string]string{"name": "Vrbo"}, "<html></html>", "/admin/rooms/1", "", "can't read the calendar: ical: not a calendar"},
+		{"no name", "1", map[string]string{"url": channel.URL}, "", "/admin/rooms/1", "", "can't add the calendar: name: This field cannot be blank!"},
+		{"neither address nor file", "1", map[string]string{"name": "Airbnb"}, "", "/admin/rooms/1", "", "can't add the calendar: url: Enter the address of the calendar or choose an .ics file"},
+		{"not a web address", "1", map[string]string{"name": "Airbnb", "url": "file:///etc/passwd"}, "", "/admin/rooms/1", "", "can't add the calendar: url: Enter an address like https://www.airbnb.com/calendar/ical/123.ics"},
+		{"unknown room", "1001", map[string]string{"name": "Vrbo"}, testCalendar, "/admin/rooms", "", "can't find the room!"},
+		{"bad room id", "x", map[string]string{"name": "Vrbo"}, testCalendar, "/admin/rooms", "", "error in url!"},
+	}
+	for _, e := range tests {
+		body, contentType := icalForm(e.fields, e.calendar)
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/ical-feeds", body)
+		req.Header.Set("Content-Type", contentType)
+		rr, ctx := serveRoomRequest(Repo.PostAdminRoomICalFeed, req, map[string]string{"id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+func TestRepository_AdminRoomICalFeedActions(t *testing.T) {
+	var tests = []struct {
+		name          string
+		handler       http.HandlerFunc
+		feedID        string
+		calendar      string
+		expectedFlash string
+		expectedError string
+	}{
+		{"upload", Repo.PostAdminRoomICalFeedUpload, "2", testCalendar, "Calendar synced, 1 bookings blocked!", ""},
+		{"upload without file", Repo.PostAdminRoomICalFeedUpload, "2", "", "", "choose an .ics file to upload"},
+		{"upload to a synced calendar", Repo.PostAdminRoomICalFeedUpload, "1", testCalendar, "", "this calendar is synced from its address, use sync instead"},
+		{"upload that is too big", Repo.PostAdminRoomICalFeedUpload, "2", strings.Repeat(" ", icalsync.MaxFeedSize+1), "", "the calendar is too big, it may have 5 MB at most"},
+		{"sync an uploaded calendar", Repo.AdminSyncRoomICalFeed, "2", "", "", "can't read the calendar: the feed has no url, upload its .ics file instead"},
+		{"sync unknown calendar", Repo.AdminSyncRoomICalFeed, "1001", "", "", "can't find the calendar!"},
+		{"delete", Repo.AdminDeleteRoomICalFeed, "1", "", "Calendar removed!", ""},
+		{"delete unknown calendar", Repo.AdminDeleteRoomICalFeed, "1001", "", "", "can't find the calendar!"},
+		{"bad calendar id", Repo.AdminDeleteRoomICalFeed, "x", "", "", "error in url!"},
+	}
+	for _, e := range tests {
+		body, contentType := icalForm(nil, e.calendar)
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/ical-feeds/"+e.feedID, body)
+		req.Header.Set("Content-Type", contentType)
+		rr, ctx := serveRoomRequest(e.handler, req, map[string]string{"id": "1", "feedID": e.feedID})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/rooms/1" {
+			t.Errorf("%s: expected location /admin/rooms/1 got %s", e.name, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+func TestICalFeedURL(t *testing.T) {
+	var tests = []struct {
+		raw      string
+		expected string
+		ok       bool
+	}{
+		{"", "", true},
+		{" https://www.airbnb.com/calendar/ical/1.ics?s=abc ", "https://www.airbnb.com/calendar/ical/1.ics?s=abc", true},
+		{"webcal://calendar.example.com/room.ics", "https://calendar.example.com/room.ics", true},
+		{"ftp://example.com/room.ics", "", false},
+		{"airbnb.com/calendar", "", false},
+	}
+	for _, e := range tests {
+		got, err := icalFeedURL(e.raw)
+		if (err == nil) != e.ok || got != e.expected {
+			t.Errorf("%q: expected %q (ok %t) got %q (%v)", e.raw, e.expected, e.ok, got, err)
+		}
+	}
+}
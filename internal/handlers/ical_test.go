@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepository_ICalFeeds(t *testing.T) {
+	var tests = []struct {
+		name         string
+		url          string
+		expectedCode int
+		expected     []string
+	}{
+		{
+			name:         "room feed",
+			url:          "/ical/rooms/1.ics?token=" + Repo.icalToken(roomFeed(1)),
+			expectedCode: http.StatusOK,
+			expected: []string{
+				"UID:reservation-5@localhost:8080\r\n",
+				"SUMMARY:Reserved\r\n",
+				"DTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500103\r\n",
+				"UID:block-2@localhost:8080\r\n",
+				"SUMMARY:Blocked by owner\r\n",
+				"UID:external-3@localhost:8080\r\n",
+				"SUMMARY:Booked elsewhere\r\n",
+			},
+		},
+		{
+			name:         "property feed",
+			url:          "/ical/property.ics?token=" + Repo.icalToken("property"),
+			expectedCode: http.StatusOK,
+			expected:     []string{"BEGIN:VCALENDAR\r\n", "X-WR-CALNAME:All rooms\r\n"},
+		},
+		{"token of another room", "/ical/rooms/2.ics?token=" + Repo.icalToken(roomFeed(1)), http.StatusNotFound, nil},
+		{"no token", "/ical/rooms/1.ics", http.StatusNotFound, nil},
+		{"property with a room token", "/ical/property.ics?token=" + Repo.icalToken(roomFeed(1)), http.StatusNotFound, nil},
+		{"unknown room", "/ical/rooms/7.ics?token=" + Repo.icalToken(roomFeed(7)), http.StatusNotFound, nil},
+	}
+	routes := getRoutes()
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedCode != http.StatusOK {
+			continue
+		}
+		if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+			t.Errorf("%s: expected a calendar, got %s", e.name, rr.Header().Get("Content-Type"))
+		}
+		for _, s := range e.expected {
+			if !strings.Contains(rr.Body.String(), s) {
+				t.Errorf("%s: expected %q in\n%s", e.name, s, rr.Body.String())
+			}
+		}
+		// the feeds go to other companies
+		if strings.Contains(rr.Body.String(), "John") {
+			t.Errorf("%s: guest names must stay out of the feed", e.name)
+		}
+	}
+}
+
+func TestRepository_ICalWithoutSecret(t *testing.T) {
+	defer func(secret string) { app.ICalSecret = secret }(app.ICalSecret)
+	app.ICalSecret = ""
+
+	if Repo.icalURL("property", "/ical/property.ics") != "" {
+		t.Error("expected no feed urls without a secret")
+	}
+	req := httptest.NewRequest("GET", "/ical/property.ics?token=", nil)
+	rr := httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected no feeds without a secret, got code %d", rr.Code)
+	}
+}
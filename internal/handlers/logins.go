@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every login attempt is recorded in login_attempts, the failures slow down guessing. from the second failure
+// in a row an email waits before its next try (1s, 2s, 4s...), after maxEmailFailures it is locked until the
+// oldest of them is lockoutWindow old or an owner unlocks it. an ip is locked the same way after maxIPFailures,
+// without the delays since a whole office may share it. unknown emails are treated like real ones, so the
+// answers don't tell who has an account
+
+const (
+	maxEmailFailures = 5
+	lockoutWindow    = 15 * time.Minute
+	maxIPFailures    = 20
+	ipWindow         = 15 * time.Minute
+)
+
+//loginWait is how long the next login has to wait after failures (how long ago they were, newest first).
+// max failures lock until the oldest of them leaves window, with progressive every failure from the second
+// on doubles the delay
+func loginWait(failures []time.Duration, max int, window time.Duration, progressive bool) time.Duration {
+	var wait time.Duration
+	switch {
+	case len(failures) >= max:
+		wait = window - failures[max-1]
+	case progressive && len(failures) >= 2:
+		wait = time.Second<<(len(failures)-2) - failures[0]
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+//waitText is a wait the way people say it, rounded up
+func waitText(d time.Duration) string {
+	if d > time.Minute {
+		return fmt.Sprintf("%d minutes", int(math.Ceil(d.Minutes())))
+	}
+	if s := int(math.Ceil(d.Seconds())); s > 1 {
+		return fmt.Sprintf("%d seconds", s)
+	}
+	return "1 second"
+}
+
+//startLogin records a login attempt with the email as failed, before the password is checked, and returns its id
+// and how long it has to wait, the longer of the waits of the email and the ip. attempts running at the same time
+// count as failures of each other, so sending many at once doesn't get around the limits
+func (m *Repository) startLogin(r *http.Request, email string) (int, time.Duration, error) {
+	now := time.Now()
+	id, byEmail, byIP, err := m.DB.StartLoginAttempt(models.LoginAttempt{
+		Email:     email,
+		IP:        requestIP(r),
+		UserAgent: r.UserAgent(),
+	}, now.Add(-lockoutWindow), now.Add(-ipWindow), maxEmailFailures, maxIPFailures)
+	if err != nil {
+		return 0, 0, err
+	}
+	wait := loginWait(byEmail, maxEmailFailures, lockoutWindow, true)
+	if w := loginWait(byIP, maxIPFailures, ipWindow, false); w > wait {
+		wait = w
+	}
+	return id, wait, nil
+}
+
+//finishLogin records how a login attempt went. the attempt already counts as failed, so a failing
+// record only costs the user a failure and must not fail the login
+func (m *Repository) finishLogin(id int, email string, userID int, result string) {
+	err := m.DB.FinishLoginAttempt(id, userID, result)
+	if err != nil {
+		m.App.ErrorLog.Printf("login attempt %s %s: %v", result, email, err)
+	}
+}
+
+//AdminLogins shows the locked emails and the login activity, filtered by ?email=, ?ip= and ?result=
+func (m *Repository) AdminLogins(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := models.LoginAttemptFilter{
+		Email:  strings.TrimSpace(q.Get("email")),
+		IP:     strings.TrimSpace(q.Get("ip")),
+		Result: q.Get("result"),
+	}
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	attempts, err := m.DB.GetLoginAttempts(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	locked, err := m.DB.GetLockedLogins(time.Now().Add(-lockoutWindow), maxEmailFailures)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the pager keeps the filters
+	q.Del("page")
+	stringMap := make(map[string]string)
+	stringMap["filters"] = q.Encode()
+	if f.Page > 1 {
+		stringMap["prev_page"] = strconv.Itoa(f.Page - 1)
+	}
+	if len(attempts) == models.LoginPageSize {
+		stringMap["next_page"] = strconv.Itoa(f.Page + 1)
+	}
+	data := make(map[string]interface{})
+	data["attempts"] = attempts
+	data["locked"] = locked
+	data["results"] = models.LoginResults
+	render.Template(w, "admin-logins.page.tmpl", r, &models.TemplateData{
+		Form:   forms.New(q),
+		Data:   data,
+		StrMap: stringMap,
+	})
+}
+
+//PostAdminUnlockLogin unlocks an email, its failures so far don't count anymore, neither for the email nor for
+// the ips they came from
+func (m *Repository) PostAdminUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	email := strings.TrimSpace(r.Form.Get("email"))
+	if email == "" {
+		m.App.Session.Put(r.Context(), "error", "which email should be unlocked?")
+		http.Redirect(w, r, "/admin/logins", http.StatusSeeOther)
+		return
+	}
+	var userID int
+	user, err := m.DB.GetUserByEmail(email)
+	switch {
+	case err == nil:
+		userID = user.ID
+	case !errors.Is(err, sql.ErrNoRows):
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.InsertLoginAttempt(models.LoginAttempt{
+		UserID:    userID,
+		Email:     email,
+		IP:        requestIP(r),
+		UserAgent: r.UserAgent(),
+		Result:    models.LoginUnlocked,
+	}, auditEntry(r, models.AuditUnlock, models.AuditUser, userID, nil, map[string]interface{}{"email": email}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s can log in again!", email))
+	http.Redirect(w, r, "/admin/logins", http.StatusSeeOther)
+}
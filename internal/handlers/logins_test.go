@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginWait(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    []time.Duration
+		progressive bool
+		expected    time.Duration
+	}{
+		{"no failures", nil, true, 0},
+		{"one failure", []time.Duration{0}, true, 0},
+		{"second failure waits a second", []time.Duration{0, time.Minute}, true, time.Second},
+		{"third failure waits 2 seconds", []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, true, time.Second},
+		{"delay is over", []time.Duration{time.Minute, 2 * time.Minute}, true, 0},
+		{"no delays for ips", []time.Duration{0, time.Minute}, false, 0},
+		{"locked until the oldest leaves the window", []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}, true, 7 * time.Minute},
+		{"ips are locked too", []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute}, false, 7 * time.Minute},
+		{"lock is over", []time.Duration{7 * time.Minute, 8 * time.Minute, 9 * time.Minute, 10 * time.Minute}, true, 0},
+	}
+	for _, e := range tests {
+		// 4 failures in 10 minutes lock
+		if actual := loginWait(e.failures, 4, 10*time.Minute, e.progressive); actual != e.expected {
+			t.Errorf("%s: expected %s got %s", e.name, e.expected, actual)
+		}
+	}
+}
+
+func TestWaitText(t *testing.T) {
+	tests := []struct {
+		wait     time.Duration
+		expected string
+	}{
+		{200 * time.Millisecond, "1 second"},
+		{1500 * time.Millisecond, "2 seconds"},
+		{time.Minute, "60 seconds"},
+		{61 * time.Second, "2 minutes"},
+		{14*time.Minute + time.Second, "15 minutes"},
+	}
+	for _, e := range tests {
+		if actual := waitText(e.wait); actual != e.expected {
+			t.Errorf("%s: expected %q got %q", e.wait, e.expected, actual)
+		}
+	}
+}
+
+var throttledLoginTests = []struct {
+	name          string
+	email         string
+	remoteAddr    string
+	expectedError string
+}{
+	{"locked email", "locked@here.ca", "10.0.0.1:51234", "too many failed logins, try again in 10 minutes!"},
+	{"email has to wait", "slow@here.ca", "10.0.0.1:51234", "too many failed logins, try again in 2 seconds!"},
+	{"locked ip", "me@here.ca", "10.0.0.66:51234", "too many failed logins, try again in 12 minutes!"},
+	{"fine", "me@here.ca", "10.0.0.1:51234", ""},
+}
+
+func TestRepository_PostShowLogin_Throttled(t *testing.T) {
+	for _, e := range throttledLoginTests {
+		postedData := url.Values{"email": {e.email}, "password": {"123hj123"}}
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = e.remoteAddr
+		rr, ctx := serveRoomRequest(Repo.PostShowLogin, req, nil)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+
+	// the password is only checked once the attempt is recorded
+	postedData := url.Values{"email": {"broken@here.ca"}, "password": {"123hj123"}}
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr, _ := serveRoomRequest(Repo.PostShowLogin, req, nil)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("unrecorded attempt: expected code %d got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestRepository_AdminLogins(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/logins", nil)
+	rr, _ := serveRoomRequest(Repo.AdminLogins, req, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	for _, expected := range []string{"Unlock", "curl/7.79.1", "Admin User"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in the page", expected)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/logins?result=success", nil)
+	rr, _ = serveRoomRequest(Repo.AdminLogins, req, nil)
+	if strings.Contains(rr.Body.String(), "curl/7.79.1") {
+		t.Error("expected only the successful logins")
+	}
+}
+
+func TestRepository_PostAdminUnlockLogin(t *testing.T) {
+	tests := []struct {
+		name          string
+		email         string
+		expectedFlash string
+		expectedError string
+	}{
+		{"user", "me@here.ca", "me@here.ca can log in again!", ""},
+		{"unknown email", "locked@here.ca", "locked@here.ca can log in again!", ""},
+		{"no email", "", "", "which email should be unlocked?"},
+	}
+	for _, e := range tests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/admin/logins/unlock", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostAdminUnlockLogin, req, nil)
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/logins" {
+			t.Errorf("%s: expected location /admin/logins got %s", e.name, actualLoc.String())
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/payments"
+	"github.com/majedutd990/bookings/internal/render"
+	"net/http"
+	"strconv"
+)
+
+//deposit is what the guest pays for a reservation when booking it
+func (m *Repository) deposit(res models.Reservation) int {
+	return payments.Deposit(res.TotalPrice, m.App.Payment.DepositPercent)
+}
+
+//unpaidReservation gets the reservation that waits for its payment out of the session,
+// on error the guest is sent back and ok is false
+func (m *Repository) unpaidReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, ok := m.App.Session.Get(r.Context(), "unpaid_reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+	return res, ok
+}
+
+//ReservationPayment shows the payment step, between make-reservation and the reservation summary
+func (m *Repository) ReservationPayment(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.unpaidReservation(w, r)
+	if !ok {
+		return
+	}
+	m.renderPayment(w, r, res, forms.New(nil))
+}
+
+//PostReservationPayment authorizes the deposit, books the room and then captures the deposit,
+// so nobody pays for a room someone else got first
+func (m *Repository) PostReservationPayment(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.unpaidReservation(w, r)
+	if !ok {
+		return
+	}
+	// a free stay has nothing to authorize, the provider would decline it
+	deposit := m.deposit(res)
+	if deposit == 0 {
+		m.App.Session.Remove(r.Context(), "unpaid_reservation")
+		m.bookUnpaid(w, r, res)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("card")
+	if !form.Valid() {
+		m.renderPayment(w, r, res, form)
+		return
+	}
+
+	reference, err := m.App.Payments.Authorize(payments.Request{
+		Amount: deposit,
+		Description: fmt.Sprintf("Deposit for %s from %s to %s", res.Room.RoomName,
+			res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+		Email:  res.Email,
+		Source: r.Form.Get("card"),
+	})
+	if errors.Is(err, payments.ErrDeclined) {
+		form.Errors.Add("card", "Your card was declined, please try another one")
+		m.renderPayment(w, r, res, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	pay := models.Payment{
+		Provider:  m.App.Payments.Name(),
+		Reference: reference,
+		Amount:    deposit,
+		Status:    models.PaymentAuthorized,
+	}
+	res, err = m.bookReservation(res, &pay)
+	if err != nil {
+		// the guest did not get the room, so the money is not held anymore
+		if err := m.App.Payments.Refund(reference, deposit); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		m.App.Session.Remove(r.Context(), "unpaid_reservation")
+		m.bookingFailed(w, r, err)
+		return
+	}
+
+	// the booking is stored, if taking the money fails now the payment stays authorized for the staff to capture
+	err = m.App.Payments.Capture(reference, deposit)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		pay.Status = models.PaymentCaptured
+		if err = m.DB.UpdatePayment(pay, nil); err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+	res.Payments = []models.Payment{pay}
+
+	m.App.Session.Remove(r.Context(), "unpaid_reservation")
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+//renderPayment renders the payment form of a reservation
+func (m *Repository) renderPayment(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	intMap := make(map[string]int)
+	intMap["deposit"] = m.deposit(res)
+	intMap["deposit_percent"] = m.App.Payment.DepositPercent
+	render.Template(w, "payment.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		StrMap: stringMap,
+		IntMap: intMap,
+	})
+}
+
+//PaymentWebhook takes the events the payment provider sends us, like refunds made in its dashboard
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	ev, err := m.App.Payments.VerifyWebhook(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid webhook")
+		return
+	}
+	if ev.ID == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid webhook")
+		return
+	}
+	// events we don't use are answered anyway, or the provider keeps sending them. so are events we had before
+	switch ev.Type {
+	case payments.EventCaptured, payments.EventRefunded, payments.EventFailed:
+		_, err = m.DB.ApplyPaymentEvent(m.App.Payments.Name(), ev.Reference, ev.ID, func(pay *models.Payment) {
+			applyPaymentEvent(pay, ev)
+		})
+		if err != nil {
+			m.writeJSONDBError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"received": true})
+}
+
+//applyPaymentEvent changes pay the way ev says. a payment only moves forward, from authorized to captured or
+// failed and from captured to refunded, so an event that arrives late changes nothing. refunds add up to the
+// captured amount at most
+func applyPaymentEvent(pay *models.Payment, ev payments.Event) {
+	switch ev.Type {
+	case payments.EventCaptured:
+		if pay.Status == models.PaymentAuthorized {
+			pay.Status = models.PaymentCaptured
+		}
+	case payments.EventRefunded:
+		if pay.Status != models.PaymentCaptured || ev.Amount <= 0 {
+			return
+		}
+		pay.Refunded += ev.Amount
+		if pay.Refunded >= pay.Amount {
+			pay.Refunded = pay.Amount
+			pay.Status = models.PaymentRefunded
+		}
+	case payments.EventFailed:
+		if pay.Status == models.PaymentAuthorized {
+			pay.Status = models.PaymentFailed
+		}
+	}
+}
+
+//adminPayment reads the payment of a reservation from the url, on error the user is sent back and ok is false
+func (m *Repository) adminPayment(w http.ResponseWriter, r *http.Request) (models.Payment, string, bool) {
+	back := fmt.Sprintf("/admin/reservations/%s/%s/show", chi.URLParam(r, "src"), chi.URLParam(r, "id"))
+	reservationID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return models.Payment{}, "", false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "paymentID"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return models.Payment{}, "", false
+	}
+	pay, err := m.DB.GetPaymentByID(id)
+	if err != nil || pay.ReservationID != reservationID {
+		m.App.Session.Put(r.Context(), "error", "can't find the payment!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return models.Payment{}, "", false
+	}
+	return pay, back, true
+}
+
+//AdminCapturePayment takes a deposit that is only authorized, like when capturing it failed while booking
+func (m *Repository) AdminCapturePayment(w http.ResponseWriter, r *http.Request) {
+	pay, back, ok := m.adminPayment(w, r)
+	if !ok {
+		return
+	}
+	if pay.Status != models.PaymentAuthorized {
+		m.App.Session.Put(r.Context(), "error", "only authorized payments can be captured!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	err := m.App.Payments.Capture(pay.Reference, pay.Amount)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "the provider did not capture the payment: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	before := paymentAudit(pay)
+	pay.Status = models.PaymentCaptured
+	err = m.DB.UpdatePayment(pay, auditEntry(r, models.AuditCapture, models.AuditPayment, pay.ID, before, paymentAudit(pay)))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Payment captured!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//AdminRefundPayment gives back what is left of a captured payment, or releases one that is only authorized
+func (m *Repository) AdminRefundPayment(w http.ResponseWriter, r *http.Request) {
+	pay, back, ok := m.adminPayment(w, r)
+	if !ok {
+		return
+	}
+	if pay.Status != models.PaymentAuthorized && pay.Status != models.PaymentCaptured {
+		m.App.Session.Put(r.Context(), "error", "this payment can't be refunded!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	err := m.App.Payments.Refund(pay.Reference, pay.Amount-pay.Refunded)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "the provider did not refund the payment: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	before := paymentAudit(pay)
+	pay.Refunded = pay.Amount
+	pay.Status = models.PaymentRefunded
+	err = m.DB.UpdatePayment(pay, auditEntry(r, models.AuditRefund, models.AuditPayment, pay.ID, before, paymentAudit(pay)))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Payment refunded!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/payments"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//unpaidTestReservation is a saturday night in room, 120.00 at the weekend rate of the test repo
+func unpaidTestReservation(roomID int) models.Reservation {
+	return models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		RoomID:     roomID,
+		Room:       models.Room{ID: roomID, RoomName: "General's Quarters"},
+		TotalPrice: 12000,
+	}
+}
+
+func TestRepository_ReservationPayment(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/make-reservation/payment", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationPayment).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("payment without reservation: expected code %d got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/make-reservation/payment", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "unpaid_reservation", unpaidTestReservation(1))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.ReservationPayment).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("payment: expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	// 20% of 120.00
+	if !strings.Contains(rr.Body.String(), "$24.00") {
+		t.Error("payment: expected the deposit on the page")
+	}
+}
+
+var postReservationPaymentTests = []struct {
+	name             string
+	roomID           int // -1 puts no reservation in the session, -2 a free stay in room 1
+	card             string
+	expectedCode     int
+	expectedLocation string
+	expectedHtml     string
+	expectedStatus   string
+}{
+	{"pays and books", 1, "4242 4242 4242 4242", http.StatusSeeOther, "/reservation-summary", "", models.PaymentCaptured},
+	{"capture fails", 1, payments.FakeCardCaptureFails, http.StatusSeeOther, "/reservation-summary", "", models.PaymentAuthorized},
+	{"no card", 1, "", http.StatusOK, "", `id="card"`, ""},
+	{"declined card", 1, payments.FakeCardDeclined, http.StatusOK, "", "Your card was declined", ""},
+	{"failed insertion", 0, "4242424242424242", http.StatusSeeOther, "/", "", ""},
+	{"room booked in the meantime", 2, "4242424242424242", http.StatusSeeOther, "/search-availability", "", ""},
+	{"no reservation in session", -1, "4242424242424242", http.StatusSeeOther, "/", "", ""},
+	{"free stay", -2, "", http.StatusSeeOther, "/reservation-summary", "", ""},
+}
+
+func TestRepository_PostReservationPayment(t *testing.T) {
+	for _, e := range postReservationPaymentTests {
+		req, _ := http.NewRequest("POST", "/make-reservation/payment", strings.NewReader(url.Values{"card": {e.card}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.roomID >= 0 {
+			session.Put(ctx, "unpaid_reservation", unpaidTestReservation(e.roomID))
+		} else if e.roomID == -2 {
+			res := unpaidTestReservation(1)
+			res.TotalPrice = 0
+			session.Put(ctx, "unpaid_reservation", res)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservationPayment).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedHtml != "" && !strings.Contains(rr.Body.String(), e.expectedHtml) {
+			t.Errorf("%s: expected %s in the page", e.name, e.expectedHtml)
+		}
+		if e.expectedStatus != "" {
+			res, ok := session.Get(ctx, "reservation").(models.Reservation)
+			if !ok || len(res.Payments) != 1 {
+				t.Errorf("%s: expected the paid reservation in the session", e.name)
+				continue
+			}
+			pay := res.Payments[0]
+			if pay.Status != e.expectedStatus || pay.Amount != 2400 || pay.Provider != "fake" {
+				t.Errorf("%s: unexpected payment %+v", e.name, pay)
+			}
+			if session.Exists(ctx, "unpaid_reservation") {
+				t.Errorf("%s: expected the unpaid reservation to be gone", e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	fake := payments.NewFake("secret")
+	var tests = []struct {
+		name         string
+		body         string
+		signature    string
+		expectedCode int
+	}{
+		{"refund", `{"id":"evt_1","type":"payment.refunded","reference":"fake_1","amount":2000}`, "", http.StatusOK},
+		{"event we had before", `{"id":"evt_seen","type":"payment.refunded","reference":"fake_1","amount":2000}`, "", http.StatusOK},
+		{"event we don't use", `{"id":"evt_2","type":"payment.disputed","reference":"fake_1"}`, "", http.StatusOK},
+		{"unknown payment", `{"id":"evt_3","type":"payment.captured","reference":"fake_unknown"}`, "", http.StatusNotFound},
+		{"no event id", `{"type":"payment.captured","reference":"fake_1"}`, "", http.StatusBadRequest},
+		{"not signed by the provider", `{"id":"evt_4","type":"payment.captured","reference":"fake_1"}`, "00", http.StatusBadRequest},
+	}
+	for _, e := range tests {
+		signature := e.signature
+		if signature == "" {
+			signature = fake.Sign([]byte(e.body))
+		}
+		req := httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(e.body))
+		req.Header.Set(payments.FakeSignatureHeader, signature)
+		rr := httptest.NewRecorder()
+		getRoutes().ServeHTTP(rr, req)
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func TestApplyPaymentEvent(t *testing.T) {
+	var tests = []struct {
+		name             string
+		status           string
+		refunded         int
+		event            payments.Event
+		expectedStatus   string
+		expectedRefunded int
+	}{
+		{"capture", models.PaymentAuthorized, 0, payments.Event{Type: payments.EventCaptured}, models.PaymentCaptured, 0},
+		{"late capture after a refund", models.PaymentRefunded, 2000, payments.Event{Type: payments.EventCaptured}, models.PaymentRefunded, 2000},
+		{"late failure after a capture", models.PaymentCaptured, 0, payments.Event{Type: payments.EventFailed}, models.PaymentCaptured, 0},
+		{"failure", models.PaymentAuthorized, 0, payments.Event{Type: payments.EventFailed}, models.PaymentFailed, 0},
+		{"partial refund", models.PaymentCaptured, 0, payments.Event{Type: payments.EventRefunded, Amount: 500}, models.PaymentCaptured, 500},
+		{"refund above the amount", models.PaymentCaptured, 1500, payments.Event{Type: payments.EventRefunded, Amount: 1000}, models.PaymentRefunded, 2000},
+		{"negative refund", models.PaymentCaptured, 500, payments.Event{Type: payments.EventRefunded, Amount: -500}, models.PaymentCaptured, 500},
+		{"refund before the capture", models.PaymentAuthorized, 0, payments.Event{Type: payments.EventRefunded, Amount: 500}, models.PaymentAuthorized, 0},
+	}
+	for _, e := range tests {
+		pay := models.Payment{Amount: 2000, Status: e.status, Refunded: e.refunded}
+		applyPaymentEvent(&pay, e.event)
+		if pay.Status != e.expectedStatus || pay.Refunded != e.expectedRefunded {
+			t.Errorf("%s: expected %s with %d refunded got %s with %d", e.name, e.expectedStatus, e.expectedRefunded,
+				pay.Status, pay.Refunded)
+		}
+	}
+}
+
+func TestRepository_AdminPayments(t *testing.T) {
+	var tests = []struct {
+		name             string
+		handler          http.HandlerFunc
+		reservationID    string
+		paymentID        string
+		expectedLocation string
+		expectedFlash    string
+		expectedError    string
+	}{
+		{"capture", Repo.AdminCapturePayment, "1", "1", "/admin/reservations/all/1/show", "Payment captured!", ""},
+		{"capture twice", Repo.AdminCapturePayment, "1", "2", "/admin/reservations/all/1/show", "", "only authorized payments can be captured!"},
+		{"refund", Repo.AdminRefundPayment, "1", "2", "/admin/reservations/all/1/show", "Payment refunded!", ""},
+		{"release an authorization", Repo.AdminRefundPayment, "1", "1", "/admin/reservations/all/1/show", "Payment refunded!", ""},
+		{"unknown payment", Repo.AdminRefundPayment, "1", "1001", "/admin/reservations/all/1/show", "", "can't find the payment!"},
+		{"payment of another reservation", Repo.AdminCapturePayment, "5", "1", "/admin/reservations/all/5/show", "", "can't find the payment!"},
+		{"bad payment id", Repo.AdminCapturePayment, "1", "x", "/admin/reservations/all/1/show", "", "error in url!"},
+		{"bad reservation id", Repo.AdminRefundPayment, "x", "1", "/admin/dashboard", "", "error in url!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.reservationID+"/payments/"+e.paymentID, nil)
+		rr, ctx := serveRoomRequest(e.handler, req, map[string]string{"src": "all", "id": e.reservationID, "paymentID": e.paymentID})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: expected code %d got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
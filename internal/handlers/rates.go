@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//quote prices a stay in a room with the room's current rates
+func (m *Repository) quote(roomID int, start, end time.Time) (pricing.Quote, error) {
+	rates, err := m.DB.GetRoomRates(roomID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	return rates.Quote(start, end)
+}
+
+//roomRateIDs reads the room id and the id of one of its overrides, discounts or calendars (param) from the url,
+// on error the user is sent back and ok is false
+func (m *Repository) roomRateIDs(w http.ResponseWriter, r *http.Request, param string) (int, int, bool) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return 0, 0, false
+	}
+	id := 0
+	if param != "" {
+		id, err = strconv.Atoi(chi.URLParam(r, param))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", roomID), http.StatusSeeOther)
+			return 0, 0, false
+		}
+	}
+	return roomID, id, true
+}
+
+//PostAdminRoomOverride adds a rate override to a room: a date range, or a season that comes back every year
+func (m *Repository) PostAdminRoomOverride(w http.ResponseWriter, r *http.Request) {
+	roomID, _, ok := m.roomRateIDs(w, r, "")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "start", "end", "nightly_rate")
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	}
+	yearly := r.Form.Get("yearly") == "1"
+	// a season may go over new year, like december 20 to january 5
+	if form.Valid() && !yearly && endDate.Before(startDate) {
+		form.Errors.Add("end", "The last night can't be before the first one")
+	}
+	rate, err := pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil || rate <= 0 {
+		form.Errors.Add("nightly_rate", "Enter a price like 120 or 120.50")
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "can't add the rate: "+formErrors(form))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	o := pricing.Override{
+		RoomID:      roomID,
+		Name:        strings.TrimSpace(r.Form.Get("name")),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		NightlyRate: rate,
+		Yearly:      yearly,
+	}
+	o.ID, err = m.DB.InsertRateOverride(o, auditEntry(r, models.AuditCreate, models.AuditRateOverride, 0, nil, map[string]interface{}{
+		"room_id": o.RoomID, "name": o.Name, "start_date": o.StartDate.Format("2006-01-02"),
+		"end_date": o.EndDate.Format("2006-01-02"), "nightly_rate": o.NightlyRate, "yearly": o.Yearly,
+	}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Rate added!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//AdminDeleteRoomOverride removes a rate override of a room
+func (m *Repository) AdminDeleteRoomOverride(w http.ResponseWriter, r *http.Request) {
+	roomID, id, ok := m.roomRateIDs(w, r, "overrideID")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	err := m.DB.DeleteRateOverride(id, roomID,
+		auditEntry(r, models.AuditDelete, models.AuditRateOverride, id, map[string]int{"room_id": roomID}, nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the rate!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Rate deleted!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//PostAdminRoomDiscount adds a length of stay discount to a room
+func (m *Repository) PostAdminRoomDiscount(w http.ResponseWriter, r *http.Request) {
+	roomID, _, ok := m.roomRateIDs(w, r, "")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("min_nights", "percent")
+	form.IsNumber("min_nights", 2)
+	if form.IsNumber("percent", 1) {
+		if percent, _ := strconv.Atoi(r.Form.Get("percent")); percent > 100 {
+			form.Errors.Add("percent", "This field must be at most 100")
+		}
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "can't add the discount: "+formErrors(form))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	minNights, _ := strconv.Atoi(r.Form.Get("min_nights"))
+	percent, _ := strconv.Atoi(r.Form.Get("percent"))
+	d := pricing.Discount{
+		RoomID:    roomID,
+		MinNights: minNights,
+		Percent:   percent,
+	}
+	d.ID, err = m.DB.InsertStayDiscount(d, auditEntry(r, models.AuditCreate, models.AuditStayDiscount, 0, nil,
+		map[string]int{"room_id": d.RoomID, "min_nights": d.MinNights, "percent": d.Percent}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Discount added!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//AdminDeleteRoomDiscount removes a stay discount of a room
+func (m *Repository) AdminDeleteRoomDiscount(w http.ResponseWriter, r *http.Request) {
+	roomID, id, ok := m.roomRateIDs(w, r, "discountID")
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", roomID)
+	err := m.DB.DeleteStayDiscount(id, roomID,
+		auditEntry(r, models.AuditDelete, models.AuditStayDiscount, id, map[string]int{"room_id": roomID}, nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the discount!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Discount deleted!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//formErrors puts the errors of a form in one line, for forms that redirect instead of rendering again
+func formErrors(form *forms.Form) string {
+	var msgs []string
+	for field, errs := range form.Errors {
+		msgs = append(msgs, field+": "+strings.Join(errs, ", "))
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRepository_AdminRoomRates(t *testing.T) {
+	var tests = []struct {
+		name             string
+		handler          http.HandlerFunc
+		params           map[string]string
+		postedData       url.Values
+		expectedLocation string
+		expectedFlash    string
+		expectedError    string
+	}{
+		{
+			name:             "add a rate",
+			handler:          Repo.PostAdminRoomOverride,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"name": {"Summer"}, "start": {"2050-07-01"}, "end": {"2050-08-31"}, "nightly_rate": {"150"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedFlash:    "Rate added!",
+		},
+		{
+			name:             "add a season over new year",
+			handler:          Repo.PostAdminRoomOverride,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"name": {"Holidays"}, "start": {"2050-12-20"}, "end": {"2050-01-05"}, "nightly_rate": {"180.50"}, "yearly": {"1"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedFlash:    "Rate added!",
+		},
+		{
+			name:             "rate ends before it starts",
+			handler:          Repo.PostAdminRoomOverride,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"name": {"Summer"}, "start": {"2050-08-31"}, "end": {"2050-07-01"}, "nightly_rate": {"150"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't add the rate: end: The last night can't be before the first one",
+		},
+		{
+			name:             "rate is not a price",
+			handler:          Repo.PostAdminRoomOverride,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"name": {"Summer"}, "start": {"2050-07-01"}, "end": {"2050-08-31"}, "nightly_rate": {"free"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't add the rate: nightly_rate: Enter a price like 120 or 120.50",
+		},
+		{
+			name:             "rate bad room id",
+			handler:          Repo.PostAdminRoomOverride,
+			params:           map[string]string{"id": "x"},
+			expectedLocation: "/admin/rooms",
+			expectedError:    "error in url!",
+		},
+		{
+			name:             "delete a rate",
+			handler:          Repo.AdminDeleteRoomOverride,
+			params:           map[string]string{"id": "1", "overrideID": "1"},
+			expectedLocation: "/admin/rooms/1",
+			expectedFlash:    "Rate deleted!",
+		},
+		{
+			name:             "delete unknown rate",
+			handler:          Repo.AdminDeleteRoomOverride,
+			params:           map[string]string{"id": "1", "overrideID": "1001"},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't find the rate!",
+		},
+		{
+			name:             "delete rate bad id",
+			handler:          Repo.AdminDeleteRoomOverride,
+			params:           map[string]string{"id": "1", "overrideID": "x"},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "error in url!",
+		},
+		{
+			name:             "add a discount",
+			handler:          Repo.PostAdminRoomDiscount,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"min_nights": {"7"}, "percent": {"10"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedFlash:    "Discount added!",
+		},
+		{
+			name:             "discount for one night",
+			handler:          Repo.PostAdminRoomDiscount,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"min_nights": {"1"}, "percent": {"10"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't add the discount: min_nights: This field must be at least 2",
+		},
+		{
+			name:             "discount over 100 percent",
+			handler:          Repo.PostAdminRoomDiscount,
+			params:           map[string]string{"id": "1"},
+			postedData:       url.Values{"min_nights": {"7"}, "percent": {"110"}},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't add the discount: percent: This field must be at most 100",
+		},
+		{
+			name:             "delete a discount",
+			handler:          Repo.AdminDeleteRoomDiscount,
+			params:           map[string]string{"id": "1", "discountID": "1"},
+			expectedLocation: "/admin/rooms/1",
+			expectedFlash:    "Discount deleted!",
+		},
+		{
+			name:             "delete unknown discount",
+			handler:          Repo.AdminDeleteRoomDiscount,
+			params:           map[string]string{"id": "1", "discountID": "1001"},
+			expectedLocation: "/admin/rooms/1",
+			expectedError:    "can't find the discount!",
+		},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.params["id"], strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(e.handler, req, e.params)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+			continue
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, session.GetString(ctx, "flash"))
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// restrictions are the kinds of unavailability a room can have. reservations, owner blocks and external
+// bookings are looked up by their code, everything else is up to the managers
+
+//restrictionID looks up the id of a restriction the code relies on, like models.RestrictionReservation
+func (m *Repository) restrictionID(code string) (int, error) {
+	restriction, err := m.DB.GetRestrictionByCode(code)
+	if err != nil {
+		return 0, fmt.Errorf("restriction %s: %w", code, err)
+	}
+	return restriction.ID, nil
+}
+
+//AdminRestrictions lists the restrictions with how often they are used
+func (m *Repository) AdminRestrictions(w http.ResponseWriter, r *http.Request) {
+	restrictions, err := m.DB.AllRestrictions()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["restrictions"] = restrictions
+	render.Template(w, "admin-restrictions.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+//AdminRestriction shows the form to edit a restriction, id "new" shows an empty one
+func (m *Repository) AdminRestriction(w http.ResponseWriter, r *http.Request) {
+	restriction := models.Restriction{Colour: "#6c757d", Unavailable: true}
+	if chi.URLParam(r, "id") != "new" {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+		restriction, err = m.DB.GetRestrictionByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find the restriction!")
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+	}
+	form := forms.New(url.Values{
+		"restriction_name": {restriction.RestrictionName},
+		"colour":           {restriction.Colour},
+	})
+	if restriction.Unavailable {
+		form.Set("unavailable", "1")
+	}
+	m.renderAdminRestriction(w, r, restriction, form)
+}
+
+//renderAdminRestriction renders the form of a restriction, the values come from form
+func (m *Repository) renderAdminRestriction(w http.ResponseWriter, r *http.Request, restriction models.Restriction, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["restriction"] = restriction
+	render.Template(w, "admin-restriction.page.tmpl", r, &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+//PostAdminRestriction creates a restriction (id "new") or stores the changes of one.
+// the restrictions the code relies on always count as unavailable
+func (m *Repository) PostAdminRestriction(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var restriction models.Restriction
+	if chi.URLParam(r, "id") != "new" {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+		restriction, err = m.DB.GetRestrictionByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find the restriction!")
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+	}
+
+	before := restrictionAudit(restriction)
+	form := forms.New(r.PostForm)
+	form.Required("restriction_name", "colour")
+	form.IsColour("colour")
+	restriction.RestrictionName = strings.TrimSpace(r.Form.Get("restriction_name"))
+	restriction.Colour = strings.ToLower(r.Form.Get("colour"))
+	restriction.Unavailable = restriction.IsSystem() || r.Form.Get("unavailable") == "1"
+	if !form.Valid() {
+		m.renderAdminRestriction(w, r, restriction, form)
+		return
+	}
+
+	if restriction.ID == 0 {
+		restriction.ID, err = m.DB.InsertRestriction(restriction,
+			auditEntry(r, models.AuditCreate, models.AuditRestriction, 0, nil, restrictionAudit(restriction)))
+	} else {
+		err = m.DB.UpdateRestriction(restriction,
+			auditEntry(r, models.AuditUpdate, models.AuditRestriction, restriction.ID, before, restrictionAudit(restriction)))
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the restriction!")
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Restriction saved!")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
+
+//AdminDeleteRestriction deletes a restriction nobody uses, the ones the code relies on stay
+func (m *Repository) AdminDeleteRestriction(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+	restriction, err := m.DB.GetRestrictionByID(id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find the restriction!")
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+	switch {
+	case restriction.IsSystem():
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is needed, it can only be renamed", restriction.RestrictionName))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	case restriction.Uses > 0:
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is still used %d times", restriction.RestrictionName, restriction.Uses))
+		http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.DeleteRestriction(id, auditEntry(r, models.AuditDelete, models.AuditRestriction, id, restrictionAudit(restriction), nil))
+	if err != nil {
+		// somebody used it in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is in use now", restriction.RestrictionName))
+			http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Restriction deleted!")
+	http.Redirect(w, r, "/admin/restrictions", http.StatusSeeOther)
+}
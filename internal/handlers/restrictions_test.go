@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var postAdminRestrictionTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedFlash    string
+	expectedHTML     string
+}{
+	{"new restriction", "new", url.Values{"restriction_name": {"Deep Clean"}, "colour": {"#20c997"}, "unavailable": {"1"}},
+		http.StatusSeeOther, "/admin/restrictions", "Restriction saved!", ""},
+	{"rename a needed one", "1", url.Values{"restriction_name": {"Booking"}, "colour": {"#dc3545"}},
+		http.StatusSeeOther, "/admin/restrictions", "Restriction saved!", ""},
+	{"no such restriction", "9", url.Values{"restriction_name": {"Deep Clean"}, "colour": {"#20c997"}},
+		http.StatusSeeOther, "/admin/restrictions", "", ""},
+	{"bad id", "x", url.Values{"restriction_name": {"Deep Clean"}, "colour": {"#20c997"}},
+		http.StatusSeeOther, "/admin/restrictions", "", ""},
+	{"no name", "new", url.Values{"colour": {"#20c997"}}, http.StatusOK, "", "", "This field cannot be blank!"},
+	{"bad colour", "new", url.Values{"restriction_name": {"Deep Clean"}, "colour": {"green"}}, http.StatusOK, "", "", "Choose a colour like #17a2b8"},
+}
+
+func TestRepository_PostAdminRestriction(t *testing.T) {
+	for _, e := range postAdminRestrictionTests {
+		req, _ := http.NewRequest("POST", "/admin/restrictions/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostAdminRestriction, req, map[string]string{"id": e.id})
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminDeleteRestrictionTests = []struct {
+	name          string
+	id            string
+	expectedFlash string
+	expectedError string
+}{
+	{"unused", "6", "Restriction deleted!", ""},
+	{"in use", "4", "", "Maintenance is still used 1 times"},
+	{"needed", "2", "", "Owner Block is needed, it can only be renamed"},
+	{"no such restriction", "9", "", "can't find the restriction!"},
+}
+
+func TestRepository_AdminDeleteRestriction(t *testing.T) {
+	for _, e := range adminDeleteRestrictionTests {
+		req, _ := http.NewRequest("POST", "/admin/restrictions/"+e.id+"/delete", nil)
+		rr, ctx := serveRoomRequest(Repo.AdminDeleteRestriction, req, map[string]string{"id": e.id})
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/restrictions" {
+			t.Errorf("%s: expected location /admin/restrictions got %s", e.name, actualLoc.String())
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_AdminRestrictions(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/restrictions", nil)
+	rr, _ := serveRoomRequest(Repo.AdminRestrictions, req, nil)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "No, only a note") {
+		t.Error("expected owner stay to be only a note")
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/restrictions/1", nil)
+	rr, _ = serveRoomRequest(Repo.AdminRestriction, req, map[string]string{"id": "1"})
+	if !strings.Contains(rr.Body.String(), "always unavailable") {
+		t.Error("expected the reservation restriction to be always unavailable")
+	}
+}
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"github.com/majedutd990/bookings/internal/render"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+//roomUploadDir is where uploaded room photos are stored, it is served as roomUploadURL.
+// tests point it to a temp dir
+var roomUploadDir = "./static/uploads/rooms"
+
+//roomUploadURL is the url of roomUploadDir
+const roomUploadURL = "/static/uploads/rooms/"
+
+//maxRoomImageSize is the biggest photo we accept
+const maxRoomImageSize = 5 << 20
+
+//roomImageTypes are the content types we accept for photos, with the extension we store them with
+var roomImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+//Rooms lists the rooms that are on the site
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.GetActiveRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	render.Template(w, "rooms.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+//RoomPage renders the page of one room with its gallery, rooms that are taken off the site are not found
+func (m *Repository) RoomPage(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, http.StatusNotFound)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	if !room.Active {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	data := make(map[string]interface{})
+	data["room"] = room
+	render.Template(w, "room.page.tmpl", r, &models.TemplateData{
+		Data: data,
+	})
+}
+
+//RedirectRoom sends the old hard-coded room urls to their /rooms/{slug} page
+func RedirectRoom(slug string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/rooms/"+slug, http.StatusMovedPermanently)
+	}
+}
+
+//AdminRooms lists all rooms, with buttons to take them off the site and to change their order
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	stringMap := make(map[string]string)
+	stringMap["ical_url"] = m.icalURL("property", "/ical/property.ics")
+	render.Template(w, "admin-rooms.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		StrMap: stringMap,
+	})
+}
+
+//AdminRoom shows the form to edit a room, its rates and its photos, id "new" shows an empty form
+func (m *Repository) AdminRoom(w http.ResponseWriter, r *http.Request) {
+	room := models.Room{MaxOccupancy: 2, Active: true}
+	if chi.URLParam(r, "id") != "new" {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		room, err = m.DB.GetRoomByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find the room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+	}
+	m.renderAdminRoom(w, r, room, forms.New(nil))
+}
+
+//PostAdminRoom creates a room (id "new") or stores the changes of one
+func (m *Repository) PostAdminRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var room models.Room
+	if chi.URLParam(r, "id") != "new" {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "error in url!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		room, err = m.DB.GetRoomByID(id)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find the room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+	} else {
+		room.Active = true
+	}
+	before := roomAudit(room)
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "max_occupancy", "base_rate")
+	form.IsSlug("slug")
+	form.IsNumber("max_occupancy", 1)
+	room.BaseRate, err = pricing.ParseAmount(r.Form.Get("base_rate"))
+	if err != nil || room.BaseRate <= 0 {
+		form.Errors.Add("base_rate", "Enter a price like 120 or 120.50")
+	}
+	// no weekend rate means the base rate on weekends too
+	room.WeekendRate = 0
+	if r.Form.Get("weekend_rate") != "" {
+		room.WeekendRate, err = pricing.ParseAmount(r.Form.Get("weekend_rate"))
+		if err != nil {
+			form.Errors.Add("weekend_rate", "Enter a price like 120 or 120.50")
+		}
+	}
+
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Slug = r.Form.Get("slug")
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.MaxOccupancy, _ = strconv.Atoi(r.Form.Get("max_occupancy"))
+	room.Amenities = nil
+	for _, a := range strings.Split(r.Form.Get("amenities"), "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			room.Amenities = append(room.Amenities, a)
+		}
+	}
+
+	// slugs are unique, tell the user instead of failing on the index
+	if form.Valid() {
+		other, err := m.DB.GetRoomBySlug(room.Slug)
+		if err == nil && other.ID != room.ID {
+			form.Errors.Add("slug", fmt.Sprintf("%s already uses this slug", other.RoomName))
+		} else if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	if room.ID == 0 {
+		room.ID, err = m.DB.InsertRoom(room, auditEntry(r, models.AuditCreate, models.AuditRoom, 0, nil, roomAudit(room)))
+	} else {
+		err = m.DB.UpdateRoom(room, auditEntry(r, models.AuditUpdate, models.AuditRoom, room.ID, before, roomAudit(room)))
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Room saved!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+//renderAdminRoom renders the form of a room, with the photos, rates and calendars of rooms that exist already
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	stringMap := map[string]string{
+		"amenities": strings.Join(room.Amenities, "\n"),
+	}
+	if room.BaseRate > 0 {
+		stringMap["base_rate"] = pricing.Format(room.BaseRate)
+	}
+	if room.WeekendRate > 0 {
+		stringMap["weekend_rate"] = pricing.Format(room.WeekendRate)
+	}
+	if room.ID > 0 {
+		var err error
+		room.Images, err = m.DB.GetRoomImages(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		rates, err := m.DB.GetRoomRates(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["rates"] = rates
+		data["ical_feeds"], err = m.DB.GetICalFeedsForRoom(room.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		stringMap["ical_url"] = m.icalURL(roomFeed(room.ID), fmt.Sprintf("/ical/rooms/%d.ics", room.ID))
+	}
+	data["room"] = room
+	render.Template(w, "admin-room.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		Form:   form,
+		StrMap: stringMap,
+	})
+}
+
+//AdminRoomActive puts a room on the site (active=1) or takes it off (active=0),
+// reservations of a room that is off the site stay as they are
+func (m *Repository) AdminRoomActive(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	active := r.Form.Get("active") == "1"
+	err = m.DB.SetRoomActive(id, active, auditEntry(r, models.AuditUpdate, models.AuditRoom, id, nil, map[string]bool{"active": active}))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the room!")
+			http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	if active {
+		m.App.Session.Put(r.Context(), "flash", "Room is on the site again!")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room taken off the site!")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//AdminMoveRoom moves a room one place up or down (direction=up|down) in the list on the site
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.GetAllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	ids, ok := moveRoom(rooms, id, r.Form.Get("direction") == "up")
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't find the room!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.UpdateRoomSortOrder(ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+//moveRoom returns the ids of rooms in their new order, with room id swapped with its neighbour.
+// moving the first room up or the last one down changes nothing
+func moveRoom(rooms []models.Room, id int, up bool) ([]int, bool) {
+	ids := make([]int, len(rooms))
+	pos := -1
+	for i, room := range rooms {
+		ids[i] = room.ID
+		if room.ID == id {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return nil, false
+	}
+	other := pos + 1
+	if up {
+		other = pos - 1
+	}
+	if other >= 0 && other < len(ids) {
+		ids[pos], ids[other] = ids[other], ids[pos]
+	}
+	return ids, true
+}
+
+//PostAdminRoomImage uploads a photo (field "image") to the gallery of a room
+func (m *Repository) PostAdminRoomImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", id)
+
+	// the size of the body is limited by the LimitBody middleware
+	err = r.ParseMultipartForm(maxRoomImageSize)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't read the upload: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	file, header, err := r.FormFile("image")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "choose a photo to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+	if header.Size > maxRoomImageSize {
+		m.App.Session.Put(r.Context(), "error", "the photo is too big, it may have 5 MB at most")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	// trust the content, not the name or the header the browser sent
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		helpers.ServerError(w, err)
+		return
+	}
+	ext, ok := roomImageTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "only jpeg, png, gif and webp photos can be uploaded")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.GetRoomByID(id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find the room!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	name, err := saveRoomImage(id, ext, io.MultiReader(bytes.NewReader(head[:n]), file))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	img := models.RoomImage{
+		RoomID:  id,
+		Path:    roomUploadURL + name,
+		Caption: strings.TrimSpace(r.Form.Get("caption")),
+	}
+	img.ID, err = m.DB.InsertRoomImage(img, auditEntry(r, models.AuditCreate, models.AuditRoomImage, 0, nil,
+		map[string]interface{}{"room_id": img.RoomID, "path": img.Path, "caption": img.Caption}))
+	if err != nil {
+		_ = os.Remove(filepath.Join(roomUploadDir, name))
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Photo uploaded!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+//saveRoomImage writes a photo of room roomID to roomUploadDir and returns its file name
+func saveRoomImage(roomID int, ext string, content io.Reader) (string, error) {
+	err := os.MkdirAll(roomUploadDir, 0755)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(roomUploadDir, fmt.Sprintf("room-%d-*%s", roomID, ext))
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+	// TempFile creates the file only readable by us, the file server needs to read it too
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(f.Name()), nil
+}
+
+//AdminDeleteRoomImage removes a photo from the gallery of a room, and its file if it was uploaded
+func (m *Repository) AdminDeleteRoomImage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", id)
+	imageID, err := strconv.Atoi(chi.URLParam(r, "imageID"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	// the audit log keeps what was deleted
+	images, err := m.DB.GetRoomImages(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var before interface{}
+	for _, img := range images {
+		if img.ID == imageID {
+			before = map[string]interface{}{"room_id": img.RoomID, "path": img.Path, "caption": img.Caption}
+		}
+	}
+	img, err := m.DB.DeleteRoomImage(imageID, id, auditEntry(r, models.AuditDelete, models.AuditRoomImage, imageID, before, nil))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the photo!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	// photos that came with the site (/static/images) stay, only uploads are ours to remove
+	if strings.HasPrefix(img.Path, roomUploadURL) {
+		err = os.Remove(filepath.Join(roomUploadDir, filepath.Base(img.Path)))
+		if err != nil && !os.IsNotExist(err) {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted!")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/models"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//serveRoomRequest runs handler for req with the given url params and returns the response and the session context
+func serveRoomRequest(handler http.HandlerFunc, req *http.Request, params map[string]string) (*httptest.ResponseRecorder, context.Context) {
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+var postAdminRoomTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedFormError  string
+}{
+	{
+		name:               "new room",
+		id:                 "new",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"old-cabin"}, "max_occupancy": {"4"}, "base_rate": {"120"}, "amenities": {"Fireplace\r\n\r\nSauna"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms/3",
+	},
+	{
+		name:               "edit room keeps its slug",
+		id:                 "1",
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "max_occupancy": {"2"}, "base_rate": {"$100.00"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms/1",
+	},
+	{
+		name:               "slug of another room",
+		id:                 "1",
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"majors-suite"}, "max_occupancy": {"2"}, "base_rate": {"$100.00"}},
+		expectedStatusCode: http.StatusOK,
+		expectedFormError:  "slug",
+	},
+	{
+		name:               "bad slug",
+		id:                 "new",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"Old Cabin"}, "max_occupancy": {"4"}, "base_rate": {"120"}},
+		expectedStatusCode: http.StatusOK,
+		expectedFormError:  "slug",
+	},
+	{
+		name:               "nobody fits in",
+		id:                 "new",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"old-cabin"}, "max_occupancy": {"0"}, "base_rate": {"120"}},
+		expectedStatusCode: http.StatusOK,
+		expectedFormError:  "max_occupancy",
+	},
+	{
+		name:               "no rate",
+		id:                 "new",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"old-cabin"}, "max_occupancy": {"4"}, "base_rate": {"cheap"}},
+		expectedStatusCode: http.StatusOK,
+		expectedFormError:  "base_rate",
+	},
+	{
+		name:               "weekend rate is not a price",
+		id:                 "1",
+		postedData:         url.Values{"room_name": {"General's Quarters"}, "slug": {"generals-quarters"}, "max_occupancy": {"2"}, "base_rate": {"100"}, "weekend_rate": {"1.234"}},
+		expectedStatusCode: http.StatusOK,
+		expectedFormError:  "weekend_rate",
+	},
+	{
+		name:               "room deleted in the meantime",
+		id:                 "2",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"old-cabin"}, "max_occupancy": {"4"}, "base_rate": {"120"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+	{
+		name:               "unknown room",
+		id:                 "5",
+		postedData:         url.Values{"room_name": {"Old Cabin"}, "slug": {"old-cabin"}, "max_occupancy": {"4"}, "base_rate": {"120"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/rooms",
+	},
+}
+
+func TestRepository_PostAdminRoom(t *testing.T) {
+	for _, e := range postAdminRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, _ := serveRoomRequest(Repo.PostAdminRoom, req, map[string]string{"id": e.id})
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedFormError != "" && !strings.Contains(rr.Body.String(), `id="`+e.expectedFormError+`"`) {
+			t.Errorf("failed %s: expected the form again", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminRoomActions(t *testing.T) {
+	var tests = []struct {
+		name          string
+		handler       http.HandlerFunc
+		id            string
+		postedData    url.Values
+		expectedFlash string
+		expectedError string
+	}{
+		{"take off the site", Repo.AdminRoomActive, "1", url.Values{"active": {"0"}}, "Room taken off the site!", ""},
+		{"put on the site", Repo.AdminRoomActive, "1", url.Values{"active": {"1"}}, "Room is on the site again!", ""},
+		{"active of unknown room", Repo.AdminRoomActive, "1001", url.Values{"active": {"1"}}, "", "can't find the room!"},
+		{"active bad id", Repo.AdminRoomActive, "x", url.Values{"active": {"1"}}, "", "error in url!"},
+		{"move unknown room", Repo.AdminMoveRoom, "1001", url.Values{"direction": {"up"}}, "", "can't find the room!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(e.handler, req, map[string]string{"id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/admin/rooms" {
+			t.Errorf("failed %s: expected location /admin/rooms, but got location %s", e.name, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q", e.name, e.expectedFlash)
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestMoveRoom(t *testing.T) {
+	rooms := []models.Room{{ID: 4}, {ID: 7}, {ID: 2}}
+	var tests = []struct {
+		name     string
+		id       int
+		up       bool
+		expected []int
+		ok       bool
+	}{
+		{"up", 2, true, []int{4, 2, 7}, true},
+		{"down", 4, false, []int{7, 4, 2}, true},
+		{"first up", 4, true, []int{4, 7, 2}, true},
+		{"last down", 2, false, []int{4, 7, 2}, true},
+		{"unknown", 9, true, nil, false},
+	}
+	for _, e := range tests {
+		ids, ok := moveRoom(rooms, e.id, e.up)
+		if ok != e.ok || !reflect.DeepEqual(ids, e.expected) {
+			t.Errorf("%s: expected %v %t, got %v %t", e.name, e.expected, e.ok, ids, ok)
+		}
+	}
+}
+
+//pngHeader is enough of a png for http.DetectContentType
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestRepository_PostAdminRoomImage(t *testing.T) {
+	dir := t.TempDir()
+	old := roomUploadDir
+	roomUploadDir = dir
+	defer func() { roomUploadDir = old }()
+
+	var tests = []struct {
+		name          string
+		id            string
+		content       []byte
+		expectedFlash string
+		expectedError string
+		expectedFiles int
+	}{
+		{"png", "1", pngHeader, "Photo uploaded!", "", 1},
+		{"not an image", "1", []byte("#!/bin/sh\nrm -rf /\n"), "", "only jpeg, png, gif and webp photos can be uploaded", 1},
+		{"unknown room", "5", pngHeader, "", "can't find the room!", 1},
+		{"too big", "1", append(append([]byte{}, pngHeader...), make([]byte, maxRoomImageSize)...), "", "the photo is too big, it may have 5 MB at most", 1},
+	}
+	for _, e := range tests {
+		body := new(bytes.Buffer)
+		mw := multipart.NewWriter(body)
+		fw, _ := mw.CreateFormFile("image", "photo.png")
+		_, _ = fw.Write(e.content)
+		_ = mw.WriteField("caption", "The view")
+		_ = mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id+"/images", body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr, ctx := serveRoomRequest(Repo.PostAdminRoomImage, req, map[string]string{"id": e.id})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q", e.name, e.expectedFlash)
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q", e.name, e.expectedError)
+		}
+		files, _ := ioutil.ReadDir(dir)
+		if len(files) != e.expectedFiles {
+			t.Errorf("failed %s: expected %d uploaded files, got %d", e.name, e.expectedFiles, len(files))
+		}
+		for _, f := range files {
+			if filepath.Ext(f.Name()) != ".png" {
+				t.Errorf("failed %s: expected the photo to be stored as png, got %s", e.name, f.Name())
+			}
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoomImage(t *testing.T) {
+	var tests = []struct {
+		name          string
+		imageID       string
+		expectedFlash string
+		expectedError string
+	}{
+		{"delete", "1", "Photo deleted!", ""},
+		{"unknown photo", "1001", "", "can't find the photo!"},
+		{"bad id", "x", "", "error in url!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/images/"+e.imageID+"/delete", nil)
+		rr, ctx := serveRoomRequest(Repo.AdminDeleteRoomImage, req, map[string]string{"id": "1", "imageID": e.imageID})
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/admin/rooms/1" {
+			t.Errorf("failed %s: expected location /admin/rooms/1, but got location %s", e.name, actualLoc.String())
+		}
+		if e.expectedFlash != "" && session.GetString(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q", e.name, e.expectedFlash)
+		}
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_PostAdminRoomImage_NoUpload(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/rooms/1/images", strings.NewReader("caption=The+view"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, ctx := serveRoomRequest(Repo.PostAdminRoomImage, req, map[string]string{"id": "1"})
+	if actual := session.GetString(ctx, "error"); !strings.HasPrefix(actual, "can't read the upload: ") {
+		t.Errorf("expected the upload to be unreadable got %q", actual)
+	}
+}
@@ -8,7 +8,11 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/mailer"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/payments"
+	"github.com/majedutd990/bookings/internal/pricing"
 	"github.com/majedutd990/bookings/internal/render"
 	"html/template"
 	"log"
@@ -22,10 +26,15 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":    render.HumanDate,
+	"formatDate":   render.FormatDate,
+	"iterate":      render.Iterate,
+	"add":          render.Add,
+	"roleName":     models.RoleName,
+	"formatPrice":  pricing.Format,
+	"statusName":   models.StatusName,
+	"statusAction": models.StatusAction,
+	"nextStatuses": models.NextStatuses,
 }
 var pathToTemplate = "./../../templates"
 var infoLog *log.Logger
@@ -39,6 +48,11 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 	app.InProduction = false
+	// guests pay a deposit of 20% with the fake provider, webhooks are signed with "secret"
+	app.Payment = config.PaymentConfig{Provider: "fake", DepositPercent: 20, WebhookSecret: "secret"}
+	app.Payments = payments.NewFake(app.Payment.WebhookSecret)
+	app.ICalSecret = "feeds"
+	app.BaseURL = "http://localhost:8080"
 
 	//log in our std lib
 	infoLog = log.New(os.Stdout, "INFO:\t", log.Ldate|log.Ltime)
@@ -68,6 +82,12 @@ func TestMain(m *testing.M) {
 	//we set UseCache to false otherwise it will use the original create template cache in render package
 	app.UseCache = true
 	render.NewRenderer(&app)
+	app.MailHTMLCache, app.MailTextCache, err = mailer.CreateTemplateCache("./../../email-templates")
+	if err != nil {
+		log.Fatal("cannot create mail template cache")
+	}
+	mailer.NewMailer(&app)
+	helpers.NewHelper(&app)
 
 	repo := NewTestRepo(&app)
 
@@ -84,8 +104,10 @@ func getRoutes() http.Handler {
 	mux.Use(SessionLoad)
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suites", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.RoomPage)
+	mux.Get("/generals-quarters", RedirectRoom("generals-quarters"))
+	mux.Get("/majors-suites", RedirectRoom("majors-suite"))
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJson)
@@ -93,10 +115,24 @@ func getRoutes() http.Handler {
 	mux.Get("/contact", Repo.Contact)
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
+	mux.Get("/make-reservation/payment", Repo.ReservationPayment)
+	mux.Post("/make-reservation/payment", Repo.PostReservationPayment)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+	mux.Get("/ical/rooms/{id}.ics", Repo.ICalRoom)
+	mux.Get("/ical/property.ics", Repo.ICalProperty)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	// guests manage their own reservation through the link in their confirmation email
+	mux.Get("/reservations/manage/{token}", Repo.ManageReservation)
+	mux.Post("/reservations/manage/{token}/dates", Repo.PostManageReservationDates)
+	mux.Post("/reservations/manage/{token}/cancel", Repo.PostManageReservationCancel)
+
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/password/{token}", Repo.ShowSetPassword)
+	mux.Post("/user/password/{token}", Repo.PostSetPassword)
 	mux.Get("/user/logout", Repo.LogOut)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
@@ -104,11 +140,67 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calender", Repo.AdminReservationsCalender)
 	mux.Post("/admin/reservations-calender", Repo.PostAdminReservationsCalender)
+	mux.Get("/admin/blocks/{id}", Repo.AdminBlock)
+	mux.Post("/admin/blocks/{id}", Repo.PostAdminBlock)
+	mux.Post("/admin/blocks/{id}/delete", Repo.AdminDeleteBlock)
+	// the front desk books for guests on the phone or at the door
+	mux.Get("/admin/reservations/new", Repo.AdminBookReservation)
+	mux.Post("/admin/reservations/new", Repo.PostAdminBookReservation)
 	// show reservation
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}/show", Repo.PostAdminShowReservation)
-	mux.Get("/admin/process/reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/process/delete/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Post("/admin/reservations/{src}/{id}/dates", Repo.PostAdminReservationDates)
+	mux.Post("/admin/reservations/{src}/{id}/status", Repo.PostAdminReservationStatus)
+	mux.Post("/admin/process/delete/{src}/{id}/do", Repo.AdminDeleteReservation)
+	mux.Get("/admin/reservations-trash", Repo.AdminTrashReservations)
+	mux.Post("/admin/reservations-trash/{id}/restore", Repo.AdminRestoreReservation)
+	mux.Post("/admin/reservations/{src}/{id}/payments/{paymentID}/capture", Repo.AdminCapturePayment)
+	mux.Post("/admin/reservations/{src}/{id}/payments/{paymentID}/refund", Repo.AdminRefundPayment)
+	mux.Get("/admin/api-tokens", Repo.AdminAPITokens)
+	mux.Post("/admin/api-tokens", Repo.PostAdminAPITokens)
+	mux.Post("/admin/api-tokens/{id}/delete", Repo.AdminDeleteAPIToken)
+	mux.Get("/admin/failed-mail", Repo.AdminFailedMail)
+	mux.Post("/admin/failed-mail/{id}/retry", Repo.AdminRetryMail)
+	mux.Get("/admin/restrictions", Repo.AdminRestrictions)
+	mux.Get("/admin/restrictions/{id}", Repo.AdminRestriction)
+	mux.Post("/admin/restrictions/{id}", Repo.PostAdminRestriction)
+	mux.Post("/admin/restrictions/{id}/delete", Repo.AdminDeleteRestriction)
+	mux.Get("/admin/audit", Repo.AdminAudit)
+	mux.Get("/admin/profile", Repo.AdminProfile)
+	mux.Post("/admin/profile/password", Repo.PostAdminProfilePassword)
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/{id}", Repo.AdminUser)
+	mux.Post("/admin/users/{id}", Repo.PostAdminUser)
+	mux.Post("/admin/users/{id}/active", Repo.PostAdminUserActive)
+	mux.Post("/admin/users/{id}/password-link", Repo.PostAdminUserPasswordLink)
+	mux.Get("/admin/logins", Repo.AdminLogins)
+	mux.Post("/admin/logins/unlock", Repo.PostAdminUnlockLogin)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/{id}", Repo.AdminRoom)
+	mux.Post("/admin/rooms/{id}", Repo.PostAdminRoom)
+	mux.Post("/admin/rooms/{id}/active", Repo.AdminRoomActive)
+	mux.Post("/admin/rooms/{id}/move", Repo.AdminMoveRoom)
+	mux.Post("/admin/rooms/{id}/images", Repo.PostAdminRoomImage)
+	mux.Post("/admin/rooms/{id}/images/{imageID}/delete", Repo.AdminDeleteRoomImage)
+	mux.Post("/admin/rooms/{id}/overrides", Repo.PostAdminRoomOverride)
+	mux.Post("/admin/rooms/{id}/overrides/{overrideID}/delete", Repo.AdminDeleteRoomOverride)
+	mux.Post("/admin/rooms/{id}/discounts", Repo.PostAdminRoomDiscount)
+	mux.Post("/admin/rooms/{id}/discounts/{discountID}/delete", Repo.AdminDeleteRoomDiscount)
+	mux.Post("/admin/rooms/{id}/ical-feeds", Repo.PostAdminRoomICalFeed)
+	mux.Post("/admin/rooms/{id}/ical-feeds/{feedID}/sync", Repo.AdminSyncRoomICalFeed)
+	mux.Post("/admin/rooms/{id}/ical-feeds/{feedID}/upload", Repo.PostAdminRoomICalFeedUpload)
+	mux.Post("/admin/rooms/{id}/ical-feeds/{feedID}/delete", Repo.AdminDeleteRoomICalFeed)
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APICreateReservation)
+		mux.Get("/reservations/{id}", Repo.APIGetReservation)
+		mux.Put("/reservations/{id}", Repo.APIUpdateReservation)
+		mux.Delete("/reservations/{id}", Repo.APICancelReservation)
+	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
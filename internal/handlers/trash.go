@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"github.com/majedutd990/bookings/internal/repository"
+	"net/http"
+	"strconv"
+)
+
+// deleting a reservation moves it to the trash and frees its room. from the trash it can be restored as long as
+// its room is still free, until the purge job removes it for good, see cmd/web/trash-purge.go
+
+//AdminTrashReservations lists the reservations in the trash
+func (m *Repository) AdminTrashReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.GetDeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	intMap := make(map[string]int)
+	intMap["trash_days"] = m.App.TrashDays
+	render.Template(w, "admin-reservations-trash.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+//AdminRestoreReservation takes a reservation out of the trash, if its room is still free for its dates
+func (m *Repository) AdminRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	restrictionID, err := m.restrictionID(models.RestrictionReservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the reservation isn't found outside the trash before it is restored, the audit log keeps what comes back
+	trash, err := m.DB.GetDeletedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	var after interface{}
+	for _, res := range trash {
+		if res.ID == id {
+			after = reservationAudit(res)
+		}
+	}
+	err = m.DB.RestoreReservation(id, restrictionID, auditEntry(r, models.AuditRestore, models.AuditReservation, id, nil, after))
+	if err != nil {
+		var notAvailable *repository.RoomNotAvailableError
+		switch {
+		case errors.As(err, &notAvailable):
+			m.App.Session.Put(r.Context(), "error", "the room is not free for these dates anymore, the reservation stays in the trash")
+		case errors.Is(err, sql.ErrNoRows):
+			m.App.Session.Put(r.Context(), "error", "can't find the reservation in the trash!")
+		default:
+			helpers.ServerError(w, err)
+			return
+		}
+		http.Redirect(w, r, "/admin/reservations-trash", http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation restored!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", id), http.StatusSeeOther)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRepository_AdminTrashReservations(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-trash", nil)
+	rr, _ := serveRoomRequest(Repo.AdminTrashReservations, req, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	for _, expected := range []string{"Trashed Guest", "by Admin User", "/admin/reservations-trash/8/restore"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in the page", expected)
+		}
+	}
+}
+
+var adminRestoreReservationTests = []struct {
+	name             string
+	id               string
+	expectedLocation string
+	expectedFlash    string
+	expectedError    string
+}{
+	{"open reservation", "7", "/admin/reservations/all/7/show", "Reservation restored!", ""},
+	{"cancelled reservation", "8", "/admin/reservations/all/8/show", "Reservation restored!", ""},
+	{"room taken meanwhile", "9", "/admin/reservations-trash", "", "the room is not free for these dates anymore, the reservation stays in the trash"},
+	{"not in the trash", "5", "/admin/reservations-trash", "", "can't find the reservation in the trash!"},
+	{"bad id", "x", "/admin/reservations-trash", "", "error in url!"},
+}
+
+func TestRepository_AdminRestoreReservation(t *testing.T) {
+	for _, e := range adminRestoreReservationTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-trash/"+e.id+"/restore", nil)
+		rr, ctx := serveRoomRequest(Repo.AdminRestoreReservation, req, map[string]string{"id": e.id})
+		if actualLoc, _ := rr.Result().Location(); actualLoc == nil || actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s got %v", e.name, e.expectedLocation, actualLoc)
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteReservation_Trash(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"to the trash", "1", "Reservation moved to the trash, it can be restored from there!", ""},
+		{"no such reservation", "1001", "", "can't find the reservation!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/process/delete/all/"+e.id+"/do", nil)
+		rr, ctx := serveRoomRequest(Repo.AdminDeleteReservation, req, map[string]string{"src": "all", "id": e.id})
+		if actualLoc, _ := rr.Result().Location(); actualLoc.String() != "/admin/reservations-all" {
+			t.Errorf("%s: expected location /admin/reservations-all got %s", e.name, actualLoc.String())
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
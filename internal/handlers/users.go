@@ -0,0 +1,489 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/majedutd990/bookings/internal/forms"
+	"github.com/majedutd990/bookings/internal/helpers"
+	"github.com/majedutd990/bookings/internal/mailer"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/render"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// staff accounts. owners invite new staff by email, the invite link lets them choose their own password,
+// so nobody else ever knows it. nobody can demote or deactivate themselves, that way the site keeps an owner
+
+const (
+	// inviteTTL is how long an invite link works
+	inviteTTL = 7 * 24 * time.Hour
+	// resetTTL is how long a password reset link works, and every link from the forgot password form
+	resetTTL = time.Hour
+	// resetInterval is how long the forgot password form waits before it mails the same user again
+	resetInterval = 5 * time.Minute
+)
+
+//userAudit is what the audit log keeps of a user, never the password hash
+func userAudit(u models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"first_name":   u.FirstName,
+		"last_name":    u.LastName,
+		"email":        u.Email,
+		"access_level": u.AccessLevel,
+		"active":       u.Active,
+	}
+}
+
+//userFromURL looks up the user with the id in the url. it redirects to the user list and returns false if there is none
+func (m *Repository) userFromURL(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "error in url!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return models.User{}, false
+	}
+	user, err := m.DB.GetUserByID(id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find the user!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+//AdminUsers lists the staff accounts
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["users"] = users
+	intMap := make(map[string]int)
+	intMap["user_id"] = helpers.UserID(r)
+	render.Template(w, "admin-users.page.tmpl", r, &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+//AdminUser shows the form to edit a user, id "new" shows the form to invite one
+func (m *Repository) AdminUser(w http.ResponseWriter, r *http.Request) {
+	user := models.User{AccessLevel: models.AccessLevelViewer, Active: true}
+	if chi.URLParam(r, "id") != "new" {
+		var ok bool
+		if user, ok = m.userFromURL(w, r); !ok {
+			return
+		}
+	}
+	form := forms.New(url.Values{
+		"first_name":   {user.FirstName},
+		"last_name":    {user.LastName},
+		"email":        {user.Email},
+		"access_level": {strconv.Itoa(user.AccessLevel)},
+	})
+	m.renderAdminUser(w, r, user, form)
+}
+
+//renderAdminUser renders the form of a user, the values come from form
+func (m *Repository) renderAdminUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["access_levels"] = []int{models.AccessLevelViewer, models.AccessLevelFrontDesk, models.AccessLevelManager,
+		models.AccessLevelOwner}
+	intMap := make(map[string]int)
+	intMap["user_id"] = helpers.UserID(r)
+	render.Template(w, "admin-user.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+//PostAdminUser invites a user (id "new") or stores the changes of one. a new user gets a mail with a link
+// to choose a password
+func (m *Repository) PostAdminUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user := models.User{Active: true}
+	if chi.URLParam(r, "id") != "new" {
+		var ok bool
+		if user, ok = m.userFromURL(w, r); !ok {
+			return
+		}
+	}
+
+	before := userAudit(user)
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+	user.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	user.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	user.Email = strings.TrimSpace(r.Form.Get("email"))
+	accessLevel, err := strconv.Atoi(r.Form.Get("access_level"))
+	if err != nil || models.RoleName(accessLevel) == "None" {
+		form.Errors.Add("access_level", "Invalid access level")
+	} else if user.ID == helpers.UserID(r) && accessLevel != user.AccessLevel {
+		form.Errors.Add("access_level", "You can't change your own access level")
+	} else {
+		user.AccessLevel = accessLevel
+	}
+	if form.Has("email") {
+		other, err := m.DB.GetUserByEmail(user.Email)
+		switch {
+		case err == nil && other.ID != user.ID:
+			form.Errors.Add("email", "There is already a user with this email")
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminUser(w, r, user, form)
+		return
+	}
+
+	if user.ID != 0 {
+		err = m.DB.UpdateUser(user, auditEntry(r, models.AuditUpdate, models.AuditUser, user.ID, before, userAudit(user)))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				m.App.Session.Put(r.Context(), "error", "can't find the user!")
+				http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+				return
+			}
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", "User saved!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	// the user and their invite are stored together, there is no user without a way to log in
+	invite, msg, err := m.passwordLink(user, models.PasswordInvite, inviteTTL)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	user.ID, err = m.DB.InsertUser(user, invite,
+		auditEntry(r, models.AuditCreate, models.AuditUser, 0, nil, userAudit(user)))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.MailChan <- msg
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invite sent to %s!", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//PostAdminUserActive activates (active=1) or deactivates a user, nobody can deactivate themselves
+func (m *Repository) PostAdminUserActive(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if user.ID == helpers.UserID(r) {
+		m.App.Session.Put(r.Context(), "error", "you can't deactivate yourself!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	before := userAudit(user)
+	user.Active = r.PostFormValue("active") == "1"
+	err := m.DB.SetUserActive(user.ID, user.Active,
+		auditEntry(r, models.AuditUpdate, models.AuditUser, user.ID, before, userAudit(user)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "can't find the user!")
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	if user.Active {
+		m.App.Session.Put(r.Context(), "flash", "User activated!")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "User deactivated, they can't log in anymore!")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//PostAdminUserPasswordLink mails a user a link to set their password: the invite again if they never set one,
+// a reset link otherwise. older links stop working
+func (m *Repository) PostAdminUserPasswordLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.userFromURL(w, r)
+	if !ok {
+		return
+	}
+	if !user.Active {
+		m.App.Session.Put(r.Context(), "error", "activate the user first!")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	purpose := models.PasswordReset
+	if user.Invited() {
+		purpose = models.PasswordInvite
+	}
+	err := m.sendPasswordLink(user, purpose,
+		auditEntry(r, models.AuditPassword, models.AuditUser, user.ID, nil, map[string]interface{}{"link": purpose}))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Link sent to %s!", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+//sendPasswordLink stores a new password token of the user, with the audit entry if an admin sent it,
+// and mails them the link
+func (m *Repository) sendPasswordLink(user models.User, purpose string, audit *models.AuditEntry) error {
+	ttl := resetTTL
+	if purpose == models.PasswordInvite {
+		ttl = inviteTTL
+	}
+	t, msg, err := m.passwordLink(user, purpose, ttl)
+	if err != nil {
+		return err
+	}
+	err = m.DB.InsertPasswordToken(t, audit)
+	if err != nil {
+		return err
+	}
+	m.App.MailChan <- msg
+	return nil
+}
+
+//passwordLink makes a new password token of the user that works for ttl, and the mail with its link
+func (m *Repository) passwordLink(user models.User, purpose string, ttl time.Duration) (models.PasswordToken, models.MailData, error) {
+	token, hash, err := helpers.NewToken()
+	if err != nil {
+		return models.PasswordToken{}, models.MailData{}, err
+	}
+	subject, tmpl := "Reset Your Password", "password-reset"
+	if purpose == models.PasswordInvite {
+		subject, tmpl = "Your Staff Account", "user-invite"
+	}
+	t := models.PasswordToken{
+		UserID:    user.ID,
+		TokenHash: hash,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	msg, err := mailer.Message(user.Email, subject, tmpl, mailer.PasswordLinkData{
+		User:      user,
+		URL:       fmt.Sprintf("%s/user/password/%s", m.App.BaseURL, token),
+		ExpiresAt: t.ExpiresAt,
+	})
+	if err != nil {
+		return models.PasswordToken{}, models.MailData{}, err
+	}
+	return t, msg, nil
+}
+
+//checkNewPassword adds errors to form if the new password does not follow our policy or is not repeated right
+func checkNewPassword(form *forms.Form) {
+	form.Required("new_password", "new_password_again")
+	if form.Has("new_password") {
+		form.IsPassword("new_password")
+	}
+	if form.Get("new_password_again") != form.Get("new_password") {
+		form.Errors.Add("new_password_again", "The passwords don't match")
+	}
+}
+
+//ShowForgotPassword shows the form to ask for a password reset link
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, "forgot-password.page.tmpl", r, &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+//PostForgotPassword mails a reset link to the user with the email, or the invite again if they never set
+// a password. the answer is the same whether there is such a user or not, so nobody can find out who works here.
+// anybody can send this form, so its links work for resetTTL only and a user gets one every resetInterval at most,
+// the link they got before keeps working until then
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, "forgot-password.page.tmpl", r, &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(strings.TrimSpace(r.Form.Get("email")))
+	switch {
+	case err == nil && user.Active:
+		purpose := models.PasswordReset
+		if user.Invited() {
+			purpose = models.PasswordInvite
+		}
+		t, msg, err := m.passwordLink(user, purpose, resetTTL)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		sent, err := m.DB.RequestPasswordToken(t, time.Now().Add(-resetInterval))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if sent {
+			m.App.MailChan <- msg
+		}
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "If there is an account with this email, we sent it a link to choose a new password.")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//ShowSetPassword shows the form to choose a password, reached through the link of an invite or reset mail
+func (m *Repository) ShowSetPassword(w http.ResponseWriter, r *http.Request) {
+	t, ok := m.passwordTokenFromURL(w, r)
+	if !ok {
+		return
+	}
+	m.renderSetPassword(w, r, t, forms.New(nil))
+}
+
+//PostSetPassword sets the password with the token of the link, the link does not work a second time.
+// the user is logged out everywhere, whoever knew the old password is locked out
+func (m *Repository) PostSetPassword(w http.ResponseWriter, r *http.Request) {
+	t, ok := m.passwordTokenFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	checkNewPassword(form)
+	if !form.Valid() {
+		m.renderSetPassword(w, r, t, form)
+		return
+	}
+	_, err = m.DB.SetPasswordWithToken(t.TokenHash, r.Form.Get("new_password"))
+	if err != nil {
+		// somebody used the link in the meantime
+		if errors.Is(err, sql.ErrNoRows) {
+			m.App.Session.Put(r.Context(), "error", "this link does not work anymore, ask for a new one")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		helpers.ServerError(w, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Password saved, you can log in now!")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+//passwordTokenFromURL looks up the password token in the url. it redirects to the login page and
+// returns false if the token is unknown, used or expired
+func (m *Repository) passwordTokenFromURL(w http.ResponseWriter, r *http.Request) (models.PasswordToken, bool) {
+	t, err := m.DB.GetPasswordToken(helpers.HashToken(chi.URLParam(r, "token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "this link does not work anymore, ask for a new one")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return t, false
+	}
+	return t, true
+}
+
+//renderSetPassword renders the form to choose a password
+func (m *Repository) renderSetPassword(w http.ResponseWriter, r *http.Request, t models.PasswordToken, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["token"] = t
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+	intMap := make(map[string]int)
+	intMap["min_password_length"] = forms.MinPasswordLength
+	render.Template(w, "set-password.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		StrMap: stringMap,
+		IntMap: intMap,
+	})
+}
+
+//AdminProfile shows the account of the logged-in user with the form to change the password
+func (m *Repository) AdminProfile(w http.ResponseWriter, r *http.Request) {
+	m.renderAdminProfile(w, r, forms.New(nil))
+}
+
+//renderAdminProfile renders the profile page of the logged-in user
+func (m *Repository) renderAdminProfile(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user, err := m.DB.GetUserByID(helpers.UserID(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["user"] = user
+	intMap := make(map[string]int)
+	intMap["min_password_length"] = forms.MinPasswordLength
+	render.Template(w, "admin-profile.page.tmpl", r, &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+//PostAdminProfilePassword changes the password of the logged-in user, the current one has to be entered again.
+// the other sessions of the user are logged out
+func (m *Repository) PostAdminProfilePassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	user, err := m.DB.GetUserByID(helpers.UserID(r))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+	checkNewPassword(form)
+	if form.Has("current_password") {
+		if _, _, err = m.DB.Authenticate(user.Email, r.Form.Get("current_password")); err != nil {
+			form.Errors.Add("current_password", "This is not your current password")
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminProfile(w, r, form)
+		return
+	}
+	version, err := m.DB.UpdateUserPassword(user.ID, r.Form.Get("new_password"),
+		auditEntry(r, models.AuditPassword, models.AuditUser, user.ID, nil, nil))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// every other session of the user is logged out, this one goes on with the new version
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "session_version", version)
+	m.App.Session.Put(r.Context(), "flash", "Password changed!")
+	http.Redirect(w, r, "/admin/profile", http.StatusSeeOther)
+}
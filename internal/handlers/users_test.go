@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+//serveAsUser serves req like serveRoomRequest, with userID logged in
+func serveAsUser(handler http.HandlerFunc, req *http.Request, params map[string]string, userID int) (*httptest.ResponseRecorder, context.Context) {
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", userID)
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr, ctx
+}
+
+//userForm is a valid user form, changed by the given values
+func userForm(changes url.Values) url.Values {
+	v := url.Values{
+		"first_name":   {"Jane"},
+		"last_name":    {"Doe"},
+		"email":        {"jane@here.ca"},
+		"access_level": {"2"},
+	}
+	for k, vals := range changes {
+		v[k] = vals
+	}
+	return v
+}
+
+var postAdminUserTests = []struct {
+	name          string
+	id            string
+	postedData    url.Values
+	expectedCode  int
+	expectedFlash string
+	expectedHTML  string
+}{
+	{"invite", "new", userForm(nil), http.StatusSeeOther, "Invite sent to jane@here.ca!", ""},
+	{"edit", "3", userForm(url.Values{"email": {"new@here.ca"}}), http.StatusSeeOther, "User saved!", ""},
+	{"email is taken", "new", userForm(url.Values{"email": {"ME@here.ca"}}), http.StatusOK, "", "There is already a user with this email"},
+	{"invalid email", "new", userForm(url.Values{"email": {"jane"}}), http.StatusOK, "", "Invalid email address"},
+	{"no name", "new", userForm(url.Values{"first_name": {""}}), http.StatusOK, "", "This field cannot be blank!"},
+	{"no such access level", "new", userForm(url.Values{"access_level": {"7"}}), http.StatusOK, "", "Invalid access level"},
+	{"own access level", "1", userForm(url.Values{"email": {"me@here.ca"}, "access_level": {"4"}}), http.StatusOK, "",
+		"You can&#39;t change your own access level"},
+	{"keep own access level", "1", userForm(url.Values{"email": {"me@here.ca"}, "access_level": {"3"}}), http.StatusSeeOther,
+		"User saved!", ""},
+	{"no such user", "2000", userForm(nil), http.StatusSeeOther, "", ""},
+}
+
+func TestRepository_PostAdminUser(t *testing.T) {
+	for _, e := range postAdminUserTests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveAsUser(Repo.PostAdminUser, req, map[string]string{"id": e.id}, 1)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var postAdminUserActiveTests = []struct {
+	name          string
+	id            string
+	active        string
+	expectedFlash string
+	expectedError string
+}{
+	{"deactivate", "3", "0", "User deactivated, they can't log in anymore!", ""},
+	{"activate", "5", "1", "User activated!", ""},
+	{"yourself", "1", "0", "", "you can't deactivate yourself!"},
+	{"no such user", "2000", "0", "", "can't find the user!"},
+}
+
+func TestRepository_PostAdminUserActive(t *testing.T) {
+	for _, e := range postAdminUserActiveTests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id+"/active", strings.NewReader("active="+e.active))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, ctx := serveAsUser(Repo.PostAdminUserActive, req, map[string]string{"id": e.id}, 1)
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_PostAdminUserPasswordLink(t *testing.T) {
+	tests := []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"invited", "3", "Link sent to new@here.ca!", ""},
+		{"active", "1", "Link sent to me@here.ca!", ""},
+		{"deactivated", "5", "", "activate the user first!"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id+"/password-link", nil)
+		_, ctx := serveAsUser(Repo.PostAdminUserPasswordLink, req, map[string]string{"id": e.id}, 1)
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.GetString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("%s: expected error %q got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_AdminUsers(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/users", nil)
+	rr, _ := serveAsUser(Repo.AdminUsers, req, nil, 1)
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d got %d", http.StatusOK, rr.Code)
+	}
+	for _, expected := range []string{"Send Invite Again", "deactivated", "Activate"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected %q in the page", expected)
+		}
+	}
+}
+
+var postAdminProfilePasswordTests = []struct {
+	name          string
+	postedData    url.Values
+	expectedCode  int
+	expectedFlash string
+	expectedHTML  string
+}{
+	{"changed", url.Values{"current_password": {"secret"}, "new_password": {"a-longer-one"}, "new_password_again": {"a-longer-one"}},
+		http.StatusSeeOther, "Password changed!", ""},
+	{"wrong current password", url.Values{"current_password": {"wrong-password"}, "new_password": {"a-longer-one"},
+		"new_password_again": {"a-longer-one"}}, http.StatusOK, "", "This is not your current password"},
+	{"too weak", url.Values{"current_password": {"secret"}, "new_password": {"onlyletters"}, "new_password_again": {"onlyletters"}},
+		http.StatusOK, "", "Use at least 10 characters"},
+	{"not repeated", url.Values{"current_password": {"secret"}, "new_password": {"a-longer-one"},
+		"new_password_again": {"a-longer-two"}}, http.StatusOK, "", "The passwords don&#39;t match"},
+}
+
+func TestRepository_PostAdminProfilePassword(t *testing.T) {
+	for _, e := range postAdminProfilePasswordTests {
+		req, _ := http.NewRequest("POST", "/admin/profile/password", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveAsUser(Repo.PostAdminProfilePassword, req, nil, 1)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var setPasswordTests = []struct {
+	name             string
+	token            string
+	postedData       url.Values
+	expectedCode     int
+	expectedLocation string
+	expectedHTML     string
+}{
+	{"invite", "valid-invite-token", url.Values{"new_password": {"a-longer-one"}, "new_password_again": {"a-longer-one"}},
+		http.StatusSeeOther, "/user/login", ""},
+	{"reset", "valid-reset-token", url.Values{"new_password": {"a-longer-one"}, "new_password_again": {"a-longer-one"}},
+		http.StatusSeeOther, "/user/login", ""},
+	{"too short", "valid-invite-token", url.Values{"new_password": {"short"}, "new_password_again": {"short"}},
+		http.StatusOK, "", "Use at least 10 characters"},
+	{"unknown token", "used-token", url.Values{"new_password": {"a-longer-one"}, "new_password_again": {"a-longer-one"}},
+		http.StatusSeeOther, "/user/login", ""},
+}
+
+func TestRepository_PostSetPassword(t *testing.T) {
+	for _, e := range setPasswordTests {
+		req, _ := http.NewRequest("POST", "/user/password/"+e.token, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, _ := serveRoomRequest(Repo.PostSetPassword, req, map[string]string{"token": e.token})
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if e.expectedLocation != "" {
+			if actualLoc, _ := rr.Result().Location(); actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_ShowSetPassword(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/password/valid-invite-token", nil)
+	rr, _ := serveRoomRequest(Repo.ShowSetPassword, req, map[string]string{"token": "valid-invite-token"})
+	if !strings.Contains(rr.Body.String(), "Welcome, New") {
+		t.Error("expected the invite to greet the new user")
+	}
+
+	req, _ = http.NewRequest("GET", "/user/password/used-token", nil)
+	rr, ctx := serveRoomRequest(Repo.ShowSetPassword, req, map[string]string{"token": "used-token"})
+	if rr.Code != http.StatusSeeOther || session.GetString(ctx, "error") == "" {
+		t.Errorf("expected a used link to be refused, got code %d", rr.Code)
+	}
+}
+
+var postForgotPasswordTests = []struct {
+	name          string
+	email         string
+	expectedCode  int
+	expectedFlash string
+	expectedHTML  string
+}{
+	{"active user", "me@here.ca", http.StatusSeeOther, "If there is an account with this email, we sent it a link to choose a new password.", ""},
+	{"invited user", "new@here.ca", http.StatusSeeOther, "If there is an account with this email, we sent it a link to choose a new password.", ""},
+	{"deactivated user", "former@here.ca", http.StatusSeeOther, "If there is an account with this email, we sent it a link to choose a new password.", ""},
+	{"no such user", "nobody@here.ca", http.StatusSeeOther, "If there is an account with this email, we sent it a link to choose a new password.", ""},
+	{"invalid email", "nobody", http.StatusOK, "", "Invalid email address"},
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	for _, e := range postForgotPasswordTests {
+		postedData := url.Values{"email": {e.email}}
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr, ctx := serveRoomRequest(Repo.PostForgotPassword, req, nil)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected code %d got %d", e.name, e.expectedCode, rr.Code)
+			continue
+		}
+		if flash := session.GetString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: expected flash %q got %q", e.name, e.expectedFlash, flash)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected %q in the page", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_PostAdminProfilePassword_KeepsSession(t *testing.T) {
+	postedData := url.Values{"current_password": {"secret"}, "new_password": {"a-longer-one"}, "new_password_again": {"a-longer-one"}}
+	req, _ := http.NewRequest("POST", "/admin/profile/password", strings.NewReader(postedData.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, ctx := serveAsUser(Repo.PostAdminProfilePassword, req, nil, 1)
+	if version := session.GetInt(ctx, "session_version"); version != 2 {
+		t.Errorf("expected the session to go on with version 2, got %d", version)
+	}
+}
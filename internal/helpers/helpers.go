@@ -1,6 +1,11 @@
 package helpers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"github.com/majedutd990/bookings/internal/config"
 	"net/http"
@@ -30,7 +35,72 @@ func ServerError(w http.ResponseWriter, err error) {
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+//AuthUser is the user behind a request that was authenticated with an api token
+type AuthUser struct {
+	ID          int
+	AccessLevel int
+}
+
+type contextKey string
+
+const authUserKey = contextKey("auth_user")
+
+//WithAuthUser returns a copy of ctx that carries the token authenticated user
+func WithAuthUser(ctx context.Context, u AuthUser) context.Context {
+	return context.WithValue(ctx, authUserKey, u)
+}
+
+//AuthUserFromContext returns the token authenticated user if there is one
+func AuthUserFromContext(ctx context.Context) (AuthUser, bool) {
+	u, ok := ctx.Value(authUserKey).(AuthUser)
+	return u, ok
+}
+
+//IsAuthenticated checks for a valid api token first and falls back to the session cookie.
+// a request that carries an Authorization header is never authenticated by its session
 func IsAuthenticated(r *http.Request) bool {
+	if _, ok := AuthUserFromContext(r.Context()); ok {
+		return true
+	}
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
 	exist := app.Session.Exists(r.Context(), "user_id")
 	return exist
 }
+
+//UserID returns the id of the logged-in user, or 0 if nobody is logged in
+func UserID(r *http.Request) int {
+	if u, ok := AuthUserFromContext(r.Context()); ok {
+		return u.ID
+	}
+	return app.Session.GetInt(r.Context(), "user_id")
+}
+
+//AccessLevel returns the access level of the logged-in user, or 0 if nobody is logged in
+func AccessLevel(r *http.Request) int {
+	if u, ok := AuthUserFromContext(r.Context()); ok {
+		return u.AccessLevel
+	}
+	if r.Header.Get("Authorization") != "" {
+		return 0
+	}
+	return app.Session.GetInt(r.Context(), "access_level")
+}
+
+//NewToken generates a random url safe token and its hash. only the hash should be stored
+func NewToken() (string, string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+//HashToken returns the sha256 hash of a token in hex, which is what we keep in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,100 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// this package writes calendars in the iCalendar format (rfc 5545) that google, outlook
+// and the booking sites read, and reads theirs (see Parse). we only need all-day events, so that is all there is
+
+//dateLayout is how all-day dates are written
+const dateLayout = "20060102"
+
+//Event is an all-day VEVENT from Start up to End, the day End is not part of it
+type Event struct {
+	// UID must stay the same for the same event, so clients update it instead of adding it again
+	UID         string
+	Summary     string
+	Description string
+	// Categories tells what kind of event it is, like RESERVATION or BLOCK
+	Categories string
+	Start      time.Time
+	End        time.Time
+}
+
+//Calendar is a VCALENDAR with its events
+type Calendar struct {
+	// Name is shown by the clients that support X-WR-CALNAME
+	Name   string
+	Events []Event
+	// Stamp is the DTSTAMP of every event, the time the calendar was made
+	Stamp time.Time
+}
+
+//Write writes the calendar to w
+func (c Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//bookings//room calendar//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", Escape(c.Name))
+	}
+	stamp := c.Stamp.UTC().Format("20060102T150405Z")
+	for _, e := range c.Events {
+		end := e.End
+		// an event needs at least one day
+		if !end.After(e.Start) {
+			end = e.Start.AddDate(0, 0, 1)
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", Escape(e.UID))
+		line("DTSTAMP", stamp)
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE", end.Format(dateLayout))
+		line("SUMMARY", Escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", Escape(e.Description))
+		}
+		if e.Categories != "" {
+			line("CATEGORIES", Escape(e.Categories))
+		}
+		line("STATUS", "CONFIRMED")
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+//Escape escapes a text value: backslashes, semicolons, commas and newlines
+func Escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+//writeLine ends a content line with crlf and folds it after 75 octets, without cutting utf-8 characters
+func writeLine(w *bufio.Writer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		// step back to the start of a character
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// the space of the continuation line counts too
+		limit = 74
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
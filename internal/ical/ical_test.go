@@ -0,0 +1,161 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Write(t *testing.T) {
+	c := Calendar{
+		Name:  "General's Quarters",
+		Stamp: time.Date(2050, 1, 1, 12, 30, 0, 0, time.UTC),
+		Events: []Event{
+			{
+				UID:        "reservation-1@example.com",
+				Summary:    "Reserved",
+				Categories: "RESERVATION",
+				Start:      time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				End:        time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				UID:     "block-7@example.com",
+				Summary: "Blocked",
+				Start:   time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC),
+				End:     time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"X-WR-CALNAME:General's Quarters\r\n",
+		"UID:reservation-1@example.com\r\nDTSTAMP:20500101T123000Z\r\nDTSTART;VALUE=DATE:20500101\r\nDTEND;VALUE=DATE:20500103\r\n",
+		"CATEGORIES:RESERVATION\r\n",
+		// a block of one day ends the day after
+		"DTSTART;VALUE=DATE:20500105\r\nDTEND;VALUE=DATE:20500106\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in\n%s", expected, out)
+		}
+	}
+	if strings.Count(out, "BEGIN:VEVENT") != 2 {
+		t.Errorf("expected 2 events in\n%s", out)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	got := Escape("a\\b;c,d\ne")
+	if got != `a\\b\;c\,d\ne` {
+		t.Errorf("unexpected escape %s", got)
+	}
+}
+
+func TestWriteLine_Folds(t *testing.T) {
+	var buf bytes.Buffer
+	c := Calendar{Name: strings.Repeat("é", 60)}
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range strings.Split(buf.String(), "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line of %d octets: %q", len(l), l)
+		}
+		if !strings.HasPrefix(l, " ") && strings.Contains(l, "é") && !strings.HasPrefix(l, "X-WR-CALNAME:") {
+			t.Errorf("folded line without leading space: %q", l)
+		}
+	}
+	unfolded := strings.ReplaceAll(buf.String(), "\r\n ", "")
+	if !strings.Contains(unfolded, "X-WR-CALNAME:"+strings.Repeat("é", 60)+"\r\n") {
+		t.Errorf("folding broke the name:\n%s", buf.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	in := "BEGIN:VCALENDAR\r\n" +
+		"PRODID:-//Airbnb Inc//Hosting Calendar//EN\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;VALUE=DATE:20500101\r\n" +
+		"DTEND;VALUE=DATE:20500104\r\n" +
+		"UID:abc@airbnb.com\r\n" +
+		"SUMMARY:Reserved\\, not available\r\n" +
+		"DESCRIPTION:a long descrip\r\n" +
+		" tion\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"DESCRIPTION:reminder\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		// lf only, a date-time and no end
+		"BEGIN:VEVENT\n" +
+		"UID:def@booking.com\n" +
+		"DTSTART;TZID=Europe/Berlin:20500110T140000\n" +
+		"END:VEVENT\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:gone@booking.com\r\n" +
+		"DTSTART:20500201T000000Z\r\n" +
+		"DTEND:20500203T000000Z\r\n" +
+		"STATUS:CANCELLED\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"DTSTART;VALUE=DATE:20500301\r\n" +
+		"DTEND;VALUE=DATE:20500302\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	events, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := func(m time.Month, d int) time.Time { return time.Date(2050, m, d, 0, 0, 0, 0, time.UTC) }
+	expected := []Event{
+		{UID: "abc@airbnb.com", Summary: "Reserved, not available", Description: "a long description", Start: day(1, 1), End: day(1, 4)},
+		{UID: "def@booking.com", Start: day(1, 10), End: day(1, 11)},
+		{UID: "20500301-20500302", Start: day(3, 1), End: day(3, 2)},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events got %d: %+v", len(expected), len(events), events)
+	}
+	for i, e := range expected {
+		if events[i] != e {
+			t.Errorf("event %d: expected %+v got %+v", i, e, events[i])
+		}
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	var tests = []struct {
+		name string
+		in   string
+	}{
+		{"not a calendar", "<html>not found</html>"},
+		{"bad date", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART:2050-01-01\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"no start", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nEND:VEVENT\nEND:VCALENDAR\n"},
+	}
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.in)); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestParse_ReadsWrite(t *testing.T) {
+	c := Calendar{Events: []Event{{UID: "block-1@example.com", Summary: "a; b, c\nd", Start: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC)}}}
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Summary != "a; b, c\nd" || !events[0].End.Equal(time.Date(2050, 1, 6, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
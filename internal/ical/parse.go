@@ -0,0 +1,153 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// the other booking channels only tell us when a room is taken, so Parse reads the VEVENTs of a calendar
+// as all-day events and ignores everything else. times are cut to their date, a stay that ends at 10:00
+// on the 5th frees the room on the 5th
+
+//ErrNoCalendar is returned by Parse when the input is not a VCALENDAR
+var ErrNoCalendar = errors.New("ical: not a calendar")
+
+//Parse reads the events of a calendar. cancelled events are left out,
+// events without a UID get one made of their dates, so they can still be told apart
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var e *Event
+	var cancelled, calendar bool
+	// alarms and the like inside an event have properties of their own, nested counts how deep we are in them
+	nested := 0
+	for _, l := range lines {
+		name, params, value := splitLine(l)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			calendar = true
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT"):
+			e = &Event{}
+			cancelled = false
+			nested = 0
+		case e == nil:
+			// outside of an event, nothing we need
+		case name == "BEGIN":
+			nested++
+		case nested > 0:
+			if name == "END" {
+				nested--
+			}
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("ical: event %q has no DTSTART", e.UID)
+			}
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			if e.UID == "" {
+				e.UID = fmt.Sprintf("%s-%s", e.Start.Format(dateLayout), e.End.Format(dateLayout))
+			}
+			if !cancelled {
+				events = append(events, *e)
+			}
+			e = nil
+		case name == "UID":
+			e.UID = value
+		case name == "SUMMARY":
+			e.Summary = Unescape(value)
+		case name == "DESCRIPTION":
+			e.Description = Unescape(value)
+		case name == "CATEGORIES":
+			e.Categories = Unescape(value)
+		case name == "STATUS":
+			cancelled = strings.EqualFold(value, "CANCELLED")
+		case name == "DTSTART" || name == "DTEND":
+			d, err := parseDate(value)
+			if err != nil {
+				return nil, fmt.Errorf("ical: %s%s: %w", name, params, err)
+			}
+			if name == "DTSTART" {
+				e.Start = d
+			} else {
+				e.End = d
+			}
+		}
+	}
+	if !calendar {
+		return nil, ErrNoCalendar
+	}
+	return events, nil
+}
+
+//Unescape undoes Escape
+func Unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+//unfold reads the content lines, joining folded ones again. crlf and plain lf both end a line
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	// a folded description can make a long line
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		l := strings.TrimSuffix(sc.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, sc.Err()
+}
+
+//splitLine splits a content line into its upper cased name, its parameters (with the leading ;) and its value
+func splitLine(l string) (string, string, string) {
+	colon := strings.Index(l, ":")
+	if colon < 0 {
+		return strings.ToUpper(l), "", ""
+	}
+	name, value := l[:colon], l[colon+1:]
+	params := ""
+	if semi := strings.Index(name, ";"); semi >= 0 {
+		name, params = name[:semi], name[semi:]
+	}
+	return strings.ToUpper(name), params, value
+}
+
+//parseDate reads a DATE (20500101) or a DATE-TIME (20500101T140000Z), keeping only the date
+func parseDate(value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	d, err := time.Parse(dateLayout, value[:len(dateLayout)])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return d, nil
+}
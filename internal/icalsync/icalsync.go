@@ -0,0 +1,159 @@
+package icalsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/ical"
+	"github.com/majedutd990/bookings/internal/models"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// this package brings the bookings of other channels (airbnb, booking.com...) into our room_restrictions,
+// so a room booked there can't be booked here. every feed belongs to one room, its events become blocks
+// with the restriction of the code models.RestrictionExternalBooking, and a sync makes the blocks of a feed exactly
+// what the feed says now
+
+//MaxFeedSize is the biggest calendar we read, in bytes
+const MaxFeedSize = 5 << 20
+
+//ErrNoURL is returned when a feed without url is synced, its calendar is uploaded by hand
+var ErrNoURL = errors.New("the feed has no url, upload its .ics file instead")
+
+//Store is the part of the database the sync needs
+type Store interface {
+	GetICalFeeds() ([]models.ICalFeed, error)
+	SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) error
+	UpdateICalFeedStatus(id int, syncedAt time.Time, lastError string) error
+}
+
+//Syncer fetches the feeds and stores their events as blocks
+type Syncer struct {
+	store  Store
+	client *http.Client
+	// now is time.Now, except in tests
+	now func() time.Time
+}
+
+//New creates a Syncer, a nil client gets one with a 30s timeout
+func New(store Store, client *http.Client) *Syncer {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Syncer{store: store, client: client, now: time.Now}
+}
+
+//SyncAll syncs every feed that has a url. a feed that fails does not stop the others,
+// the error returned lists all of them and every feed keeps its own error for the admin pages
+func (s *Syncer) SyncAll(ctx context.Context) error {
+	feeds, err := s.store.GetICalFeeds()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, f := range feeds {
+		if f.URL == "" {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err := s.SyncFeed(ctx, f); err != nil {
+			failed = append(failed, fmt.Sprintf("feed %d (%s): %s", f.ID, f.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("ical sync: %d of %d feeds failed: %s", len(failed), len(feeds), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+//SyncFeed fetches the calendar of a feed and stores its events, it returns how many blocks the feed has now
+func (s *Syncer) SyncFeed(ctx context.Context, f models.ICalFeed) (int, error) {
+	if f.URL == "" {
+		return 0, ErrNoURL
+	}
+	body, err := s.fetch(ctx, f.URL)
+	if err != nil {
+		return 0, s.failed(f, err)
+	}
+	defer body.Close()
+	return s.Import(f, body)
+}
+
+//Import stores the events of a calendar as the blocks of a feed, for calendars that are uploaded
+// and for the ones SyncFeed fetched
+func (s *Syncer) Import(f models.ICalFeed, r io.Reader) (int, error) {
+	// one byte more than we take, to know it was too big
+	data, err := io.ReadAll(io.LimitReader(r, MaxFeedSize+1))
+	if err != nil {
+		return 0, s.failed(f, err)
+	}
+	if len(data) > MaxFeedSize {
+		return 0, s.failed(f, fmt.Errorf("the calendar is bigger than %d MB", MaxFeedSize>>20))
+	}
+	events, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		return 0, s.failed(f, err)
+	}
+	now := s.now()
+	blocks := Blocks(events, now)
+	if err = s.store.SyncExternalBlocks(f, blocks); err != nil {
+		return 0, s.failed(f, err)
+	}
+	if err = s.store.UpdateICalFeedStatus(f.ID, now, ""); err != nil {
+		return 0, err
+	}
+	return len(blocks), nil
+}
+
+//Blocks turns events into blocks, leaving out the ones that ended before today.
+// an event twice in a calendar is only taken once
+func Blocks(events []ical.Event, now time.Time) []models.RoomRestriction {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	seen := make(map[string]bool)
+	var blocks []models.RoomRestriction
+	for _, e := range events {
+		// the day End is free again, so an event that ends today is over
+		if !e.End.After(today) || seen[e.UID] {
+			continue
+		}
+		seen[e.UID] = true
+		blocks = append(blocks, models.RoomRestriction{
+			StartDate:   e.Start,
+			EndDate:     e.End,
+			ExternalUID: e.UID,
+		})
+	}
+	return blocks
+}
+
+//fetch gets a calendar, the caller closes the body
+func (s *Syncer) fetch(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("got %s from %s", resp.Status, url)
+	}
+	return resp.Body, nil
+}
+
+//failed stores the error of a feed and returns it, the blocks of the last sync that worked stay
+func (s *Syncer) failed(f models.ICalFeed, err error) error {
+	if updateErr := s.store.UpdateICalFeedStatus(f.ID, time.Time{}, err.Error()); updateErr != nil {
+		return fmt.Errorf("%w (and storing it failed: %s)", err, updateErr)
+	}
+	return err
+}
@@ -0,0 +1,200 @@
+package icalsync
+
+import (
+	"context"
+	"errors"
+	"github.com/majedutd990/bookings/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//memoryStore keeps the blocks and the status of every feed, like the database would
+type memoryStore struct {
+	mu       sync.Mutex
+	feeds    []models.ICalFeed
+	blocks   map[int][]models.RoomRestriction
+	syncedAt map[int]time.Time
+	errs     map[int]string
+	syncErr  error
+}
+
+func newMemoryStore(feeds ...models.ICalFeed) *memoryStore {
+	return &memoryStore{
+		feeds:    feeds,
+		blocks:   make(map[int][]models.RoomRestriction),
+		syncedAt: make(map[int]time.Time),
+		errs:     make(map[int]string),
+	}
+}
+
+func (s *memoryStore) GetICalFeeds() ([]models.ICalFeed, error) {
+	return s.feeds, nil
+}
+
+func (s *memoryStore) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syncErr != nil {
+		return s.syncErr
+	}
+	s.blocks[feed.ID] = blocks
+	return nil
+}
+
+func (s *memoryStore) UpdateICalFeedStatus(id int, syncedAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errs[id] = lastError
+	if lastError == "" {
+		s.syncedAt[id] = syncedAt
+	}
+	return nil
+}
+
+//channel is a stand-in for the calendar of another booking channel
+type channel struct {
+	mu       sync.Mutex
+	calendar string
+	status   int
+}
+
+func (c *channel) set(status int, events ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+	c.calendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func (c *channel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status != http.StatusOK {
+		http.Error(w, "nope", c.status)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar")
+	_, _ = w.Write([]byte(c.calendar))
+}
+
+func event(uid, start, end string) string {
+	return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART;VALUE=DATE:" + start + "\r\nDTEND;VALUE=DATE:" + end +
+		"\r\nSUMMARY:Reserved\r\nEND:VEVENT\r\n"
+}
+
+func TestSyncer_SyncFeed(t *testing.T) {
+	upstream := &channel{}
+	srv := httptest.NewServer(upstream)
+	defer srv.Close()
+
+	feed := models.ICalFeed{ID: 1, RoomID: 2, Name: "airbnb", URL: srv.URL + "/calendar.ics"}
+	store := newMemoryStore(feed)
+	s := New(store, srv.Client())
+	now := time.Date(2050, 1, 5, 15, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	// a booking, one that ended yesterday and one that ends today
+	upstream.set(http.StatusOK, event("a@airbnb", "20500110", "20500113"), event("old@airbnb", "20500101", "20500104"),
+		event("today@airbnb", "20500103", "20500105"))
+	n, err := s.SyncFeed(context.Background(), feed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || len(store.blocks[1]) != 1 {
+		t.Fatalf("expected 1 block, got %d: %+v", n, store.blocks[1])
+	}
+	b := store.blocks[1][0]
+	if b.ExternalUID != "a@airbnb" ||
+		!b.StartDate.Equal(time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC)) || !b.EndDate.Equal(time.Date(2050, 1, 13, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected block %+v", b)
+	}
+	if !store.syncedAt[1].Equal(now) || store.errs[1] != "" {
+		t.Errorf("expected the feed synced at %s, got %s (%q)", now, store.syncedAt[1], store.errs[1])
+	}
+
+	// the booking moved and a new one came, the store gets all of them again
+	upstream.set(http.StatusOK, event("a@airbnb", "20500111", "20500114"), event("b@airbnb", "20500120", "20500121"))
+	if n, err = s.SyncFeed(context.Background(), feed); err != nil || n != 2 {
+		t.Fatalf("expected 2 blocks, got %d (%v)", n, err)
+	}
+
+	// a broken channel keeps the blocks we have
+	upstream.set(http.StatusServiceUnavailable)
+	_, err = s.SyncFeed(context.Background(), feed)
+	if err == nil {
+		t.Fatal("expected an error from the channel")
+	}
+	if len(store.blocks[1]) != 2 || !strings.Contains(store.errs[1], "503") || !store.syncedAt[1].Equal(now) {
+		t.Errorf("expected the blocks and the time of the last sync to stay, got %+v %q", store.blocks[1], store.errs[1])
+	}
+
+	// everything cancelled upstream
+	upstream.set(http.StatusOK)
+	if n, err = s.SyncFeed(context.Background(), feed); err != nil || n != 0 || len(store.blocks[1]) != 0 {
+		t.Errorf("expected no blocks left, got %d (%v)", n, err)
+	}
+}
+
+func TestSyncer_SyncAll(t *testing.T) {
+	upstream := &channel{}
+	upstream.set(http.StatusOK, event("a@booking", "20500110", "20500113"))
+	mux := http.NewServeMux()
+	mux.Handle("/", upstream)
+	mux.HandleFunc("/404", http.NotFound)
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("<html></html>")) })
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	store := newMemoryStore(
+		models.ICalFeed{ID: 1, RoomID: 1, Name: "booking.com", URL: srv.URL},
+		models.ICalFeed{ID: 2, RoomID: 1, Name: "uploaded"},
+		models.ICalFeed{ID: 3, RoomID: 2, Name: "gone", URL: srv.URL + "/404"},
+		models.ICalFeed{ID: 4, RoomID: 2, Name: "not a calendar", URL: srv.URL + "/html"},
+	)
+
+	s := New(store, srv.Client())
+	s.now = func() time.Time { return time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC) }
+	err := s.SyncAll(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "2 of 4 feeds failed") {
+		t.Errorf("expected 2 failed feeds, got %v", err)
+	}
+	if len(store.blocks[1]) != 1 {
+		t.Errorf("expected the working feed synced, got %+v", store.blocks[1])
+	}
+	if _, ok := store.errs[2]; ok {
+		t.Error("a feed without url must not be synced")
+	}
+	if store.errs[3] == "" || store.errs[4] == "" {
+		t.Errorf("expected the errors of the broken feeds stored, got %+v", store.errs)
+	}
+}
+
+func TestSyncer_Import(t *testing.T) {
+	store := newMemoryStore()
+	s := New(store, nil)
+	s.now = func() time.Time { return time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC) }
+	feed := models.ICalFeed{ID: 7, RoomID: 1, Name: "vrbo"}
+
+	cal := "BEGIN:VCALENDAR\r\n" + event("x@vrbo", "20500102", "20500104") + event("x@vrbo", "20500102", "20500104") + "END:VCALENDAR\r\n"
+	n, err := s.Import(feed, strings.NewReader(cal))
+	if err != nil || n != 1 {
+		t.Errorf("expected the event once, got %d (%v)", n, err)
+	}
+
+	if _, err = s.SyncFeed(context.Background(), feed); !errors.Is(err, ErrNoURL) {
+		t.Errorf("expected ErrNoURL, got %v", err)
+	}
+
+	big := strings.NewReader("BEGIN:VCALENDAR\r\n" + strings.Repeat("X-FILL:"+strings.Repeat("x", 1000)+"\r\n", MaxFeedSize/1000))
+	if _, err = s.Import(feed, big); err == nil {
+		t.Error("expected a calendar that is too big to fail")
+	}
+
+	store.syncErr = errors.New("database is down")
+	if _, err = s.Import(feed, strings.NewReader(cal)); err == nil || store.errs[7] != "database is down" {
+		t.Errorf("expected the database error stored, got %v %q", err, store.errs[7])
+	}
+}
@@ -0,0 +1,143 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"github.com/majedutd990/bookings/internal/render"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// every mail has an html page (name.page.html) and a plain text page (name.page.txt) in email-templates,
+// both use the layouts (*.layout.html / *.layout.txt) and get the same typed data
+
+//ReservationData is the data of the mails about a reservation
+type ReservationData struct {
+	Reservation models.Reservation
+	// ManageURL is the link the guest uses to manage the reservation, only set in mails to the guest
+	ManageURL string
+	// Note is an extra paragraph the front desk wrote for the guest
+	Note string
+}
+
+//ReservationChangedData is the data of the mail about a reservation that got new dates
+type ReservationChangedData struct {
+	Reservation  models.Reservation
+	OldStartDate time.Time
+	OldEndDate   time.Time
+}
+
+//PasswordLinkData is the data of the mails with a link to set a password, an invite or a reset
+type PasswordLinkData struct {
+	User models.User
+	// URL is the link to the page where the password is set, it works once
+	URL       string
+	ExpiresAt time.Time
+}
+
+var htmlFunctions = htmltemplate.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatPrice": pricing.Format,
+}
+
+var textFunctions = texttemplate.FuncMap{
+	"humanDate":   render.HumanDate,
+	"formatPrice": pricing.Format,
+}
+
+var pathToTemplates = "./email-templates"
+
+// app is a reference to AppConfig that we are sending here from main
+var app *config.AppConfig
+
+//NewMailer is the function sending the AppConfig struct here
+func NewMailer(a *config.AppConfig) {
+	app = a
+}
+
+//Message renders the mail template tmpl (e.g. "reservation-confirmation") with data into a mail,
+// with an html and a plain text version
+func Message(to, subject, tmpl string, data interface{}) (models.MailData, error) {
+	htmlCache, textCache := app.MailHTMLCache, app.MailTextCache
+	if !app.UseCache {
+		var err error
+		htmlCache, textCache, err = CreateTemplateCache(pathToTemplates)
+		if err != nil {
+			return models.MailData{}, err
+		}
+	}
+
+	ht, ok := htmlCache[tmpl]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("can't get mail template %s.page.html from cache", tmpl)
+	}
+	tt, ok := textCache[tmpl]
+	if !ok {
+		return models.MailData{}, fmt.Errorf("can't get mail template %s.page.txt from cache", tmpl)
+	}
+
+	htmlBuf := new(bytes.Buffer)
+	if err := ht.Execute(htmlBuf, data); err != nil {
+		return models.MailData{}, err
+	}
+	textBuf := new(bytes.Buffer)
+	if err := tt.Execute(textBuf, data); err != nil {
+		return models.MailData{}, err
+	}
+
+	return models.MailData{
+		To:           to,
+		Subject:      subject,
+		Content:      htmlBuf.String(),
+		PlainContent: strings.TrimSpace(textBuf.String()),
+	}, nil
+}
+
+//CreateTemplateCache parses the mail templates in dir, every page together with the layouts.
+// the maps are keyed by the template name without .page.html / .page.txt
+func CreateTemplateCache(dir string) (map[string]*htmltemplate.Template, map[string]*texttemplate.Template, error) {
+	htmlCache := map[string]*htmltemplate.Template{}
+	textCache := map[string]*texttemplate.Template{}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.page.html"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".page.html")
+		ts, err := htmltemplate.New(filepath.Base(page)).Funcs(htmlFunctions).ParseFiles(page)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		ts, err = ts.ParseGlob(filepath.Join(dir, "*.layout.html"))
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		htmlCache[name] = ts
+	}
+
+	pages, err = filepath.Glob(filepath.Join(dir, "*.page.txt"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".page.txt")
+		ts, err := texttemplate.New(filepath.Base(page)).Funcs(textFunctions).ParseFiles(page)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		ts, err = ts.ParseGlob(filepath.Join(dir, "*.layout.txt"))
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		textCache[name] = ts
+	}
+
+	return htmlCache, textCache, nil
+}
@@ -0,0 +1,100 @@
+package mailer
+
+import (
+	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testApp config.AppConfig
+
+func TestCreateTemplateCache(t *testing.T) {
+	htmlCache, textCache, err := CreateTemplateCache("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"reservation-confirmation", "reservation-notification", "reservation-changed", "reservation-cancelled",
+		"user-invite", "password-reset"} {
+		if _, ok := htmlCache[name]; !ok {
+			t.Errorf("html template %s not in cache", name)
+		}
+		if _, ok := textCache[name]; !ok {
+			t.Errorf("text template %s not in cache", name)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	pathToTemplates = "./../../email-templates"
+	testApp.UseCache = false
+	NewMailer(&testApp)
+
+	res := models.Reservation{
+		FirstName: "<b>John</b>",
+		LastName:  "Smith",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+	msg, err := Message("john@smith.com", "Reservation Confirmation!", "reservation-confirmation",
+		ReservationData{Reservation: res, ManageURL: "http://localhost:8080/reservations/manage/abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.To != "john@smith.com" || msg.Subject != "Reservation Confirmation!" {
+		t.Errorf("wrong recipient or subject: %s %s", msg.To, msg.Subject)
+	}
+	if strings.Contains(msg.Content, "<b>John</b>") {
+		t.Error("guest name was not escaped in the html part")
+	}
+	if !strings.Contains(msg.Content, "&lt;b&gt;John&lt;/b&gt;") {
+		t.Error("expected the escaped guest name in the html part")
+	}
+	if !strings.Contains(msg.PlainContent, "from 2050-01-01 to 2050-01-03") {
+		t.Errorf("expected the dates in the text part, got %s", msg.PlainContent)
+	}
+	if !strings.Contains(msg.PlainContent, "<b>John</b>") {
+		t.Error("expected the text part not to be html escaped")
+	}
+	if !strings.Contains(msg.PlainContent, "Fort Smythe Bed & Breakfast") {
+		t.Error("expected the text layout in the text part")
+	}
+
+	if strings.Contains(msg.PlainContent, "Parking") {
+		t.Error("expected no note without one")
+	}
+	msg, err = Message("john@smith.com", "Reservation Confirmation!", "reservation-confirmation",
+		ReservationData{Reservation: res, Note: "Parking is behind the house."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Content, "Parking is behind the house.") || !strings.Contains(msg.PlainContent, "Parking is behind the house.") {
+		t.Error("expected the note in both parts")
+	}
+
+	_, err = Message("john@smith.com", "Nope", "non-existing", nil)
+	if err == nil {
+		t.Error("rendered a mail template which does not exist")
+	}
+}
+
+func TestMessageFromCache(t *testing.T) {
+	htmlCache, textCache, err := CreateTemplateCache("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.MailHTMLCache = htmlCache
+	testApp.MailTextCache = textCache
+	testApp.UseCache = true
+	// a wrong path shows we don't parse the files again
+	pathToTemplates = "./nowhere"
+	NewMailer(&testApp)
+
+	_, err = Message("owner@here.com", "Reservation Cancelled!", "reservation-cancelled",
+		ReservationData{Reservation: models.Reservation{FirstName: "John"}})
+	if err != nil {
+		t.Error(err)
+	}
+}
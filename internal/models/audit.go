@@ -0,0 +1,128 @@
+package models
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+//these are the actions the audit log records
+const (
+	AuditCreate   = "create"
+	AuditUpdate   = "update"
+	AuditDelete   = "delete"
+	AuditMove     = "move"
+	AuditStatus   = "status"
+	AuditCapture  = "capture"
+	AuditRefund   = "refund"
+	AuditRestore  = "restore"
+	AuditPurge    = "purge"
+	AuditPassword = "password"
+	AuditUnlock   = "unlock"
+)
+
+//AuditActions are all actions, in the order the filter offers them
+var AuditActions = []string{AuditCreate, AuditUpdate, AuditDelete, AuditRestore, AuditPurge, AuditMove, AuditStatus,
+	AuditCapture, AuditRefund, AuditPassword, AuditUnlock}
+
+//these are the things the audit log records actions on
+const (
+	AuditReservation  = "reservation"
+	AuditBlock        = "block"
+	AuditPayment      = "payment"
+	AuditRoom         = "room"
+	AuditRoomImage    = "room_image"
+	AuditRateOverride = "rate_override"
+	AuditStayDiscount = "stay_discount"
+	AuditICalFeed     = "ical_feed"
+	AuditRestriction  = "restriction"
+	AuditAPIToken     = "api_token"
+	AuditUser         = "user"
+)
+
+//AuditEntities are all things, in the order the filter offers them
+var AuditEntities = []string{AuditReservation, AuditBlock, AuditPayment, AuditRoom, AuditRoomImage, AuditRateOverride,
+	AuditStayDiscount, AuditICalFeed, AuditRestriction, AuditAPIToken, AuditUser}
+
+//AuditPageSize is how many entries a page of the audit log shows
+const AuditPageSize = 50
+
+//AuditEntry is one action of a user in the admin area. Before and After are json,
+// "" when there was nothing before (create) or after (delete)
+type AuditEntry struct {
+	ID        int
+	UserID    int
+	UserName  string
+	Action    string
+	Entity    string
+	EntityID  int
+	Before    string
+	After     string
+	IP        string
+	CreatedAt time.Time
+}
+
+//AuditFilter picks entries of the audit log, zero values don't filter. From and To are days,
+// both included. Page starts at 1
+type AuditFilter struct {
+	UserID   int
+	Action   string
+	Entity   string
+	EntityID int
+	From     time.Time
+	To       time.Time
+	Page     int
+}
+
+//AuditChange is one field of an AuditEntry, Before or After is "" if the field is not in that snapshot
+type AuditChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+//Changes returns the fields that differ between Before and After, sorted by name.
+// for a create or a delete that is every field of the snapshot there is
+func (e AuditEntry) Changes() []AuditChange {
+	before := auditFields(e.Before)
+	after := auditFields(e.After)
+	var fields []string
+	for f := range before {
+		fields = append(fields, f)
+	}
+	for f := range after {
+		if _, ok := before[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+
+	var changes []AuditChange
+	for _, f := range fields {
+		if before[f] != after[f] {
+			changes = append(changes, AuditChange{Field: f, Before: before[f], After: after[f]})
+		}
+	}
+	return changes
+}
+
+//auditFields reads a json snapshot of an AuditEntry, snapshots that are no json object have no fields
+func auditFields(snapshot string) map[string]string {
+	fields := make(map[string]string)
+	var values map[string]interface{}
+	if json.Unmarshal([]byte(snapshot), &values) != nil {
+		return fields
+	}
+	for k, v := range values {
+		switch v := v.(type) {
+		case nil:
+			fields[k] = ""
+		case string:
+			fields[k] = v
+		default:
+			b, _ := json.Marshal(v)
+			fields[k] = string(b)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+var auditChangesTests = []struct {
+	name     string
+	entry    AuditEntry
+	expected []AuditChange
+}{
+	{"update", AuditEntry{Before: `{"first_name":"Jon","last_name":"Doe","room_id":1}`, After: `{"first_name":"John","last_name":"Doe","room_id":2}`},
+		[]AuditChange{{"first_name", "Jon", "John"}, {"room_id", "1", "2"}}},
+	{"create", AuditEntry{After: `{"name":"Winter","percent":10}`},
+		[]AuditChange{{"name", "", "Winter"}, {"percent", "", "10"}}},
+	{"delete", AuditEntry{Before: `{"active":true}`},
+		[]AuditChange{{"active", "true", ""}}},
+	{"nothing changed", AuditEntry{Before: `{"status":"pending"}`, After: `{"status":"pending"}`}, nil},
+	{"no json", AuditEntry{Before: "oops"}, nil},
+}
+
+func TestAuditEntry_Changes(t *testing.T) {
+	for _, e := range auditChangesTests {
+		if actual := e.entry.Changes(); !reflect.DeepEqual(actual, e.expected) {
+			t.Errorf("%s: expected %v got %v", e.name, e.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+//these are the results we record in login_attempts
+const (
+	LoginSuccess = "success"
+	LoginFailed  = "failed"
+	// LoginLocked attempts were turned away before the password was checked, after too many failures
+	LoginLocked = "locked"
+	// LoginUnlocked is no attempt but an owner unlocking the email, the failures before it don't count anymore
+	LoginUnlocked = "unlocked"
+)
+
+//LoginResults are all results, in the order the filter offers them
+var LoginResults = []string{LoginSuccess, LoginFailed, LoginLocked, LoginUnlocked}
+
+//LoginPageSize is how many attempts a page of the login activity shows
+const LoginPageSize = 50
+
+//LoginAttempt is one try to log in. UserID is 0 if the email belongs to nobody
+type LoginAttempt struct {
+	ID        int
+	UserID    int
+	UserName  string
+	Email     string
+	IP        string
+	UserAgent string
+	Result    string
+	CreatedAt time.Time
+}
+
+//LoginAttemptFilter selects login attempts, empty fields match everything
+type LoginAttemptFilter struct {
+	Email  string
+	IP     string
+	Result string
+	Page   int
+}
+
+//LockedLogin is an email that can't log in right now because of too many failures
+type LockedLogin struct {
+	Email       string
+	Failures    int
+	LastFailure time.Time
+}
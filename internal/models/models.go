@@ -11,25 +11,96 @@ type User struct {
 	LastName    string
 	Email       string
 	Password    string
-	AccessLevel string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	AccessLevel int
+	// Active is false for deactivated staff, they can't log in anymore
+	Active bool
+	// SessionVersion goes up with every new password, sessions of an older version are logged out
+	SessionVersion int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+//Invited tells if the user has not set a password yet
+func (u User) Invited() bool {
+	return u.Password == ""
 }
 
 //Room is the room model
 type Room struct {
+	ID       int
+	RoomName string
+	// Slug is the room's part of the url, /rooms/{slug}
+	Slug         string
+	Description  string
+	MaxOccupancy int
+	// Amenities are stored one per line
+	Amenities []string
+	// Active rooms are shown on the site and can be booked
+	Active    bool
+	SortOrder int
+	// BaseRate and WeekendRate are in cents, see the pricing package
+	BaseRate    int
+	WeekendRate int
+	Images      []RoomImage
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//RoomImage is a photo in the gallery of a room
+type RoomImage struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Path      string
+	Caption   string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-//Restriction is the Restriction model
+//Restriction is the Restriction model, a kind of unavailability of a room
 type Restriction struct {
 	ID              int
 	RestrictionName string
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
+	// Code is set on the restrictions the code itself relies on, see RestrictionReservation
+	Code string
+	// Colour is how the days of the restriction look on the calendar, like #17a2b8
+	Colour string
+	// Unavailable restrictions keep guests from booking the room, the others are only notes on the calendar
+	Unavailable bool
+	// Uses is how many room restrictions have the restriction
+	Uses      int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//these are the codes of the restrictions the code relies on, they are looked up by the code.
+// they can be renamed but not deleted and always count as unavailable
+const (
+	RestrictionReservation     = "reservation"
+	RestrictionOwnerBlock      = "owner_block"
+	RestrictionExternalBooking = "external_booking"
+)
+
+//IsSystem reports whether the code relies on the restriction
+func (r Restriction) IsSystem() bool {
+	return r.Code != ""
+}
+
+//IsBlockType reports whether owners may block a room with the restriction,
+// reservations and external bookings get theirs on their own
+func (r Restriction) IsBlockType() bool {
+	return r.Code != RestrictionReservation && r.Code != RestrictionExternalBooking
+}
+
+//BlockTypes returns the restrictions owners may block a room with
+func BlockTypes(restrictions []Restriction) []Restriction {
+	var types []Restriction
+	for _, r := range restrictions {
+		if r.IsBlockType() {
+			types = append(types, r)
+		}
+	}
+	return types
 }
 
 //Reservation is Reservation  model
@@ -44,8 +115,21 @@ type Reservation struct {
 	RoomID    int
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Processed int
-	Room      Room
+	// Status is where the reservation is in its lifecycle, see status.go
+	Status string
+	Room   Room
+	// ManageTokenHash is the hash of the token in the guest's manage link
+	ManageTokenHash string
+	CancelledAt     time.Time
+	// TotalPrice is what the stay costs in cents, quoted when it was booked or moved
+	TotalPrice    int
+	Payments      []Payment
+	StatusHistory []StatusChange
+	// DeletedAt is when the reservation was moved to the trash, zero if it is not in the trash.
+	// DeletedBy is the user who did it
+	DeletedAt     time.Time
+	DeletedBy     int
+	DeletedByName string
 }
 
 // RoomRestriction  is RoomRestriction  model
@@ -59,15 +143,115 @@ type RoomRestriction struct {
 	Reservation   Reservation
 	RestrictionID int
 	Restrictions  Restriction
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	// ICalFeedID and ExternalUID are set on blocks that were imported from another booking channel
+	ICalFeedID  int
+	ExternalUID string
+	// Reason is why the owner blocked the room
+	Reason    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//ICalFeed is a calendar of another booking channel whose bookings block one of our rooms
+type ICalFeed struct {
+	ID     int
+	RoomID int
+	Name   string
+	// URL is fetched by the sync, feeds without one are .ics files uploaded by hand
+	URL          string
+	LastSyncedAt time.Time
+	// LastError is why the last sync failed, "" if it worked
+	LastError string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//APIToken is a token non-browser clients use instead of logging in.
+// we only store the hash of the token, the token itself is shown once when it is issued
+type APIToken struct {
+	ID          int
+	UserID      int
+	Name        string
+	TokenHash   string
+	AccessLevel int
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	User        User
+}
+
+//these are the reasons a password token is mailed for
+const (
+	PasswordInvite = "invite"
+	PasswordReset  = "reset"
+)
+
+//PasswordToken lets a user set their password through a mailed link. like api tokens
+// we only store the hash, and a token works once
+type PasswordToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	User      User
 }
 
 //MailData is structure of our Email
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To      string
+	From    string
+	Subject string
+	// Content is the html version of the mail
+	Content string
+	// PlainContent is the text/plain alternative, mails without it are sent as html only
+	PlainContent string
+}
+
+//these are the states of a mail in the outbox
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	// OutboxDead mails gave up after too many attempts, they wait for an admin to retry them
+	OutboxDead = "dead"
+)
+
+//OutboxMail is a mail waiting in (or done with) the mail_outbox table
+type OutboxMail struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+//these are the states of a payment
+const (
+	PaymentAuthorized = "authorized"
+	PaymentCaptured   = "captured"
+	PaymentRefunded   = "refunded"
+	PaymentFailed     = "failed"
+)
+
+//Payment is money a guest paid (or was asked to pay) for a reservation, amounts are in cents
+type Payment struct {
+	ID            int
+	ReservationID int
+	// Provider and Reference tell which payment of which payment provider this is
+	Provider  string
+	Reference string
+	Amount    int
+	// Refunded is how much of Amount was given back
+	Refunded  int
+	Status    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
@@ -0,0 +1,19 @@
+package models
+
+import "testing"
+
+func TestBlockTypes(t *testing.T) {
+	restrictions := []Restriction{
+		{ID: 1, Code: RestrictionReservation},
+		{ID: 2, Code: RestrictionOwnerBlock},
+		{ID: 3, Code: RestrictionExternalBooking},
+		{ID: 4},
+	}
+	types := BlockTypes(restrictions)
+	if len(types) != 2 || types[0].ID != 2 || types[1].ID != 4 {
+		t.Errorf("expected the owner block and 4, got %+v", types)
+	}
+	if !restrictions[1].IsSystem() || restrictions[3].IsSystem() {
+		t.Error("only restrictions with a code are needed by the code")
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+//these are the access levels we keep in users.access_level, every role can do
+// everything the roles below it can do
+const (
+	AccessLevelViewer    = 1
+	AccessLevelFrontDesk = 2
+	AccessLevelManager   = 3
+	AccessLevelOwner     = 4
+)
+
+//RoleNames are the human names of the access levels
+var RoleNames = map[int]string{
+	AccessLevelViewer:    "Viewer",
+	AccessLevelFrontDesk: "Front Desk",
+	AccessLevelManager:   "Manager",
+	AccessLevelOwner:     "Owner",
+}
+
+//Permissions maps every protected action to the lowest access level that may take it
+var Permissions = map[string]int{
+	"reservations.view":    AccessLevelViewer,
+	"reservations.edit":    AccessLevelFrontDesk,
+	"reservations.process": AccessLevelFrontDesk,
+	"reservations.delete":  AccessLevelManager,
+	"blocks.edit":          AccessLevelManager,
+	"api_tokens.manage":    AccessLevelViewer,
+	"mail.manage":          AccessLevelManager,
+	"rooms.manage":         AccessLevelManager,
+	"restrictions.manage":  AccessLevelManager,
+	"payments.manage":      AccessLevelManager,
+	"audit.view":           AccessLevelOwner,
+	"users.manage":         AccessLevelOwner,
+}
+
+//RoleName returns the name of an access level
+func RoleName(accessLevel int) string {
+	if name, ok := RoleNames[accessLevel]; ok {
+		return name
+	}
+	return "None"
+}
+
+//Can reports whether an access level is enough for a permission, unknown permissions are never granted
+func Can(accessLevel int, permission string) bool {
+	required, ok := Permissions[permission]
+	if !ok {
+		return false
+	}
+	return accessLevel >= required
+}
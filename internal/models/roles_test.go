@@ -0,0 +1,46 @@
+package models
+
+import "testing"
+
+var canTests = []struct {
+	name        string
+	accessLevel int
+	permission  string
+	expected    bool
+}{
+	{"viewer can view", AccessLevelViewer, "reservations.view", true},
+	{"viewer can't edit", AccessLevelViewer, "reservations.edit", false},
+	{"front desk can process", AccessLevelFrontDesk, "reservations.process", true},
+	{"front desk can't delete", AccessLevelFrontDesk, "reservations.delete", false},
+	{"manager can delete", AccessLevelManager, "reservations.delete", true},
+	{"owner can edit blocks", AccessLevelOwner, "blocks.edit", true},
+	{"nobody can do unknown things", AccessLevelOwner, "fly.away", false},
+	{"logged out can't view", 0, "reservations.view", false},
+}
+
+func TestCan(t *testing.T) {
+	for _, e := range canTests {
+		if Can(e.accessLevel, e.permission) != e.expected {
+			t.Errorf("%s: expected %t for level %d and %s", e.name, e.expected, e.accessLevel, e.permission)
+		}
+	}
+}
+
+func TestTemplateData_Can(t *testing.T) {
+	td := TemplateData{AccessLevel: AccessLevelFrontDesk}
+	if !td.Can("reservations.edit") {
+		t.Error("front desk should be able to edit reservations")
+	}
+	if td.Can("blocks.edit") {
+		t.Error("front desk should not be able to edit blocks")
+	}
+}
+
+func TestRoleName(t *testing.T) {
+	if RoleName(AccessLevelManager) != "Manager" {
+		t.Errorf("expected Manager got %s", RoleName(AccessLevelManager))
+	}
+	if RoleName(42) != "None" {
+		t.Errorf("expected None got %s", RoleName(42))
+	}
+}
@@ -0,0 +1,105 @@
+package models
+
+import "time"
+
+//these are the statuses of a reservation, we keep them in reservations.status
+const (
+	StatusPending    = "pending"
+	StatusConfirmed  = "confirmed"
+	StatusCheckedIn  = "checked_in"
+	StatusCheckedOut = "checked_out"
+	StatusCancelled  = "cancelled"
+	StatusNoShow     = "no_show"
+)
+
+//Statuses are all statuses in the order a stay goes through them
+var Statuses = []string{StatusPending, StatusConfirmed, StatusCheckedIn, StatusCheckedOut, StatusCancelled, StatusNoShow}
+
+//OpenStatuses are the statuses of reservations someone still has to act on
+var OpenStatuses = []string{StatusPending, StatusConfirmed, StatusCheckedIn}
+
+//StatusNames are the human names of the statuses
+var StatusNames = map[string]string{
+	StatusPending:    "Pending",
+	StatusConfirmed:  "Confirmed",
+	StatusCheckedIn:  "Checked In",
+	StatusCheckedOut: "Checked Out",
+	StatusCancelled:  "Cancelled",
+	StatusNoShow:     "No Show",
+}
+
+//StatusActions are the names of the buttons that move a reservation into a status
+var StatusActions = map[string]string{
+	StatusConfirmed:  "Confirm",
+	StatusCheckedIn:  "Check In",
+	StatusCheckedOut: "Check Out",
+	StatusCancelled:  "Cancel",
+	StatusNoShow:     "No Show",
+}
+
+//statusTransitions maps every status to the statuses it may go to, checked out, cancelled and no show are final
+var statusTransitions = map[string][]string{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusCancelled, StatusNoShow},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+//StatusChange is one step of a reservation through its statuses, UserID is 0 when the guest took it
+type StatusChange struct {
+	ID            int
+	ReservationID int
+	FromStatus    string
+	ToStatus      string
+	UserID        int
+	UserName      string
+	CreatedAt     time.Time
+}
+
+//StatusName returns the name of a status
+func StatusName(status string) string {
+	if name, ok := StatusNames[status]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+//StatusAction returns the name of the button that moves a reservation into status
+func StatusAction(status string) string {
+	return StatusActions[status]
+}
+
+//IsStatus reports whether status is one of ours
+func IsStatus(status string) bool {
+	_, ok := StatusNames[status]
+	return ok
+}
+
+//NextStatuses returns the statuses a reservation may go to from status
+func NextStatuses(status string) []string {
+	return statusTransitions[status]
+}
+
+//CanTransition reports whether a reservation may go from one status to another
+func CanTransition(from, to string) bool {
+	for _, s := range statusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+//IsOpen reports whether a reservation in status still takes its room and may be moved
+func IsOpen(status string) bool {
+	for _, s := range OpenStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+//FreesRoom reports whether a reservation in status no longer takes its room
+func FreesRoom(status string) bool {
+	return status == StatusCancelled || status == StatusNoShow
+}
@@ -0,0 +1,46 @@
+package models
+
+import "testing"
+
+var canTransitionTests = []struct {
+	name     string
+	from     string
+	to       string
+	expected bool
+}{
+	{"confirm a pending reservation", StatusPending, StatusConfirmed, true},
+	{"cancel a pending reservation", StatusPending, StatusCancelled, true},
+	{"can't check in before confirming", StatusPending, StatusCheckedIn, false},
+	{"check in", StatusConfirmed, StatusCheckedIn, true},
+	{"no show", StatusConfirmed, StatusNoShow, true},
+	{"check out", StatusCheckedIn, StatusCheckedOut, true},
+	{"can't cancel once checked in", StatusCheckedIn, StatusCancelled, false},
+	{"checked out is final", StatusCheckedOut, StatusCheckedIn, false},
+	{"cancelled is final", StatusCancelled, StatusPending, false},
+	{"no show is final", StatusNoShow, StatusConfirmed, false},
+	{"unknown status", "lost", StatusConfirmed, false},
+}
+
+func TestCanTransition(t *testing.T) {
+	for _, e := range canTransitionTests {
+		if CanTransition(e.from, e.to) != e.expected {
+			t.Errorf("%s: expected %t from %s to %s", e.name, e.expected, e.from, e.to)
+		}
+	}
+}
+
+func TestStatuses(t *testing.T) {
+	for _, s := range Statuses {
+		if !IsStatus(s) {
+			t.Errorf("%s has no name", s)
+		}
+		for _, next := range NextStatuses(s) {
+			if StatusAction(next) == "" {
+				t.Errorf("%s has no action", next)
+			}
+		}
+	}
+	if StatusName("lost") != "Unknown" {
+		t.Errorf("expected Unknown got %s", StatusName("lost"))
+	}
+}
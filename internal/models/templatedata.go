@@ -19,4 +19,10 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	AccessLevel     int
+}
+
+//Can lets templates hide the actions the current user is not allowed to take
+func (td *TemplateData) Can(permission string) bool {
+	return Can(td.AccessLevel, permission)
 }
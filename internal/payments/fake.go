@@ -0,0 +1,137 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//these card numbers make the fake provider behave differently, every other number of 12 digits or more pays
+const (
+	FakeCardDeclined     = "4000000000000002"
+	FakeCardCaptureFails = "4000000000000341"
+)
+
+//FakeSignatureHeader holds the signature of the fake provider's webhooks
+const FakeSignatureHeader = "X-Fake-Signature"
+
+//Fake is a payment provider that runs in memory, so bookings can be paid locally and in the tests.
+// it forgets its payments on restart, payments it does not know are trusted with the amounts they are given
+type Fake struct {
+	secret []byte
+	mu     sync.Mutex
+	// payments are kept by reference
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	authorized   int
+	captured     int
+	refunded     int
+	captureFails bool
+}
+
+//NewFake returns a fake provider, its webhooks are signed with secret. without a secret no webhook is accepted
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+//Name is the name of the fake provider
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+//Authorize holds the amount, unless the card is FakeCardDeclined or not a card number
+func (f *Fake) Authorize(req Request) (string, error) {
+	card := strings.Join(strings.Fields(req.Source), "")
+	notDigit := strings.IndexFunc(card, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0
+	if len(card) < 12 || notDigit || card == FakeCardDeclined || req.Amount <= 0 {
+		return "", ErrDeclined
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	reference := "fake_" + hex.EncodeToString(b)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.payments[reference] = &fakePayment{authorized: req.Amount, captureFails: card == FakeCardCaptureFails}
+	return reference, nil
+}
+
+//Capture takes amount of a payment, paid with FakeCardCaptureFails it is declined
+func (f *Fake) Capture(reference string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[reference]
+	if !ok {
+		f.payments[reference] = &fakePayment{authorized: amount, captured: amount}
+		return nil
+	}
+	if p.captureFails {
+		return ErrDeclined
+	}
+	if amount <= 0 || p.captured+amount > p.authorized {
+		return ErrAmount
+	}
+	p.captured += amount
+	return nil
+}
+
+//Refund gives back amount of what was captured, or releases the hold of a payment that was not captured
+func (f *Fake) Refund(reference string, amount int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[reference]
+	if !ok {
+		f.payments[reference] = &fakePayment{authorized: amount, captured: amount, refunded: amount}
+		return nil
+	}
+	if p.captured == 0 {
+		p.authorized = 0
+		return nil
+	}
+	if amount <= 0 || p.refunded+amount > p.captured {
+		return ErrAmount
+	}
+	p.refunded += amount
+	return nil
+}
+
+//VerifyWebhook reads an Event from a json body signed with the hex hmac-sha256 of the secret in FakeSignatureHeader
+func (f *Fake) VerifyWebhook(r *http.Request) (Event, error) {
+	var ev Event
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, 1<<20))
+	if err != nil {
+		return ev, err
+	}
+	signature, err := hex.DecodeString(r.Header.Get(FakeSignatureHeader))
+	if err != nil || len(f.secret) == 0 || !hmac.Equal(signature, f.sign(body)) {
+		return ev, ErrInvalidSignature
+	}
+	err = json.Unmarshal(body, &ev)
+	return ev, err
+}
+
+//Sign returns the signature of a webhook body, to send fake webhooks from scripts and tests
+func (f *Fake) Sign(body []byte) string {
+	return hex.EncodeToString(f.sign(body))
+}
+
+func (f *Fake) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// all amounts are in cents, like in the pricing package
+
+//ErrDeclined is returned when the guest's card or account does not pay, the guest can try another one
+var ErrDeclined = errors.New("the payment was declined")
+
+//ErrInvalidSignature is returned for webhooks that were not sent by the provider
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+//ErrAmount is returned when more is captured or refunded than there is
+var ErrAmount = errors.New("the amount is more than what is left of the payment")
+
+//these are the events a provider tells us about through its webhook
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+//Provider is a payment gateway. a payment is first authorized, which only holds the money,
+// and captured once the booking is stored, so nobody pays for a room someone else got first
+type Provider interface {
+	// Name is stored with every payment, so we know where its reference belongs
+	Name() string
+	// Authorize holds the amount and returns the provider's reference of the payment
+	Authorize(req Request) (string, error)
+	// Capture takes amount of an authorized payment
+	Capture(reference string, amount int) error
+	// Refund gives amount of a captured payment back, on a payment that is only authorized it releases the hold
+	Refund(reference string, amount int) error
+	// VerifyWebhook checks that a webhook request comes from the provider and reads its event
+	VerifyWebhook(r *http.Request) (Event, error)
+}
+
+//Request is what we ask a provider to authorize
+type Request struct {
+	Amount      int
+	Description string
+	Email       string
+	// Source is what the guest pays with: a card number for the fake provider,
+	// real gateways give us a token from their checkout script instead
+	Source string
+}
+
+//Event is something that happened to a payment at the provider, like a refund made in its dashboard.
+// providers may send an event more than once, always with the same ID
+type Event struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Reference string `json:"reference"`
+	Amount    int    `json:"amount"`
+}
+
+//New returns the provider with the given name
+func New(name, webhookSecret string) (Provider, error) {
+	switch name {
+	case "fake":
+		return NewFake(webhookSecret), nil
+	}
+	return nil, fmt.Errorf("unknown payment provider %q", name)
+}
+
+//Known reports whether New knows a provider
+func Known(name string) bool {
+	return name == "fake"
+}
+
+//Deposit is percent of total, rounded half up
+func Deposit(total, percent int) int {
+	return (total*percent + 50) / 100
+}
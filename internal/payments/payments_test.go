@@ -0,0 +1,122 @@
+package payments
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeposit(t *testing.T) {
+	var tests = []struct {
+		total, percent, expected int
+	}{
+		{10000, 20, 2000},
+		{10050, 10, 1005},
+		{999, 50, 500},
+		{12345, 0, 0},
+		{12345, 100, 12345},
+	}
+	for _, e := range tests {
+		if got := Deposit(e.total, e.percent); got != e.expected {
+			t.Errorf("deposit of %d%% of %d: expected %d got %d", e.percent, e.total, e.expected, got)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New("fake", "secret")
+	if err != nil || p.Name() != "fake" {
+		t.Errorf("expected the fake provider, got %v %v", p, err)
+	}
+	if _, err = New("paypal", ""); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+	if !Known("fake") || Known("") {
+		t.Error("Known reports wrong providers")
+	}
+}
+
+func TestFake_Authorize(t *testing.T) {
+	var tests = []struct {
+		name     string
+		source   string
+		amount   int
+		expected error
+	}{
+		{"pays", "4242 4242 4242 4242", 2000, nil},
+		{"declined card", FakeCardDeclined, 2000, ErrDeclined},
+		{"not a card", "4242-4242", 2000, ErrDeclined},
+		{"nothing to pay", "4242424242424242", 0, ErrDeclined},
+	}
+	f := NewFake("")
+	for _, e := range tests {
+		ref, err := f.Authorize(Request{Amount: e.amount, Source: e.source})
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected error %v got %v", e.name, e.expected, err)
+		}
+		if err == nil && !strings.HasPrefix(ref, "fake_") {
+			t.Errorf("%s: unexpected reference %q", e.name, ref)
+		}
+	}
+}
+
+func TestFake_CaptureAndRefund(t *testing.T) {
+	f := NewFake("")
+	ref, _ := f.Authorize(Request{Amount: 2000, Source: "4242424242424242"})
+	if err := f.Capture(ref, 2500); !errors.Is(err, ErrAmount) {
+		t.Errorf("capturing more than authorized: expected ErrAmount got %v", err)
+	}
+	if err := f.Capture(ref, 2000); err != nil {
+		t.Errorf("capture failed: %v", err)
+	}
+	if err := f.Capture(ref, 2000); !errors.Is(err, ErrAmount) {
+		t.Errorf("capturing twice: expected ErrAmount got %v", err)
+	}
+	if err := f.Refund(ref, 1500); err != nil {
+		t.Errorf("refund failed: %v", err)
+	}
+	if err := f.Refund(ref, 1000); !errors.Is(err, ErrAmount) {
+		t.Errorf("refunding more than captured: expected ErrAmount got %v", err)
+	}
+
+	ref, _ = f.Authorize(Request{Amount: 2000, Source: FakeCardCaptureFails})
+	if err := f.Capture(ref, 2000); !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected the capture to be declined, got %v", err)
+	}
+	if err := f.Refund(ref, 2000); err != nil {
+		t.Errorf("releasing an authorization failed: %v", err)
+	}
+
+	// payments from before a restart
+	if err := f.Capture("fake_old", 2000); err != nil {
+		t.Errorf("capture of unknown payment failed: %v", err)
+	}
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	body := `{"type":"payment.refunded","reference":"fake_1","amount":500}`
+	var tests = []struct {
+		name      string
+		secret    string
+		signature string
+		valid     bool
+	}{
+		{"signed", "secret", NewFake("secret").Sign([]byte(body)), true},
+		{"wrong signature", "secret", NewFake("other").Sign([]byte(body)), false},
+		{"no signature", "secret", "", false},
+		{"no secret", "", NewFake("").Sign([]byte(body)), false},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/payments/webhook", strings.NewReader(body))
+		req.Header.Set(FakeSignatureHeader, e.signature)
+		ev, err := NewFake(e.secret).VerifyWebhook(req)
+		if e.valid {
+			if err != nil || ev.Type != EventRefunded || ev.Reference != "fake_1" || ev.Amount != 500 {
+				t.Errorf("%s: expected the event, got %+v %v", e.name, ev, err)
+			}
+		} else if !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected ErrInvalidSignature got %v", e.name, err)
+		}
+	}
+}
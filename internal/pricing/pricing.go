@@ -0,0 +1,174 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// all amounts are in cents, so we never round money with floats
+
+//ErrNoNights is returned for a stay that does not end after it starts
+var ErrNoNights = errors.New("the stay must be at least one night")
+
+//ErrNoRate is returned for a room that has no base rate yet
+var ErrNoRate = errors.New("the room has no base rate")
+
+//Rates are the prices of one room
+type Rates struct {
+	RoomID   int
+	BaseRate int
+	// WeekendRate is the rate of friday and saturday nights, 0 means the base rate
+	WeekendRate int
+	Overrides   []Override
+	Discounts   []Discount
+}
+
+//Override replaces the nightly rate from StartDate to EndDate, both nights included.
+// a Yearly override is a season: it comes back every year, only month and day count
+type Override struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	Yearly      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+//Discount takes Percent off the stay if it is at least MinNights long
+type Discount struct {
+	ID        int
+	RoomID    int
+	MinNights int
+	Percent   int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+//Night is the price of one night of a quote
+type Night struct {
+	Date time.Time
+	Rate int
+	// RateName tells the guest where the rate comes from: Base rate, Weekend rate or the name of an override
+	RateName string
+}
+
+//Quote is the price of a stay, night by night
+type Quote struct {
+	StartDate       time.Time
+	EndDate         time.Time
+	Nights          []Night
+	Subtotal        int
+	DiscountPercent int
+	Discount        int
+	Total           int
+}
+
+//Quote prices the nights from start up to the day before end. for every night:
+//  1. an override for these dates, the first one in Overrides if there are more
+//  2. else a season (yearly override), again the first one
+//  3. else the weekend rate on friday and saturday
+//  4. else the base rate
+// the longest stay discount the stay qualifies for is taken off the subtotal
+func (r Rates) Quote(start, end time.Time) (Quote, error) {
+	start, end = day(start), day(end)
+	q := Quote{StartDate: start, EndDate: end}
+	if !end.After(start) {
+		return q, ErrNoNights
+	}
+	if r.BaseRate <= 0 {
+		return q, ErrNoRate
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		n := r.night(d)
+		q.Nights = append(q.Nights, n)
+		q.Subtotal += n.Rate
+	}
+
+	for _, discount := range r.Discounts {
+		if len(q.Nights) >= discount.MinNights && discount.Percent > q.DiscountPercent {
+			q.DiscountPercent = discount.Percent
+		}
+	}
+	// round half up to the cent
+	q.Discount = (q.Subtotal*q.DiscountPercent + 50) / 100
+	q.Total = q.Subtotal - q.Discount
+	return q, nil
+}
+
+//night prices one night
+func (r Rates) night(d time.Time) Night {
+	for _, o := range r.Overrides {
+		if !o.Yearly && o.covers(d) {
+			return Night{Date: d, Rate: o.NightlyRate, RateName: o.Name}
+		}
+	}
+	for _, o := range r.Overrides {
+		if o.Yearly && o.covers(d) {
+			return Night{Date: d, Rate: o.NightlyRate, RateName: o.Name}
+		}
+	}
+	if r.WeekendRate > 0 && (d.Weekday() == time.Friday || d.Weekday() == time.Saturday) {
+		return Night{Date: d, Rate: r.WeekendRate, RateName: "Weekend rate"}
+	}
+	return Night{Date: d, Rate: r.BaseRate, RateName: "Base rate"}
+}
+
+//covers reports whether the night of d is in the override
+func (o Override) covers(d time.Time) bool {
+	if !o.Yearly {
+		return !d.Before(day(o.StartDate)) && !d.After(day(o.EndDate))
+	}
+	md := monthDay(d)
+	from, to := monthDay(o.StartDate), monthDay(o.EndDate)
+	if from <= to {
+		return md >= from && md <= to
+	}
+	// a season over new year, like december 20 to january 5
+	return md >= from || md <= to
+}
+
+//monthDay makes month and day comparable, march 5th is 305
+func monthDay(d time.Time) int {
+	return int(d.Month())*100 + d.Day()
+}
+
+//day drops the time of day, the nights of a stay are counted in calendar days
+func day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+//Format shows an amount in cents as dollars, 12050 is $120.50
+func Format(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+//amountRegexp matches dollars with at most two decimals
+var amountRegexp = regexp.MustCompile(`^(\d+)(?:\.(\d{1,2}))?$`)
+
+//ParseAmount reads an amount in dollars like 120, 120.5 or $120.50 into cents
+func ParseAmount(s string) (int, error) {
+	m := amountRegexp.FindStringSubmatch(strings.TrimPrefix(strings.TrimSpace(s), "$"))
+	if m == nil {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	dollars, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an amount", s)
+	}
+	cents, _ := strconv.Atoi((m[2] + "00")[:2])
+	return dollars*100 + cents, nil
+}
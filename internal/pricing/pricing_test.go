@@ -0,0 +1,116 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+var rates = Rates{
+	RoomID:      1,
+	BaseRate:    10000,
+	WeekendRate: 12000,
+	Overrides: []Override{
+		{Name: "Summer", StartDate: date("2000-07-01"), EndDate: date("2000-08-31"), NightlyRate: 15000, Yearly: true},
+		{Name: "Holidays", StartDate: date("2000-12-20"), EndDate: date("2001-01-05"), NightlyRate: 18000, Yearly: true},
+		{Name: "Festival", StartDate: date("2050-07-14"), EndDate: date("2050-07-15"), NightlyRate: 25000},
+	},
+	Discounts: []Discount{
+		{MinNights: 7, Percent: 10},
+		{MinNights: 28, Percent: 25},
+	},
+}
+
+func TestRates_Quote(t *testing.T) {
+	var tests = []struct {
+		name          string
+		start, end    string
+		expectedRates []int
+		expectedNames []string
+		expectedTotal int
+	}{
+		// 2050-03-07 is a monday
+		{"weekdays", "2050-03-07", "2050-03-09", []int{10000, 10000}, []string{"Base rate", "Base rate"}, 20000},
+		{"weekend", "2050-03-10", "2050-03-13", []int{10000, 12000, 12000}, []string{"Base rate", "Weekend rate", "Weekend rate"}, 34000},
+		{"season", "2050-06-30", "2050-07-02", []int{10000, 15000}, []string{"Base rate", "Summer"}, 25000},
+		{"date range over season", "2050-07-13", "2050-07-16", []int{15000, 25000, 25000}, []string{"Summer", "Festival", "Festival"}, 65000},
+		{"season over new year", "2050-12-31", "2051-01-02", []int{18000, 18000}, []string{"Holidays", "Holidays"}, 36000},
+		{"week gets 10% off", "2050-03-07", "2050-03-14", nil, nil, (5*10000 + 2*12000) * 90 / 100},
+	}
+	for _, e := range tests {
+		q, err := rates.Quote(date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+		if e.expectedRates != nil {
+			if len(q.Nights) != len(e.expectedRates) {
+				t.Errorf("%s: expected %d nights, got %d", e.name, len(e.expectedRates), len(q.Nights))
+				continue
+			}
+			for i, n := range q.Nights {
+				if n.Rate != e.expectedRates[i] || n.RateName != e.expectedNames[i] {
+					t.Errorf("%s: night %d expected %d %s, got %d %s", e.name, i, e.expectedRates[i], e.expectedNames[i], n.Rate, n.RateName)
+				}
+			}
+		}
+		if q.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d, got %d", e.name, e.expectedTotal, q.Total)
+		}
+		if q.Subtotal-q.Discount != q.Total {
+			t.Errorf("%s: subtotal %d minus discount %d is not the total %d", e.name, q.Subtotal, q.Discount, q.Total)
+		}
+	}
+}
+
+func TestRates_QuoteBestDiscount(t *testing.T) {
+	q, err := Rates{BaseRate: 1001, Discounts: rates.Discounts}.Quote(date("2050-03-01"), date("2050-03-31"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.DiscountPercent != 25 {
+		t.Errorf("expected the 28 night discount, got %d%%", q.DiscountPercent)
+	}
+	// 30 nights * 1001 = 30030, a quarter of it is 7507.5 which rounds up
+	if q.Discount != 7508 || q.Total != 22522 {
+		t.Errorf("expected discount 7508 and total 22522, got %d and %d", q.Discount, q.Total)
+	}
+}
+
+func TestRates_QuoteErrors(t *testing.T) {
+	if _, err := rates.Quote(date("2050-03-07"), date("2050-03-07")); err != ErrNoNights {
+		t.Errorf("expected ErrNoNights for a stay without nights, got %v", err)
+	}
+	if _, err := (Rates{}).Quote(date("2050-03-07"), date("2050-03-08")); err != ErrNoRate {
+		t.Errorf("expected ErrNoRate for a room without rate, got %v", err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for cents, expected := range map[int]string{0: "$0.00", 5: "$0.05", 12050: "$120.50", -150: "-$1.50"} {
+		if Format(cents) != expected {
+			t.Errorf("Format(%d): expected %s got %s", cents, expected, Format(cents))
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for s, expected := range map[string]int{"120": 12000, "120.5": 12050, "$120.50": 12050, " 0.05 ": 5} {
+		cents, err := ParseAmount(s)
+		if err != nil || cents != expected {
+			t.Errorf("ParseAmount(%q): expected %d got %d %v", s, expected, cents, err)
+		}
+	}
+	for _, s := range []string{"", "fish", "1.234", "-5", ".5", "1.-5", "1.+5", "+1"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q): expected an error", s)
+		}
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"github.com/justinas/nosurf"
 	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/helpers"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
 	"html/template"
 	"log"
 	"net/http"
@@ -16,10 +18,15 @@ import (
 
 //functions what this allows us to do is to specify certain functions that are available to our golang template
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":    HumanDate,
+	"formatDate":   FormatDate,
+	"iterate":      Iterate,
+	"add":          Add,
+	"roleName":     models.RoleName,
+	"formatPrice":  pricing.Format,
+	"statusName":   models.StatusName,
+	"statusAction": models.StatusAction,
+	"nextStatuses": models.NextStatuses,
 }
 
 func Add(a, b int) int {
@@ -64,6 +71,7 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	td.AccessLevel = helpers.AccessLevel(r)
 	td.CSRFToken = nosurf.Token(r)
 	return td
 }
@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"github.com/alexedwards/scs/v2"
 	"github.com/majedutd990/bookings/internal/config"
+	"github.com/majedutd990/bookings/internal/helpers"
 	"github.com/majedutd990/bookings/internal/models"
 	"log"
 	"net/http"
@@ -37,6 +38,7 @@ func TestMain(m *testing.M) {
 	testApp.Session = session
 	// we need app not testApp in render.go
 	app = &testApp
+	helpers.NewHelper(&testApp)
 	os.Exit(m.Run())
 }
 
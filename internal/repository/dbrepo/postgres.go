@@ -2,17 +2,17 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"github.com/majedutd990/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 	"log"
+	"strings"
 	"time"
 )
 
-func (p *postgresDBRepo) AllUsers() bool {
-	return true
-}
-
 //InsertReservation inserts a reservation into the database
 func (p *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	var newID int
@@ -26,8 +26,8 @@ func (p *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	//the above line means that if this transaction is not commit in 4 second he says 3
 	//something is seriously wrong in our application
 	stmt := `insert into reservations (first_name,last_name,email,phone,start_date,end_date,room_id
-             ,created_at,updated_at)
-			  values($1,$2,$3,$4,$5,$6,$7,$8,$9)  returning id`
+             ,created_at,updated_at,total_price)
+			  values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)  returning id`
 
 	//exec does not know anything about context but
 	// execContext know
@@ -40,7 +40,8 @@ func (p *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.EndDate,
 		res.RoomID,
 		time.Now(),
-		time.Now()).Scan(&newID)
+		time.Now(),
+		res.TotalPrice).Scan(&newID)
 
 	if err != nil {
 		return 0, err
@@ -74,32 +75,190 @@ func (p *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
-func (p *postgresDBRepo) GetAllRooms() ([]models.Room, error) {
-	var rooms []models.Room
+//InsertReservationWithRestriction inserts a reservation and its room restriction in one transaction,
+// with the status of res (pending if it has none) and the first status change, made by userID (0 for the guest).
+// the room row is locked first, so two guests booking the same room have to wait for each other,
+// then availability is checked again and a *repository.RoomNotAvailableError is returned if someone was faster.
+// mails are put in the outbox in the same transaction, so they are sent if and only if the booking is stored
+func (p *postgresDBRepo) InsertReservationWithRestriction(res models.Reservation, restrictionID int, userID int, audit *models.AuditEntry, mails ...models.MailData) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	query := `
-			select r.id, r.room_name,r.created_at,r.updated_at
-			from rooms r
-			order by r.room_name
-`
-	rows, err := p.DB.QueryContext(ctx, query)
+
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return rooms, err
+		return 0, err
 	}
-	var room models.Room
-	for rows.Next() {
-		err = rows.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	// rollback does nothing once the transaction is committed
+	defer tx.Rollback()
+
+	newID, err := insertReservationTx(ctx, tx, res, restrictionID, userID, mails)
+	if err != nil {
+		return 0, err
+	}
+	if err = auditTx(ctx, tx, audit, newID); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+//InsertReservationWithPayment works like InsertReservationWithRestriction and stores the payment of the reservation
+// in the same transaction, it returns the ids of the reservation and the payment
+func (p *postgresDBRepo) InsertReservationWithPayment(res models.Reservation, restrictionID int, pay models.Payment, mails ...models.MailData) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	reservationID, err := insertReservationTx(ctx, tx, res, restrictionID, 0, mails)
+	if err != nil {
+		return 0, 0, err
+	}
+	var paymentID int
+	stmt := `insert into payments (reservation_id, provider, reference, amount, refunded, status, created_at, updated_at)
+			  values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		reservationID,
+		pay.Provider,
+		pay.Reference,
+		pay.Amount,
+		pay.Refunded,
+		pay.Status,
+		time.Now(),
+		time.Now()).Scan(&paymentID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return reservationID, paymentID, nil
+}
+
+//insertReservationTx does the work of InsertReservationWithRestriction in tx, without committing it
+func insertReservationTx(ctx context.Context, tx *sql.Tx, res models.Reservation, restrictionID int, userID int, mails []models.MailData) (int, error) {
+	err := lockRoomAndCheckAvailability(ctx, tx, res.RoomID, res.StartDate, res.EndDate, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	var manageTokenHash interface{}
+	if res.ManageTokenHash != "" {
+		manageTokenHash = res.ManageTokenHash
+	}
+	status := res.Status
+	if status == "" {
+		status = models.StatusPending
+	}
+	stmt := `insert into reservations (first_name,last_name,email,phone,start_date,end_date,room_id
+             ,created_at,updated_at,manage_token_hash,total_price,status)
+			  values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)  returning id`
+	err = tx.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		time.Now(),
+		time.Now(),
+		manageTokenHash,
+		res.TotalPrice,
+		status).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into room_restrictions (start_date,end_date,room_id,
+             reservation_id,created_at,updated_at,restriction_id)
+			  values($1,$2,$3,$4,$5,$6,$7)`
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		restrictionID)
+	if err != nil {
+		return 0, err
+	}
+
+	// the history starts with the booking, from no status to the first one
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt = `insert into reservation_status_changes (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+			values ($1, '', $2, $3, $4, $4)`
+	_, err = tx.ExecContext(ctx, stmt, newID, status, user, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range mails {
+		err = insertOutboxMail(ctx, tx, m)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		rooms = append(rooms, room)
 	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+	return newID, nil
+}
+
+//lockRoomAndCheckAvailability locks the room row until tx ends, so bookings of the same room
+// wait for each other, and then checks that nothing else takes the room in the given dates.
+// the restriction of excludeReservationID is ignored, that's the one being moved
+func lockRoomAndCheckAvailability(ctx context.Context, tx *sql.Tx, roomID int, start, end time.Time, excludeReservationID int) error {
+	var active bool
+	err := tx.QueryRowContext(ctx, `select active from rooms where id = $1 for update`, roomID).Scan(&active)
+	if err != nil {
+		return err
 	}
-	return rooms, nil
+	// a room that was taken off the site can't be booked anymore
+	if !active {
+		return &repository.RoomNotAvailableError{
+			RoomID:    roomID,
+			StartDate: start,
+			EndDate:   end,
+		}
+	}
+
+	var numRows int
+	query := `
+			select
+				count(id)
+			from room_restrictions
+			where
+			room_id = $1 and $2<end_date and $3>start_date and coalesce(reservation_id,0) <> $4
+			and restriction_id in (select id from restrictions where unavailable)`
+	err = tx.QueryRowContext(ctx, query, roomID, start, end, excludeReservationID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return &repository.RoomNotAvailableError{
+			RoomID:    roomID,
+			StartDate: start,
+			EndDate:   end,
+		}
+	}
+	return nil
+}
 
+//GetAllRooms returns all rooms, active or not, in the order they are shown on the site
+func (p *postgresDBRepo) GetAllRooms() ([]models.Room, error) {
+	query := `select ` + roomColumns + `
+			from rooms
+			order by sort_order, room_name`
+	return p.queryRooms(query)
 }
 
 //SearchAvailabilityByDatesByRoomID returns true if there is an availability otherwise false for roomID
@@ -111,7 +270,8 @@ func (p *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 				count(id)
 			from room_restrictions
 			where
-			room_id = $1 and $2<end_date and $3>start_date`
+			room_id = $1 and $2<end_date and $3>start_date
+			and restriction_id in (select id from restrictions where unavailable)`
 	var nomRows int
 	row := p.DB.QueryRowContext(ctx, query, roomId, start, end)
 	err := row.Scan(&nomRows)
@@ -134,8 +294,10 @@ func (p *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 			select r.id, r.room_name
 			from
 			rooms r
-			where r.id not in 
-			(select room_id from room_restrictions rr where $1<rr.end_date and $2>rr.start_date)
+			where r.active and r.id not in
+			(select room_id from room_restrictions rr where $1<rr.end_date and $2>rr.start_date
+			and rr.restriction_id in (select id from restrictions where unavailable))
+			order by r.sort_order, r.room_name
 `
 	rows, err := p.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
@@ -159,36 +321,17 @@ func (p *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 func (p *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	var room models.Room
-
-	query := `
-			select id,room_name,created_at,updated_at from rooms
-			where id = $1
-`
-	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
 
-	if err != nil {
-		return room, err
-	}
-	return room, nil
+	query := `select ` + roomColumns + ` from rooms
+			where id = $1`
+	return scanRoom(p.DB.QueryRowContext(ctx, query, id))
 }
 
-//GetUserByID returns user by id
-func (p *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
-	defer cancel()
-	query := `
-			select id,first_name,last_name,email,password,access_level,created_at,updated_at from users
-			where id = $1
-`
-	//at most one row
-	row := p.DB.QueryRowContext(ctx, query, id)
+//userColumns are the columns scanUser expects
+const userColumns = `id,first_name,last_name,email,password,access_level,active,session_version,created_at,updated_at`
+
+//scanUser reads one user row selected with userColumns
+func scanUser(row scanner) (models.User, error) {
 	var u models.User
 	err := row.Scan(
 		&u.ID,
@@ -197,38 +340,323 @@ func (p *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&u.Active,
+		&u.SessionVersion,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
+	return u, err
+}
+
+//AllUsers returns all users, the active ones first
+func (p *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var users []models.User
+	query := `select ` + userColumns + ` from users order by active desc, last_name, first_name`
+	rows, err := p.DB.QueryContext(ctx, query)
 	if err != nil {
-		return u, err
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
 	}
-	return u, nil
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+	return users, nil
+}
+
+//GetUserByID returns user by id
+func (p *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	query := `select ` + userColumns + ` from users where id = $1`
+	//at most one row
+	return scanUser(p.DB.QueryRowContext(ctx, query, id))
+}
+
+//GetUserByEmail returns the user with the email, emails are compared case insensitive
+func (p *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	query := `select ` + userColumns + ` from users where lower(email) = lower($1)`
+	return scanUser(p.DB.QueryRowContext(ctx, query, email))
+}
 
+//InsertUser creates an active user without a password together with the token of their invite link,
+// the user sets a password through it. the UserID of invite is ignored
+func (p *postgresDBRepo) InsertUser(u models.User, invite models.PasswordToken, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		var newID int
+		stmt := `insert into users (first_name,last_name,email,password,access_level,active,created_at,updated_at)
+			  values($1,$2,$3,'',$4,true,$5,$6) returning id`
+		err := tx.QueryRowContext(ctx, stmt,
+			u.FirstName,
+			u.LastName,
+			u.Email,
+			u.AccessLevel,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		if err != nil {
+			return 0, err
+		}
+		invite.UserID = newID
+		return newID, insertPasswordToken(ctx, tx, invite)
+	})
 }
 
 //UpdateUser updates a user in database
-func (p *postgresDBRepo) UpdateUser(u models.User) error {
+func (p *postgresDBRepo) UpdateUser(u models.User, audit *models.AuditEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	query := `
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		query := `
 			update users set first_name=$1,last_name=$2, email=$3, access_level=$4,updated_at=$5
 			where id = $6
 
 `
-	_, err := p.DB.ExecContext(ctx, query,
-		u.FirstName,
-		u.LastName,
-		u.Email,
-		u.AccessLevel,
+		result, err := tx.ExecContext(ctx, query,
+			u.FirstName,
+			u.LastName,
+			u.Email,
+			u.AccessLevel,
+			time.Now(),
+			u.ID,
+		)
+		if err != nil {
+			return 0, err
+		}
+		return u.ID, rowsChanged(result)
+	})
+	return err
+}
+
+//passwordCost is the bcrypt cost of new password hashes
+const passwordCost = 12
+
+//hashPassword hashes a password the way Authenticate expects it
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+//UpdateUserPassword stores a new password of a user, hashed with bcrypt, and returns the new session version.
+// the sessions of the user are logged out and the password links they still have stop working
+func (p *postgresDBRepo) UpdateUserPassword(id int, password string, audit *models.AuditEntry) (int, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	version, err := setPasswordTx(ctx, tx, id, hash)
+	if err != nil {
+		return 0, err
+	}
+	if err = auditTx(ctx, tx, audit, id); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+//setPasswordTx stores the password hash of a user, starts a new session version and voids the unused
+// password tokens of the user. it returns the new session version
+func setPasswordTx(ctx context.Context, tx *sql.Tx, userID int, hash string) (int, error) {
+	var version int
+	stmt := `update users set password = $1, session_version = session_version + 1, updated_at = $2
+			where id = $3 returning session_version`
+	err := tx.QueryRowContext(ctx, stmt, hash, time.Now(), userID).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `update password_tokens set used_at = $1, updated_at = $1
+			where user_id = $2 and used_at is null`, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+//SetUserActive activates or deactivates a user. deactivated users can't log in and their api tokens stop working
+func (p *postgresDBRepo) SetUserActive(id int, active bool, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `update users set active = $1, updated_at = $2 where id = $3`
+		result, err := tx.ExecContext(ctx, stmt, active, time.Now(), id)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//InsertPasswordToken stores a new password token (only its hash). the unused tokens the user got
+// before for the same purpose stop working, only the newest link counts
+func (p *postgresDBRepo) InsertPasswordToken(t models.PasswordToken, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = insertPasswordToken(ctx, tx, t); err != nil {
+		return err
+	}
+	if err = auditTx(ctx, tx, audit, t.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//RequestPasswordToken stores t like InsertPasswordToken, unless the user got a password token after since:
+// then nothing changes and it returns false. requests for the same user take turns
+func (p *postgresDBRepo) RequestPasswordToken(t models.PasswordToken, since time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRowContext(ctx, `select id from users where id = $1 for update`, t.UserID).Scan(&id)
+	if err != nil {
+		return false, err
+	}
+	var recent bool
+	err = tx.QueryRowContext(ctx, `select exists(select 1 from password_tokens where user_id = $1 and created_at > $2)`,
+		t.UserID, since).Scan(&recent)
+	if err != nil || recent {
+		return false, err
+	}
+	if err = insertPasswordToken(ctx, tx, t); err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//insertPasswordToken stores t and voids the unused tokens the user got before for the same purpose
+func insertPasswordToken(ctx context.Context, tx *sql.Tx, t models.PasswordToken) error {
+	_, err := tx.ExecContext(ctx, `update password_tokens set used_at = $1, updated_at = $1
+			where user_id = $2 and purpose = $3 and used_at is null`, time.Now(), t.UserID, t.Purpose)
+	if err != nil {
+		return err
+	}
+	stmt := `insert into password_tokens (user_id,token_hash,purpose,expires_at,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6)`
+	_, err = tx.ExecContext(ctx, stmt,
+		t.UserID,
+		t.TokenHash,
+		t.Purpose,
+		t.ExpiresAt,
+		time.Now(),
 		time.Now(),
-		u.ID,
+	)
+	return err
+}
+
+//GetPasswordToken returns an unused, not expired password token of an active user together with the user
+func (p *postgresDBRepo) GetPasswordToken(tokenHash string) (models.PasswordToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var t models.PasswordToken
+	query := `
+			select t.id,t.user_id,t.purpose,t.expires_at,t.created_at,t.updated_at,
+			u.id,u.first_name,u.last_name,u.email,u.access_level
+			from password_tokens t
+			left join users u on (t.user_id = u.id)
+			where t.token_hash = $1 and t.used_at is null and t.expires_at > $2 and u.active = true
+`
+	err := p.DB.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Purpose,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.ID,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+		&t.User.AccessLevel,
 	)
 	if err != nil {
-		return err
+		return t, err
 	}
-	return nil
+	t.TokenHash = tokenHash
+	t.User.Active = true
+	return t, nil
+}
+
+//SetPasswordWithToken uses a password token to set the password of its user and returns the user id.
+// the token is locked and marked as used in the same transaction, so it works only once. like
+// UpdateUserPassword it logs the user out everywhere
+func (p *postgresDBRepo) SetPasswordWithToken(tokenHash, password string) (int, error) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
+	var userID int
+	query := `
+			select t.user_id from password_tokens t
+			left join users u on (t.user_id = u.id)
+			where t.token_hash = $1 and t.used_at is null and t.expires_at > $2 and u.active = true
+			for update of t
+`
+	err = tx.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+	// this voids the token too
+	_, err = setPasswordTx(ctx, tx, userID, hash)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return userID, nil
 }
 
 //Authenticate authenticates a user
@@ -238,7 +666,7 @@ func (p *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	var id int
 	var hashedPassword string
 	query := `
-			select id, password from users where email = $1
+			select id, password from users where email = $1 and active = true
 `
 	row := p.DB.QueryRowContext(ctx, query, email)
 	err := row.Scan(
@@ -258,98 +686,171 @@ func (p *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-//AllReservation returns a slice of all reservations
-func (p *postgresDBRepo) AllReservation() ([]models.Reservation, error) {
+//InsertAPIToken stores a new api token (only its hash) for a user
+func (p *postgresDBRepo) InsertAPIToken(t models.APIToken, audit *models.AuditEntry) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	var reservations []models.Reservation
+	var expiresAt interface{}
+	if !t.ExpiresAt.IsZero() {
+		expiresAt = t.ExpiresAt
+	}
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		var newID int
+		stmt := `insert into api_tokens (user_id,name,token_hash,access_level,expires_at,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6,$7) returning id`
+		err := tx.QueryRowContext(ctx, stmt,
+			t.UserID,
+			t.Name,
+			t.TokenHash,
+			t.AccessLevel,
+			expiresAt,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		return newID, err
+	})
+}
 
+//GetAPITokensForUser returns all api tokens of a user, newest first
+func (p *postgresDBRepo) GetAPITokensForUser(userID int) ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var tokens []models.APIToken
 	query := `
-				select r.id,r.first_name,r.last_name,r.email,r.phone,
-				r.start_date,r.end_date,r.room_id,r.created_at,r.updated_at,r.processed
-				,rm.id,rm.room_name
-				from reservations r 
-				left join rooms rm on (r.room_id = rm.id)
-				order by r.start_date asc
+			select id,user_id,name,access_level,
+			coalesce(last_used_at,'0001-01-01'),coalesce(expires_at,'0001-01-01'),created_at,updated_at
+			from api_tokens
+			where user_id = $1
+			order by created_at desc
 `
-	rows, err := p.DB.QueryContext(ctx, query)
-
+	rows, err := p.DB.QueryContext(ctx, query, userID)
 	if err != nil {
-		return reservations, err
+		return tokens, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var i models.Reservation
-
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
+		var t models.APIToken
+		err = rows.Scan(
+			&t.ID,
+			&t.UserID,
+			&t.Name,
+			&t.AccessLevel,
+			&t.LastUsedAt,
+			&t.ExpiresAt,
+			&t.CreatedAt,
+			&t.UpdatedAt,
 		)
 		if err != nil {
-			return reservations, err
+			return tokens, err
 		}
-		reservations = append(reservations, i)
+		tokens = append(tokens, t)
 	}
 	if err := rows.Err(); err != nil {
-		return reservations, err
+		return tokens, err
 	}
-	return reservations, nil
+	return tokens, nil
 }
 
-//NewReservation returns a slice of all reservations
-func (p *postgresDBRepo) NewReservation() ([]models.Reservation, error) {
+//GetAPITokenByHash returns a valid (not expired) api token together with its user,
+// and remembers when it was used last
+func (p *postgresDBRepo) GetAPITokenByHash(tokenHash string) (models.APIToken, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	var reservations []models.Reservation
-
+	var t models.APIToken
 	query := `
-				select r.id,r.first_name,r.last_name,r.email,r.phone,
-				r.start_date,r.end_date,r.room_id,r.created_at,r.updated_at,r.processed
-				,rm.id,rm.room_name
-				from reservations r 
-				left join rooms rm on (r.room_id = rm.id)
-				where r.processed = 0
-				order by r.start_date asc
+			select t.id,t.user_id,t.name,t.access_level,coalesce(t.expires_at,'0001-01-01'),
+			t.created_at,t.updated_at,
+			u.id,u.first_name,u.last_name,u.email,u.access_level
+			from api_tokens t
+			left join users u on (t.user_id = u.id)
+			where t.token_hash = $1 and (t.expires_at is null or t.expires_at > $2) and u.active = true
 `
-	rows, err := p.DB.QueryContext(ctx, query)
+	row := p.DB.QueryRowContext(ctx, query, tokenHash, time.Now())
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.AccessLevel,
+		&t.ExpiresAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.ID,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+		&t.User.AccessLevel,
+	)
+	if err != nil {
+		return t, err
+	}
+	t.LastUsedAt = time.Now()
+	_, err = p.DB.ExecContext(ctx, `update api_tokens set last_used_at = $1 where id = $2`, t.LastUsedAt, t.ID)
+	if err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+//DeleteAPIToken revokes an api token of a user, returns sql.ErrNoRows if the user has no such token
+func (p *postgresDBRepo) DeleteAPIToken(id, userID int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `delete from api_tokens where id = $1 and user_id = $2`
+		result, err := tx.ExecContext(ctx, stmt, id, userID)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//reservationColumns are the columns scanReservation expects, r is the reservation and rm its room
+const reservationColumns = `r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id,
+			r.created_at, r.updated_at, r.status, coalesce(r.cancelled_at,'0001-01-01'), r.total_price, rm.id, rm.room_name`
+
+//scanReservation reads one reservation row selected with reservationColumns, columns selected after
+// them are read into extra
+func scanReservation(row scanner, extra ...interface{}) (models.Reservation, error) {
+	var res models.Reservation
+	dest := []interface{}{
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Status,
+		&res.CancelledAt,
+		&res.TotalPrice,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return res, err
+}
 
+//queryReservations runs a select on the reservations and returns the reservations found
+func (p *postgresDBRepo) queryReservations(query string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var reservations []models.Reservation
+	rows, err := p.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return reservations, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var i models.Reservation
-
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
+		res, err := scanReservation(rows)
 		if err != nil {
 			return reservations, err
 		}
-		reservations = append(reservations, i)
+		reservations = append(reservations, res)
 	}
 	if err := rows.Err(); err != nil {
 		return reservations, err
@@ -357,93 +858,365 @@ func (p *postgresDBRepo) NewReservation() ([]models.Reservation, error) {
 	return reservations, nil
 }
 
-//GetReservationById return one reservation by id
+//AllReservation returns all reservations, only the ones in status unless it is ""
+func (p *postgresDBRepo) AllReservation(status string) ([]models.Reservation, error) {
+	query := `select ` + reservationColumns + `
+				from reservations r 
+				left join rooms rm on (r.room_id = rm.id)
+				where r.deleted_at is null and ($1 = '' or r.status = $1)
+				order by r.start_date asc
+`
+	return p.queryReservations(query, status)
+}
+
+//NewReservation returns the reservations that are still open (pending, confirmed or checked in),
+// only the ones in status unless it is ""
+func (p *postgresDBRepo) NewReservation(status string) ([]models.Reservation, error) {
+	query := `select ` + reservationColumns + `
+				from reservations r 
+				left join rooms rm on (r.room_id = rm.id)
+				where r.deleted_at is null and r.status in ('pending', 'confirmed', 'checked_in') and ($1 = '' or r.status = $1)
+				order by r.start_date asc
+`
+	return p.queryReservations(query, status)
+}
+
+//GetReservationById return one reservation by id, reservations in the trash are not found
 func (p *postgresDBRepo) GetReservationById(id int) (models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	var res models.Reservation
-	query := `
-			select r.id,r.first_name,r.last_name,r.email,r.phone,
-		    r.start_date,r.end_date,r.room_id,r.created_at,r.updated_at,r.processed
-			,rm.id,rm.room_name
+	query := `select ` + reservationColumns + `
 			from reservations r 	
 			left join rooms rm on (r.room_id = rm.id)
-			where r.id = $1
+			where r.id = $1 and r.deleted_at is null
 `
-	row := p.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&res.ID,
-		&res.FirstName,
-		&res.LastName,
-		&res.Email,
-		&res.Phone,
-		&res.StartDate,
-		&res.EndDate,
-		&res.RoomID,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-		&res.Processed,
-		&res.Room.ID,
-		&res.Room.RoomName,
-	)
+	return scanReservation(p.DB.QueryRowContext(ctx, query, id))
+}
+
+//GetReservationByManageToken returns the reservation behind a guest's manage link
+func (p *postgresDBRepo) GetReservationByManageToken(tokenHash string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	query := `select ` + reservationColumns + `
+			from reservations r 	
+			left join rooms rm on (r.room_id = rm.id)
+			where r.manage_token_hash = $1 and r.deleted_at is null
+`
+	return scanReservation(p.DB.QueryRowContext(ctx, query, tokenHash))
+}
+
+//UpdateReservationDates moves a reservation to res.StartDate - res.EndDate in res.RoomID, for res.TotalPrice.
+// the reservation and its room restriction are updated in one transaction, after checking
+// that nothing but the reservation itself takes the room in the new dates
+func (p *postgresDBRepo) UpdateReservationDates(res models.Reservation, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockRoomAndCheckAvailability(ctx, tx, res.RoomID, res.StartDate, res.EndDate, res.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update reservations set start_date=$1, end_date=$2, room_id=$3, total_price=$4, updated_at=$5
+			where id = $6`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, res.TotalPrice, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+	stmt = `update room_restrictions set start_date=$1, end_date=$2, room_id=$3, updated_at=$4
+			where reservation_id = $5`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, time.Now(), res.ID)
 	if err != nil {
-		return res, err
+		return err
+	}
+	if err = auditTx(ctx, tx, audit, res.ID); err != nil {
+		return err
 	}
-	return res, nil
+	return tx.Commit()
 }
 
-//UpdateReservation updates a reservations in database
-func (p *postgresDBRepo) UpdateReservation(u models.Reservation) error {
+//ChangeReservationStatus moves a reservation from one status to another and records who did it,
+// userID is 0 for the guest. it returns sql.ErrNoRows when the reservation is not in from (anymore).
+// a cancelled reservation or a no show frees its room by removing its room restriction
+func (p *postgresDBRepo) ChangeReservationStatus(id int, from, to string, userID int, audit *models.AuditEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	query := `
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `update reservations set status=$1, updated_at=$2,
+				cancelled_at = case when $1 = 'cancelled' then $2 else cancelled_at end
+			where id = $3 and status = $4 and deleted_at is null`
+	result, err := tx.ExecContext(ctx, stmt, to, now, id, from)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if models.FreesRoom(to) {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	}
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt = `insert into reservation_status_changes (reservation_id, from_status, to_status, user_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5)`
+	_, err = tx.ExecContext(ctx, stmt, id, from, to, user, now)
+	if err != nil {
+		return err
+	}
+	if err = auditTx(ctx, tx, audit, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//GetReservationStatusChanges returns the status changes of a reservation, oldest first
+func (p *postgresDBRepo) GetReservationStatusChanges(id int) ([]models.StatusChange, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var changes []models.StatusChange
+	query := `select c.id, c.reservation_id, c.from_status, c.to_status, coalesce(c.user_id, 0),
+				coalesce(trim(u.first_name || ' ' || u.last_name), ''), c.created_at
+			from reservation_status_changes c
+			left join users u on (c.user_id = u.id)
+			where c.reservation_id = $1
+			order by c.created_at asc, c.id asc`
+	rows, err := p.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c models.StatusChange
+		err := rows.Scan(&c.ID, &c.ReservationID, &c.FromStatus, &c.ToStatus, &c.UserID, &c.UserName, &c.CreatedAt)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return changes, err
+	}
+	return changes, nil
+}
+
+//UpdateReservation updates a reservations in database, returns sql.ErrNoRows if there is no such reservation
+func (p *postgresDBRepo) UpdateReservation(u models.Reservation, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		query := `
 			update reservations set first_name=$1,last_name=$2, email=$3, phone=$4,updated_at=$5
 			where id = $6
 
 `
-	_, err := p.DB.ExecContext(ctx, query,
-		u.FirstName,
-		u.LastName,
-		u.Email,
-		u.Phone,
-		time.Now(),
-		u.ID,
-	)
+		result, err := tx.ExecContext(ctx, query,
+			u.FirstName,
+			u.LastName,
+			u.Email,
+			u.Phone,
+			time.Now(),
+			u.ID,
+		)
+		if err != nil {
+			return 0, err
+		}
+		return u.ID, rowsChanged(result)
+	})
+	return err
+}
+
+//DeleteReservationById moves a reservation to the trash and frees its room, userID is who did it.
+// it returns sql.ErrNoRows when there is no such reservation or it is in the trash already
+func (p *postgresDBRepo) DeleteReservationById(id, userID int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
 
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt := `update reservations set deleted_at=$1, deleted_by=$2, updated_at=$1
+			where id = $3 and deleted_at is null`
+	result, err := tx.ExecContext(ctx, stmt, time.Now(), user, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if err = auditTx(ctx, tx, audit, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-//DeleteReservationById delete one reservations by id
-func (p *postgresDBRepo) DeleteReservationById(id int) error {
+//GetDeletedReservations returns the reservations in the trash, the last deleted first
+func (p *postgresDBRepo) GetDeletedReservations() ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	query := `
-			delete from reservations 
-			where id = $1
-`
-	_, err := p.DB.ExecContext(ctx, query, id)
+	var reservations []models.Reservation
+	query := `select ` + reservationColumns + `, r.deleted_at, coalesce(r.deleted_by, 0),
+				coalesce(trim(u.first_name || ' ' || u.last_name), '')
+			from reservations r
+			left join rooms rm on (r.room_id = rm.id)
+			left join users u on (r.deleted_by = u.id)
+			where r.deleted_at is not null
+			order by r.deleted_at desc`
+	rows, err := p.DB.QueryContext(ctx, query)
 	if err != nil {
-		return err
+		return reservations, err
 	}
-	return nil
+	defer rows.Close()
+	for rows.Next() {
+		var deletedAt time.Time
+		var deletedBy int
+		var deletedByName string
+		res, err := scanReservation(rows, &deletedAt, &deletedBy, &deletedByName)
+		if err != nil {
+			return reservations, err
+		}
+		res.DeletedAt, res.DeletedBy, res.DeletedByName = deletedAt, deletedBy, deletedByName
+		reservations = append(reservations, res)
+	}
+	if err := rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
 }
 
-//UpdateProcessedFroReservation updates the processed for a reservation by id
-func (p *postgresDBRepo) UpdateProcessedFroReservation(id, processed int) error {
+//RestoreReservation takes a reservation out of the trash. an open reservation gets its room back with
+// restrictionID, if nothing else took the room meanwhile, else a *repository.RoomNotAvailableError is returned.
+// it returns sql.ErrNoRows when the reservation is not in the trash
+func (p *postgresDBRepo) RestoreReservation(id, restrictionID int, audit *models.AuditEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	query := `
-			update reservations set processed = $1 
-			where id = $2
-`
-	_, err := p.DB.ExecContext(ctx, query, processed, id)
+
+	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	var res models.Reservation
+	query := `select room_id, start_date, end_date, status from reservations
+			where id = $1 and deleted_at is not null
+			for update`
+	err = tx.QueryRowContext(ctx, query, id).Scan(&res.RoomID, &res.StartDate, &res.EndDate, &res.Status)
+	if err != nil {
+		return err
+	}
+	// cancelled, checked out... reservations had no room restriction when they were deleted either
+	if models.IsOpen(res.Status) {
+		err = lockRoomAndCheckAvailability(ctx, tx, res.RoomID, res.StartDate, res.EndDate, id)
+		if err != nil {
+			return err
+		}
+		stmt := `insert into room_restrictions (start_date,end_date,room_id,
+				reservation_id,created_at,updated_at,restriction_id)
+				values($1,$2,$3,$4,$5,$5,$6)`
+		_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.RoomID, id, time.Now(), restrictionID)
+		if err != nil {
+			return err
+		}
+	}
+	stmt := `update reservations set deleted_at=null, deleted_by=null, updated_at=$1 where id = $2`
+	_, err = tx.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if err = auditTx(ctx, tx, audit, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//PurgeDeletedReservations removes the reservations that went to the trash before before for good,
+// with their status changes, and records every purge in the audit log without a user. reservations with
+// payments are kept, the money that was taken and given back must stay on record. it returns the ids of
+// the removed and of the kept reservations
+func (p *postgresDBRepo) PurgeDeletedReservations(before time.Time) ([]int, []int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	kept, err := queryIDs(ctx, tx, `select r.id from reservations r
+		where r.deleted_at < $1 and exists (select 1 from payments p where p.reservation_id = r.id)
+		for update of r`, before)
+	if err != nil {
+		return nil, nil, err
+	}
+	purged, err := queryIDs(ctx, tx, `delete from reservations r
+		where r.deleted_at < $1 and not exists (select 1 from payments p where p.reservation_id = r.id)
+		returning r.id`, before)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range purged {
+		err = insertAuditEntry(ctx, tx, models.AuditEntry{Action: models.AuditPurge, Entity: models.AuditReservation, EntityID: id})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return purged, kept, tx.Commit()
+}
+
+//queryIDs runs a query that returns one id per row
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	var ids []int
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
 }
 
 //GetRestrictionsFroRoomByDate gets all restrictions of a given room in a given duration
@@ -453,9 +1226,12 @@ func (p *postgresDBRepo) GetRestrictionsFroRoomByDate(roomId int, startDate, end
 	var restrictions []models.RoomRestriction
 	// coalesce is some kind of shor if
 	query := `
-				select id,coalesce (reservation_id,0), restriction_id,room_id,start_date,end_date
-				from room_restrictions 
-				where room_id = $1 and  $2<end_date and $3>= start_date
+				select rr.id,coalesce (rr.reservation_id,0), rr.restriction_id,rr.room_id,rr.start_date,rr.end_date,
+				coalesce(rr.ical_feed_id,0),coalesce(rr.external_uid,''),rr.reason,
+				r.restriction_name,r.code,r.colour,r.unavailable
+				from room_restrictions rr
+				left join restrictions r on (r.id = rr.restriction_id)
+				where rr.room_id = $1 and  $2<rr.end_date and $3>= rr.start_date
 `
 
 	rows, err := p.DB.QueryContext(ctx, query, roomId, startDate, endDate)
@@ -473,10 +1249,18 @@ func (p *postgresDBRepo) GetRestrictionsFroRoomByDate(roomId int, startDate, end
 			&restriction.RoomID,
 			&restriction.StartDate,
 			&restriction.EndDate,
+			&restriction.ICalFeedID,
+			&restriction.ExternalUID,
+			&restriction.Reason,
+			&restriction.Restrictions.RestrictionName,
+			&restriction.Restrictions.Code,
+			&restriction.Restrictions.Colour,
+			&restriction.Restrictions.Unavailable,
 		)
 		if err != nil {
 			return nil, err
 		}
+		restriction.Restrictions.ID = restriction.RestrictionID
 		restrictions = append(restrictions, restriction)
 	}
 	if err := rows.Err(); err != nil {
@@ -485,39 +1269,1241 @@ func (p *postgresDBRepo) GetRestrictionsFroRoomByDate(roomId int, startDate, end
 	return restrictions, nil
 }
 
-//InsertBlockForRoom inserts a block restrictions for a particular date which is not a reservation
-func (p *postgresDBRepo) InsertBlockForRoom(r models.RoomRestriction) error {
+//InsertBlockForRoom inserts an owner block, which is not a reservation. like a reservation it blocks
+// the nights from r.StartDate up to the night before r.EndDate
+func (p *postgresDBRepo) InsertBlockForRoom(r models.RoomRestriction, audit *models.AuditEntry) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	stmt := `insert into room_restrictions (start_date,end_date,room_id,restriction_id ,created_at,updated_at)
-			  values($1,$2,$3,$4,$5,$6)  returning id`
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into room_restrictions (start_date,end_date,room_id,restriction_id,reason,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6,$7) returning id`
 
-	_, err := p.DB.ExecContext(ctx, stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		2,
-		time.Now(),
-		time.Now(),
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt,
+			r.StartDate,
+			r.EndDate,
+			r.RoomID,
+			r.RestrictionID,
+			r.Reason,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		return newID, nil
+	})
+}
+
+//GetBlockByID returns an owner block, reservations and external bookings are not found
+func (p *postgresDBRepo) GetBlockByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var b models.RoomRestriction
+	query := `select id, room_id, restriction_id, start_date, end_date, reason, created_at, updated_at
+			from room_restrictions
+			where id = $1 and reservation_id is null and ical_feed_id is null`
+	err := p.DB.QueryRowContext(ctx, query, id).Scan(
+		&b.ID,
+		&b.RoomID,
+		&b.RestrictionID,
+		&b.StartDate,
+		&b.EndDate,
+		&b.Reason,
+		&b.CreatedAt,
+		&b.UpdatedAt,
 	)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	return nil
+	return b, err
+}
+
+//UpdateBlock stores the changes of an owner block, sql.ErrNoRows means there is no such block
+func (p *postgresDBRepo) UpdateBlock(r models.RoomRestriction, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `update room_restrictions set start_date=$1, end_date=$2, room_id=$3, restriction_id=$4, reason=$5, updated_at=$6
+			where id = $7 and reservation_id is null and ical_feed_id is null`
+		result, err := tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.RestrictionID, r.Reason, time.Now(), r.ID)
+		if err != nil {
+			return 0, err
+		}
+		return r.ID, rowsChanged(result)
+	})
+	return err
 }
 
 //DeleteBlockByID deletes a room restrictions
-func (p *postgresDBRepo) DeleteBlockByID(id int) error {
+func (p *postgresDBRepo) DeleteBlockByID(id int, audit *models.AuditEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
 	defer cancel()
-	stmt := `delete from room_restrictions
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `delete from room_restrictions
  			where id =$1`
 
-	_, err := p.DB.ExecContext(ctx, stmt, id)
-	if err != nil {
-		log.Println(err)
-		return err
+		_, err := tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		return id, nil
+	})
+	return err
+}
+
+//execer is what *sql.DB and *sql.Tx have in common, so helpers can run in or out of a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+//insertOutboxMail puts a mail in the outbox, ready to be sent right away
+func insertOutboxMail(ctx context.Context, db execer, m models.MailData) error {
+	stmt := `insert into mail_outbox (to_address,from_address,subject,content,plain_content,
+             status,attempts,next_attempt_at,last_error,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6,0,$7,'',$7,$7)`
+	_, err := db.ExecContext(ctx, stmt,
+		m.To,
+		m.From,
+		m.Subject,
+		m.Content,
+		m.PlainContent,
+		models.OutboxPending,
+		time.Now())
+	return err
+}
+
+//InsertOutboxMail puts a mail in the outbox, the mail worker picks it up from there
+func (p *postgresDBRepo) InsertOutboxMail(m models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return insertOutboxMail(ctx, p.DB, m)
+}
+
+//outboxColumns are the columns scanOutboxMail expects
+const outboxColumns = `id, to_address, from_address, subject, content, plain_content, status, attempts,
+			next_attempt_at, last_error, coalesce(sent_at,'0001-01-01'), created_at, updated_at`
+
+//scanOutboxMail reads one outbox row selected with outboxColumns
+func scanOutboxMail(rows *sql.Rows) (models.OutboxMail, error) {
+	var m models.OutboxMail
+	err := rows.Scan(
+		&m.ID,
+		&m.Mail.To,
+		&m.Mail.From,
+		&m.Mail.Subject,
+		&m.Mail.Content,
+		&m.Mail.PlainContent,
+		&m.Status,
+		&m.Attempts,
+		&m.NextAttemptAt,
+		&m.LastError,
+		&m.SentAt,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+	return m, err
+}
+
+//queryOutboxMails runs a select on the outbox and returns the mails found
+func (p *postgresDBRepo) queryOutboxMails(query string, args ...interface{}) ([]models.OutboxMail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var mails []models.OutboxMail
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return mails, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m, err := scanOutboxMail(rows)
+		if err != nil {
+			return mails, err
+		}
+		mails = append(mails, m)
+	}
+	if err = rows.Err(); err != nil {
+		return mails, err
+	}
+	return mails, nil
+}
+
+//GetDueOutboxMails returns pending mails whose next attempt is due, oldest first
+func (p *postgresDBRepo) GetDueOutboxMails(limit int) ([]models.OutboxMail, error) {
+	query := `select ` + outboxColumns + `
+			from mail_outbox
+			where status = $1 and next_attempt_at <= $2
+			order by next_attempt_at, id
+			limit $3`
+	return p.queryOutboxMails(query, models.OutboxPending, time.Now(), limit)
+}
+
+//UpdateOutboxMail stores the result of a delivery attempt
+func (p *postgresDBRepo) UpdateOutboxMail(m models.OutboxMail) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var sentAt interface{}
+	if !m.SentAt.IsZero() {
+		sentAt = m.SentAt
+	}
+	stmt := `update mail_outbox set status = $1, attempts = $2, next_attempt_at = $3, last_error = $4,
+             sent_at = $5, updated_at = $6
+             where id = $7`
+	_, err := p.DB.ExecContext(ctx, stmt,
+		m.Status,
+		m.Attempts,
+		m.NextAttemptAt,
+		m.LastError,
+		sentAt,
+		time.Now(),
+		m.ID)
+	return err
+}
+
+//GetFailedOutboxMails returns the mails that gave up, newest first
+func (p *postgresDBRepo) GetFailedOutboxMails() ([]models.OutboxMail, error) {
+	query := `select ` + outboxColumns + `
+			from mail_outbox
+			where status = $1
+			order by updated_at desc`
+	return p.queryOutboxMails(query, models.OutboxDead)
+}
+
+//RetryOutboxMail puts a dead mail back in the queue with a fresh set of attempts
+func (p *postgresDBRepo) RetryOutboxMail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	stmt := `update mail_outbox set status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+             where id = $3 and status = $4`
+	result, err := p.DB.ExecContext(ctx, stmt, models.OutboxPending, time.Now(), id, models.OutboxDead)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 	return nil
 }
+
+//roomColumns are the columns scanRoom expects
+const roomColumns = `id, room_name, slug, description, max_occupancy, amenities, active, sort_order,
+			base_rate, weekend_rate, created_at, updated_at`
+
+//scanner is what *sql.Row and *sql.Rows have in common
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanRoom reads one room row selected with roomColumns
+func scanRoom(row scanner) (models.Room, error) {
+	var r models.Room
+	var amenities string
+	err := row.Scan(
+		&r.ID,
+		&r.RoomName,
+		&r.Slug,
+		&r.Description,
+		&r.MaxOccupancy,
+		&amenities,
+		&r.Active,
+		&r.SortOrder,
+		&r.BaseRate,
+		&r.WeekendRate,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	r.Amenities = splitAmenities(amenities)
+	return r, err
+}
+
+//splitAmenities turns the amenities column, one per line, into a slice
+func splitAmenities(s string) []string {
+	var amenities []string
+	for _, a := range strings.Split(s, "\n") {
+		a = strings.TrimSpace(a)
+		if a != "" {
+			amenities = append(amenities, a)
+		}
+	}
+	return amenities
+}
+
+//queryRooms runs a select on the rooms and returns the rooms found
+func (p *postgresDBRepo) queryRooms(query string, args ...interface{}) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var rooms []models.Room
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRoom(rows)
+		if err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, r)
+	}
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+	return rooms, nil
+}
+
+//GetActiveRooms returns the rooms that are shown on the site, in their order
+func (p *postgresDBRepo) GetActiveRooms() ([]models.Room, error) {
+	query := `select ` + roomColumns + `
+			from rooms
+			where active
+			order by sort_order, room_name`
+	return p.queryRooms(query)
+}
+
+//GetRoomBySlug gets a room with its images by the slug in its url
+func (p *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms
+			where slug = $1`
+	room, err := scanRoom(p.DB.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		return room, err
+	}
+	room.Images, err = p.GetRoomImages(room.ID)
+	return room, err
+}
+
+//InsertRoom adds a room at the end of the list and returns its id
+func (p *postgresDBRepo) InsertRoom(r models.Room, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into rooms (room_name,slug,description,max_occupancy,amenities,active,base_rate,weekend_rate,
+             sort_order,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6,$7,$8,(select coalesce(max(sort_order),0)+1 from rooms),$9,$9) returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt,
+			r.RoomName,
+			r.Slug,
+			r.Description,
+			r.MaxOccupancy,
+			strings.Join(r.Amenities, "\n"),
+			r.Active,
+			r.BaseRate,
+			r.WeekendRate,
+			time.Now(),
+		).Scan(&newID)
+		return newID, err
+	})
+}
+
+//UpdateRoom stores the details of a room, active and sort order have their own functions.
+// returns sql.ErrNoRows if there is no such room
+func (p *postgresDBRepo) UpdateRoom(r models.Room, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `update rooms set room_name = $1, slug = $2, description = $3, max_occupancy = $4, amenities = $5,
+             base_rate = $6, weekend_rate = $7, updated_at = $8
+             where id = $9`
+		result, err := tx.ExecContext(ctx, stmt,
+			r.RoomName,
+			r.Slug,
+			r.Description,
+			r.MaxOccupancy,
+			strings.Join(r.Amenities, "\n"),
+			r.BaseRate,
+			r.WeekendRate,
+			time.Now(),
+			r.ID)
+		if err != nil {
+			return 0, err
+		}
+		return r.ID, rowsChanged(result)
+	})
+	return err
+}
+
+//SetRoomActive puts a room on the site or takes it off, returns sql.ErrNoRows if there is no such room
+func (p *postgresDBRepo) SetRoomActive(id int, active bool, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		result, err := tx.ExecContext(ctx, `update rooms set active = $1, updated_at = $2 where id = $3`,
+			active, time.Now(), id)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//UpdateRoomSortOrder puts the rooms in the order of ids
+func (p *postgresDBRepo) UpdateRoomSortOrder(ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i, id := range ids {
+		_, err = tx.ExecContext(ctx, `update rooms set sort_order = $1, updated_at = $2 where id = $3`,
+			i+1, time.Now(), id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//GetRoomImages returns the gallery of a room in its order
+func (p *postgresDBRepo) GetRoomImages(roomID int) ([]models.RoomImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var images []models.RoomImage
+	query := `select id, room_id, path, caption, sort_order, created_at, updated_at
+			from room_images
+			where room_id = $1
+			order by sort_order, id`
+	rows, err := p.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return images, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.RoomImage
+		err = rows.Scan(&i.ID, &i.RoomID, &i.Path, &i.Caption, &i.SortOrder, &i.CreatedAt, &i.UpdatedAt)
+		if err != nil {
+			return images, err
+		}
+		images = append(images, i)
+	}
+	if err = rows.Err(); err != nil {
+		return images, err
+	}
+	return images, nil
+}
+
+//InsertRoomImage adds an image at the end of the gallery of its room and returns its id
+func (p *postgresDBRepo) InsertRoomImage(img models.RoomImage, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into room_images (room_id,path,caption,sort_order,created_at,updated_at)
+			  values($1,$2,$3,(select coalesce(max(sort_order),0)+1 from room_images where room_id = $1),$4,$4)
+			  returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt, img.RoomID, img.Path, img.Caption, time.Now()).Scan(&newID)
+		return newID, err
+	})
+}
+
+//DeleteRoomImage removes an image of a room and returns it, so the file can be removed too.
+// returns sql.ErrNoRows if the room has no such image
+func (p *postgresDBRepo) DeleteRoomImage(id, roomID int, audit *models.AuditEntry) (models.RoomImage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var i models.RoomImage
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `delete from room_images where id = $1 and room_id = $2
+			returning id, room_id, path, caption, sort_order, created_at, updated_at`
+		err := tx.QueryRowContext(ctx, stmt, id, roomID).Scan(
+			&i.ID, &i.RoomID, &i.Path, &i.Caption, &i.SortOrder, &i.CreatedAt, &i.UpdatedAt)
+		return id, err
+	})
+	return i, err
+}
+
+//GetRoomRates returns everything we need to quote a room: its rates, overrides and stay discounts.
+// date range overrides come before seasons and newer ones before older ones, so the newest one wins
+func (p *postgresDBRepo) GetRoomRates(roomID int) (pricing.Rates, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	rates := pricing.Rates{RoomID: roomID}
+	err := p.DB.QueryRowContext(ctx, `select base_rate, weekend_rate from rooms where id = $1`, roomID).
+		Scan(&rates.BaseRate, &rates.WeekendRate)
+	if err != nil {
+		return rates, err
+	}
+
+	rates.Overrides, err = p.GetRateOverrides(roomID)
+	if err != nil {
+		return rates, err
+	}
+
+	query := `select id, room_id, min_nights, percent, created_at, updated_at
+			from stay_discounts
+			where room_id = $1
+			order by min_nights`
+	rows, err := p.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d pricing.Discount
+		err = rows.Scan(&d.ID, &d.RoomID, &d.MinNights, &d.Percent, &d.CreatedAt, &d.UpdatedAt)
+		if err != nil {
+			return rates, err
+		}
+		rates.Discounts = append(rates.Discounts, d)
+	}
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+	return rates, nil
+}
+
+//GetRateOverrides returns the overrides of a room, date ranges first, newest first
+func (p *postgresDBRepo) GetRateOverrides(roomID int) ([]pricing.Override, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var overrides []pricing.Override
+	query := `select id, room_id, name, start_date, end_date, nightly_rate, yearly, created_at, updated_at
+			from rate_overrides
+			where room_id = $1
+			order by yearly, id desc`
+	rows, err := p.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return overrides, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o pricing.Override
+		err = rows.Scan(&o.ID, &o.RoomID, &o.Name, &o.StartDate, &o.EndDate, &o.NightlyRate, &o.Yearly,
+			&o.CreatedAt, &o.UpdatedAt)
+		if err != nil {
+			return overrides, err
+		}
+		overrides = append(overrides, o)
+	}
+	if err = rows.Err(); err != nil {
+		return overrides, err
+	}
+	return overrides, nil
+}
+
+//InsertRateOverride adds an override to the rates of a room and returns its id
+func (p *postgresDBRepo) InsertRateOverride(o pricing.Override, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into rate_overrides (room_id,name,start_date,end_date,nightly_rate,yearly,created_at,updated_at)
+			  values($1,$2,$3,$4,$5,$6,$7,$7) returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt,
+			o.RoomID,
+			o.Name,
+			o.StartDate,
+			o.EndDate,
+			o.NightlyRate,
+			o.Yearly,
+			time.Now(),
+		).Scan(&newID)
+		return newID, err
+	})
+}
+
+//DeleteRateOverride removes an override of a room, returns sql.ErrNoRows if the room has no such override
+func (p *postgresDBRepo) DeleteRateOverride(id, roomID int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		result, err := tx.ExecContext(ctx, `delete from rate_overrides where id = $1 and room_id = $2`, id, roomID)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//InsertStayDiscount adds a length of stay discount to a room and returns its id
+func (p *postgresDBRepo) InsertStayDiscount(d pricing.Discount, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into stay_discounts (room_id,min_nights,percent,created_at,updated_at)
+			  values($1,$2,$3,$4,$4) returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt, d.RoomID, d.MinNights, d.Percent, time.Now()).Scan(&newID)
+		return newID, err
+	})
+}
+
+//DeleteStayDiscount removes a stay discount of a room, returns sql.ErrNoRows if the room has no such discount
+func (p *postgresDBRepo) DeleteStayDiscount(id, roomID int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		result, err := tx.ExecContext(ctx, `delete from stay_discounts where id = $1 and room_id = $2`, id, roomID)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//paymentColumns are the columns scanPayment reads, in its order
+const paymentColumns = `id, reservation_id, provider, reference, amount, refunded, status, created_at, updated_at`
+
+func scanPayment(row scanner) (models.Payment, error) {
+	var pay models.Payment
+	err := row.Scan(
+		&pay.ID,
+		&pay.ReservationID,
+		&pay.Provider,
+		&pay.Reference,
+		&pay.Amount,
+		&pay.Refunded,
+		&pay.Status,
+		&pay.CreatedAt,
+		&pay.UpdatedAt,
+	)
+	return pay, err
+}
+
+//GetPaymentByID returns one payment
+func (p *postgresDBRepo) GetPaymentByID(id int) (models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := p.DB.QueryRowContext(ctx, `select `+paymentColumns+` from payments where id = $1`, id)
+	return scanPayment(row)
+}
+
+//ApplyPaymentEvent runs apply on the payment with the reference its provider gave it and stores the result, for
+// webhooks. the payment is locked meanwhile. providers may send an event more than once, an event id that was
+// applied to the payment before changes nothing and returns false
+func (p *postgresDBRepo) ApplyPaymentEvent(provider, reference, eventID string, apply func(pay *models.Payment)) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	row := tx.QueryRowContext(ctx, `select `+paymentColumns+` from payments where provider = $1 and reference = $2
+			for update`, provider, reference)
+	pay, err := scanPayment(row)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `insert into payment_events (payment_id, event_id, created_at, updated_at)
+			values ($1, $2, $3, $3) on conflict do nothing`, pay.ID, eventID, now)
+	if err != nil {
+		return false, err
+	}
+	if err = rowsChanged(result); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	apply(&pay)
+	_, err = tx.ExecContext(ctx, `update payments set refunded = $1, status = $2, updated_at = $3 where id = $4`,
+		pay.Refunded, pay.Status, now, pay.ID)
+	if err != nil {
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//GetPaymentsForReservation returns the payments of a reservation, oldest first
+func (p *postgresDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payments []models.Payment
+	rows, err := p.DB.QueryContext(ctx, `select `+paymentColumns+` from payments where reservation_id = $1 order by id`,
+		reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		pay, err := scanPayment(rows)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, pay)
+	}
+	return payments, rows.Err()
+}
+
+//UpdatePayment stores the amount refunded and the status of a payment
+func (p *postgresDBRepo) UpdatePayment(pay models.Payment, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		result, err := tx.ExecContext(ctx, `update payments set refunded = $1, status = $2, updated_at = $3 where id = $4`,
+			pay.Refunded, pay.Status, time.Now(), pay.ID)
+		if err != nil {
+			return 0, err
+		}
+		return pay.ID, rowsChanged(result)
+	})
+	return err
+}
+
+//icalFeedColumns are the columns scanICalFeed reads, in its order
+const icalFeedColumns = `id, room_id, name, url, coalesce(last_synced_at,'0001-01-01'), last_error, created_at, updated_at`
+
+func scanICalFeed(row scanner) (models.ICalFeed, error) {
+	var f models.ICalFeed
+	err := row.Scan(
+		&f.ID,
+		&f.RoomID,
+		&f.Name,
+		&f.URL,
+		&f.LastSyncedAt,
+		&f.LastError,
+		&f.CreatedAt,
+		&f.UpdatedAt,
+	)
+	return f, err
+}
+
+//queryICalFeeds returns the feeds a query selects with icalFeedColumns
+func (p *postgresDBRepo) queryICalFeeds(query string, args ...interface{}) ([]models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var feeds []models.ICalFeed
+	rows, err := p.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return feeds, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		f, err := scanICalFeed(rows)
+		if err != nil {
+			return feeds, err
+		}
+		feeds = append(feeds, f)
+	}
+	return feeds, rows.Err()
+}
+
+//GetICalFeeds returns the feeds of all rooms
+func (p *postgresDBRepo) GetICalFeeds() ([]models.ICalFeed, error) {
+	return p.queryICalFeeds(`select ` + icalFeedColumns + ` from ical_feeds order by id`)
+}
+
+//GetICalFeedsForRoom returns the feeds of a room, oldest first
+func (p *postgresDBRepo) GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error) {
+	return p.queryICalFeeds(`select `+icalFeedColumns+` from ical_feeds where room_id = $1 order by id`, roomID)
+}
+
+//GetICalFeedByID returns a feed of a room, sql.ErrNoRows if the room has no such feed
+func (p *postgresDBRepo) GetICalFeedByID(id, roomID int) (models.ICalFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := p.DB.QueryRowContext(ctx, `select `+icalFeedColumns+` from ical_feeds where id = $1 and room_id = $2`, id, roomID)
+	return scanICalFeed(row)
+}
+
+//InsertICalFeed adds a feed to a room and returns its id
+func (p *postgresDBRepo) InsertICalFeed(f models.ICalFeed, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into ical_feeds (room_id,name,url,last_error,created_at,updated_at)
+			  values($1,$2,$3,'',$4,$4) returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt, f.RoomID, f.Name, f.URL, time.Now()).Scan(&newID)
+		return newID, err
+	})
+}
+
+//DeleteICalFeed removes a feed of a room together with its blocks, returns sql.ErrNoRows if the room has no such feed
+func (p *postgresDBRepo) DeleteICalFeed(id, roomID int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		// the blocks go with the foreign key
+		result, err := tx.ExecContext(ctx, `delete from ical_feeds where id = $1 and room_id = $2`, id, roomID)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//SyncExternalBlocks makes the blocks of a feed what the feed says now, in one transaction:
+// blocks are inserted or moved by their ExternalUID, and blocks that are not in the feed anymore are removed
+func (p *postgresDBRepo) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `insert into room_restrictions (start_date,end_date,room_id,restriction_id,ical_feed_id,external_uid,
+             created_at,updated_at)
+			  values($1,$2,$3,(select id from restrictions where code = $4),$5,$6,$7,$7)
+			  on conflict (ical_feed_id, external_uid) do update
+			  set start_date = excluded.start_date, end_date = excluded.end_date, room_id = excluded.room_id,
+			  updated_at = excluded.updated_at`
+	// not nil, an empty feed removes every block of it
+	uids := make([]string, 0, len(blocks))
+	for _, b := range blocks {
+		_, err = tx.ExecContext(ctx, stmt,
+			b.StartDate,
+			b.EndDate,
+			feed.RoomID,
+			models.RestrictionExternalBooking,
+			feed.ID,
+			b.ExternalUID,
+			now,
+		)
+		if err != nil {
+			return err
+		}
+		uids = append(uids, b.ExternalUID)
+	}
+	// the blocks whose uid is not in the feed anymore are gone upstream
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where ical_feed_id = $1 and external_uid <> all($2)`,
+		feed.ID, uids)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//UpdateICalFeedStatus stores how the last sync of a feed went: with lastError "" it worked at syncedAt,
+// otherwise only the error is stored and the feed keeps the time of the last sync that worked
+func (p *postgresDBRepo) UpdateICalFeedStatus(id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var err error
+	if lastError == "" {
+		_, err = p.DB.ExecContext(ctx, `update ical_feeds set last_synced_at = $1, last_error = '', updated_at = $2 where id = $3`,
+			syncedAt, time.Now(), id)
+	} else {
+		_, err = p.DB.ExecContext(ctx, `update ical_feeds set last_error = $1, updated_at = $2 where id = $3`,
+			lastError, time.Now(), id)
+	}
+	return err
+}
+
+//restrictionColumns are the columns scanRestriction expects
+const restrictionColumns = `id, restriction_name, code, colour, unavailable,
+			(select count(id) from room_restrictions where restriction_id = restrictions.id), created_at, updated_at`
+
+//scanRestriction reads one restriction row selected with restrictionColumns
+func scanRestriction(row scanner) (models.Restriction, error) {
+	var r models.Restriction
+	err := row.Scan(
+		&r.ID,
+		&r.RestrictionName,
+		&r.Code,
+		&r.Colour,
+		&r.Unavailable,
+		&r.Uses,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+	return r, err
+}
+
+//AllRestrictions returns all restrictions, the ones the code relies on first
+func (p *postgresDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var restrictions []models.Restriction
+	query := `select ` + restrictionColumns + `
+			from restrictions
+			order by code = '', id`
+	rows, err := p.DB.QueryContext(ctx, query)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanRestriction(rows)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+//GetRestrictionByID gets a restriction by id
+func (p *postgresDBRepo) GetRestrictionByID(id int) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	query := `select ` + restrictionColumns + ` from restrictions where id = $1`
+	return scanRestriction(p.DB.QueryRowContext(ctx, query, id))
+}
+
+//GetRestrictionByCode gets one of the restrictions the code relies on, like models.RestrictionReservation
+func (p *postgresDBRepo) GetRestrictionByCode(code string) (models.Restriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	query := `select ` + restrictionColumns + ` from restrictions where code = $1 and code <> ''`
+	return scanRestriction(p.DB.QueryRowContext(ctx, query, code))
+}
+
+//InsertRestriction adds a restriction and returns its id, new restrictions never have a code
+func (p *postgresDBRepo) InsertRestriction(r models.Restriction, audit *models.AuditEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	return p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `insert into restrictions (restriction_name,colour,unavailable,created_at,updated_at)
+			  values($1,$2,$3,$4,$4) returning id`
+		var newID int
+		err := tx.QueryRowContext(ctx, stmt, r.RestrictionName, r.Colour, r.Unavailable, time.Now()).Scan(&newID)
+		return newID, err
+	})
+}
+
+//UpdateRestriction stores the name, colour and flag of a restriction, its code never changes.
+// it returns sql.ErrNoRows when there is no such restriction
+func (p *postgresDBRepo) UpdateRestriction(r models.Restriction, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `update restrictions set restriction_name=$1, colour=$2, unavailable=$3, updated_at=$4
+			where id = $5`
+		result, err := tx.ExecContext(ctx, stmt, r.RestrictionName, r.Colour, r.Unavailable, time.Now(), r.ID)
+		if err != nil {
+			return 0, err
+		}
+		return r.ID, rowsChanged(result)
+	})
+	return err
+}
+
+//DeleteRestriction deletes a restriction nobody uses. the ones the code relies on and the ones
+// room restrictions still have are not deleted, that is sql.ErrNoRows
+func (p *postgresDBRepo) DeleteRestriction(id int, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		stmt := `delete from restrictions
+			where id = $1 and code = ''
+			and not exists (select 1 from room_restrictions where restriction_id = $1)`
+		result, err := tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return 0, err
+		}
+		return id, rowsChanged(result)
+	})
+	return err
+}
+
+//insertAuditEntry writes an entry of the audit log in or out of a transaction, a UserID of 0 is stored as null
+func insertAuditEntry(ctx context.Context, db execer, e models.AuditEntry) error {
+	var user sql.NullInt64
+	if e.UserID > 0 {
+		user = sql.NullInt64{Int64: int64(e.UserID), Valid: true}
+	}
+	stmt := `insert into audit_log (user_id, action, entity, entity_id, before, after, ip, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+	_, err := db.ExecContext(ctx, stmt, user, e.Action, e.Entity, e.EntityID, e.Before, e.After, e.IP, time.Now())
+	return err
+}
+
+//auditTx writes the audit entry of a change in the transaction of the change, a create's entry gets the id
+// of the new row. a nil entry writes nothing
+func auditTx(ctx context.Context, tx *sql.Tx, e *models.AuditEntry, entityID int) error {
+	if e == nil {
+		return nil
+	}
+	if e.EntityID == 0 {
+		e.EntityID = entityID
+	}
+	return insertAuditEntry(ctx, tx, *e)
+}
+
+//rowsChanged returns sql.ErrNoRows when a statement changed no row
+func rowsChanged(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//audited runs change in a transaction and writes its audit entry in the same one. change returns the id
+// of what it changed
+func (p *postgresDBRepo) audited(ctx context.Context, e *models.AuditEntry, change func(tx *sql.Tx) (int, error)) (int, error) {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := change(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err = auditTx(ctx, tx, e, id); err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//GetAuditEntries returns a page of the entries that match f, newest first
+func (p *postgresDBRepo) GetAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	to := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if !f.To.IsZero() {
+		to = f.To.AddDate(0, 0, 1)
+	}
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+	var entries []models.AuditEntry
+	query := `select a.id, coalesce(a.user_id, 0), coalesce(trim(u.first_name || ' ' || u.last_name), ''),
+				a.action, a.entity, a.entity_id, a.before, a.after, a.ip, a.created_at
+			from audit_log a
+			left join users u on (a.user_id = u.id)
+			where ($1 = 0 or a.user_id = $1) and ($2 = '' or a.action = $2) and ($3 = '' or a.entity = $3)
+			and ($4 = 0 or a.entity_id = $4) and a.created_at >= $5 and a.created_at < $6
+			order by a.created_at desc, a.id desc
+			limit $7 offset $8`
+	rows, err := p.DB.QueryContext(ctx, query, f.UserID, f.Action, f.Entity, f.EntityID, f.From, to,
+		models.AuditPageSize, (page-1)*models.AuditPageSize)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(&e.ID, &e.UserID, &e.UserName, &e.Action, &e.Entity, &e.EntityID, &e.Before, &e.After, &e.IP, &e.CreatedAt)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+//InsertLoginAttempt records a login attempt, or an owner unlocking an email
+func (p *postgresDBRepo) InsertLoginAttempt(a models.LoginAttempt, audit *models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	_, err := p.audited(ctx, audit, func(tx *sql.Tx) (int, error) {
+		var userID sql.NullInt64
+		if a.UserID > 0 {
+			userID = sql.NullInt64{Int64: int64(a.UserID), Valid: true}
+		}
+		stmt := `insert into login_attempts (user_id, email, ip, user_agent, result, created_at, updated_at)
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+		_, err := tx.ExecContext(ctx, stmt, userID, a.Email, a.IP, a.UserAgent, a.Result, time.Now(), time.Now())
+		return a.UserID, err
+	})
+	return err
+}
+
+//StartLoginAttempt records the attempt a as failed before its password is checked and returns how long ago the
+// failures before it were, newest first: the ones with its email after emailSince and after the last success or
+// unlock of the email, at most emailLimit, and the ones from its ip after ipSince that came before no unlock of their
+// email, at most ipLimit. a success does not reset the ones of the ip, one valid account must not open the door for
+// guessing the others. attempts with the same
+// email or ip take their turns, so each one sees the attempts running at the same time
+func (p *postgresDBRepo) StartLoginAttempt(a models.LoginAttempt, emailSince, ipSince time.Time, emailLimit, ipLimit int) (int, []time.Duration, []time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// the locks are held until the attempt is committed, always the email first
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock(1, hashtext(lower($1)))`, a.Email)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	_, err = tx.ExecContext(ctx, `select pg_advisory_xact_lock(2, hashtext($1))`, a.IP)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	now := time.Now()
+	query := `select extract(epoch from ($2 - a.created_at))::float8
+			from login_attempts a
+			where lower(a.email) = lower($1) and a.result = $3 and a.created_at > $4
+			and a.created_at > coalesce((select max(b.created_at) from login_attempts b
+				where lower(b.email) = lower($1) and b.result in ($5, $6)), '0001-01-01')
+			order by a.created_at desc
+			limit $7`
+	byEmail, err := loginFailures(ctx, tx, query, a.Email, now, models.LoginFailed, emailSince,
+		models.LoginSuccess, models.LoginUnlocked, emailLimit)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	query = `select extract(epoch from ($2 - a.created_at))::float8
+			from login_attempts a
+			where a.ip = $1 and a.result = $3 and a.created_at > $4
+			and not exists (select 1 from login_attempts b
+				where lower(b.email) = lower(a.email) and b.result = $5 and b.created_at > a.created_at)
+			order by a.created_at desc
+			limit $6`
+	byIP, err := loginFailures(ctx, tx, query, a.IP, now, models.LoginFailed, ipSince, models.LoginUnlocked, ipLimit)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	var userID sql.NullInt64
+	if a.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(a.UserID), Valid: true}
+	}
+	var id int
+	stmt := `insert into login_attempts (user_id, email, ip, user_agent, result, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = tx.QueryRowContext(ctx, stmt, userID, a.Email, a.IP, a.UserAgent, models.LoginFailed, now, now).Scan(&id)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, nil, nil, err
+	}
+	return id, byEmail, byIP, nil
+}
+
+//FinishLoginAttempt records the result of an attempt StartLoginAttempt started, and the user if the email is known
+func (p *postgresDBRepo) FinishLoginAttempt(id, userID int, result string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var user sql.NullInt64
+	if userID > 0 {
+		user = sql.NullInt64{Int64: int64(userID), Valid: true}
+	}
+	stmt := `update login_attempts set user_id = $1, result = $2, updated_at = $3 where id = $4`
+	res, err := p.DB.ExecContext(ctx, stmt, user, result, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return rowsChanged(res)
+}
+
+//loginFailures runs a query that selects the age of failures in seconds
+func loginFailures(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]time.Duration, error) {
+	var failures []time.Duration
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return failures, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seconds float64
+		if err := rows.Scan(&seconds); err != nil {
+			return failures, err
+		}
+		failures = append(failures, time.Duration(seconds*float64(time.Second)))
+	}
+	if err := rows.Err(); err != nil {
+		return failures, err
+	}
+	return failures, nil
+}
+
+//GetLoginAttempts returns a page of the login attempts that match f, newest first
+func (p *postgresDBRepo) GetLoginAttempts(f models.LoginAttemptFilter) ([]models.LoginAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+	var attempts []models.LoginAttempt
+	query := `select a.id, coalesce(a.user_id, 0), coalesce(trim(u.first_name || ' ' || u.last_name), ''),
+				a.email, a.ip, a.user_agent, a.result, a.created_at
+			from login_attempts a
+			left join users u on (a.user_id = u.id)
+			where ($1 = '' or lower(a.email) = lower($1)) and ($2 = '' or a.ip = $2) and ($3 = '' or a.result = $3)
+			order by a.created_at desc, a.id desc
+			limit $4 offset $5`
+	rows, err := p.DB.QueryContext(ctx, query, f.Email, f.IP, f.Result, models.LoginPageSize, (page-1)*models.LoginPageSize)
+	if err != nil {
+		return attempts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.LoginAttempt
+		err := rows.Scan(&a.ID, &a.UserID, &a.UserName, &a.Email, &a.IP, &a.UserAgent, &a.Result, &a.CreatedAt)
+		if err != nil {
+			return attempts, err
+		}
+		attempts = append(attempts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+//GetLockedLogins returns the emails with at least failures failed logins after since
+// and after their last success or unlock, the ones locked last first
+func (p *postgresDBRepo) GetLockedLogins(since time.Time, failures int) ([]models.LockedLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+	var locked []models.LockedLogin
+	query := `select lower(a.email), count(*), max(a.created_at)
+			from login_attempts a
+			where a.result = $1 and a.created_at > $2
+			and a.created_at > coalesce((select max(b.created_at) from login_attempts b
+				where lower(b.email) = lower(a.email) and b.result in ($3, $4)), '0001-01-01')
+			group by lower(a.email)
+			having count(*) >= $5
+			order by max(a.created_at) desc`
+	rows, err := p.DB.QueryContext(ctx, query, models.LoginFailed, since, models.LoginSuccess, models.LoginUnlocked, failures)
+	if err != nil {
+		return locked, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l models.LockedLogin
+		if err := rows.Scan(&l.Email, &l.Failures, &l.LastFailure); err != nil {
+			return locked, err
+		}
+		locked = append(locked, l)
+	}
+	if err := rows.Err(); err != nil {
+		return locked, err
+	}
+	return locked, nil
+}
@@ -1,16 +1,18 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
+	"github.com/majedutd990/bookings/internal/helpers"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
+	"github.com/majedutd990/bookings/internal/repository"
 	"log"
+	"strings"
 	"time"
 )
 
-func (p *testDBRepo) AllUsers() bool {
-	return true
-}
-
 //InsertReservation inserts a reservation into the database
 func (p *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	// if roomID is 0 this should fail
@@ -28,6 +30,35 @@ func (p *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+//InsertReservationWithRestriction inserts a reservation and its restriction in one go
+func (p *testDBRepo) InsertReservationWithRestriction(res models.Reservation, restrictionID int, userID int, audit *models.AuditEntry, mails ...models.MailData) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	// room 0 fails like a broken insert, room 1000 like a broken restriction insert
+	if res.RoomID == 0 || res.RoomID == 1000 {
+		return 0, errors.New("some error")
+	}
+	// room 2 is always booked by someone else in the meantime
+	if res.RoomID == 2 {
+		return 0, &repository.RoomNotAvailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+	return 1, nil
+}
+
+//InsertReservationWithPayment fails for the same rooms as InsertReservationWithRestriction
+func (p *testDBRepo) InsertReservationWithPayment(res models.Reservation, restrictionID int, pay models.Payment, mails ...models.MailData) (int, int, error) {
+	id, err := p.InsertReservationWithRestriction(res, restrictionID, 0, nil, mails...)
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, 1, nil
+}
+
 //SearchAvailabilityByDatesByRoomID returns true if there is an availability otherwise false for roomID
 func (p *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomId int) (bool, error) {
 	layout := "2006-01-02"
@@ -101,78 +132,804 @@ func (p *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	if id > 2 {
 		return room, errors.New("there is no such room")
 	}
+	room.ID = id
+	room.Active = true
 	return room, nil
 }
 
+//fakeUsers are the users of the fake db: 1 is a manager, 3 is still invited and 5 is deactivated
+var fakeUsers = []models.User{
+	{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca", Password: "hash", AccessLevel: 3, Active: true, SessionVersion: 1},
+	{ID: 3, FirstName: "New", LastName: "Staff", Email: "new@here.ca", AccessLevel: 1, Active: true, SessionVersion: 1},
+	{ID: 5, FirstName: "Former", LastName: "Staff", Email: "former@here.ca", Password: "hash", AccessLevel: 2, SessionVersion: 1},
+}
+
+func (p *testDBRepo) AllUsers() ([]models.User, error) {
+	return fakeUsers, nil
+}
+
+//GetUserByID knows the fake users, every other id up to 1000 is an active manager
 func (p *testDBRepo) GetUserByID(id int) (models.User, error) {
+	if id > 1000 {
+		return models.User{}, sql.ErrNoRows
+	}
+	for _, u := range fakeUsers {
+		if u.ID == id {
+			return u, nil
+		}
+	}
 	var u = models.User{
-		ID:          0,
-		FirstName:   "",
-		LastName:    "",
-		Email:       "",
-		Password:    "",
-		AccessLevel: "",
-		CreatedAt:   time.Time{},
-		UpdatedAt:   time.Time{},
+		ID:             id,
+		FirstName:      "",
+		LastName:       "",
+		Email:          "me@here.ca",
+		Password:       "hash",
+		AccessLevel:    3,
+		Active:         true,
+		SessionVersion: 1,
+		CreatedAt:      time.Time{},
+		UpdatedAt:      time.Time{},
 	}
 	return u, nil
 }
 
-func (p *testDBRepo) UpdateUser(u models.User) error {
+//GetUserByEmail only knows the fake users
+func (p *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	for _, u := range fakeUsers {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (p *testDBRepo) InsertUser(u models.User, invite models.PasswordToken, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 6, nil
+}
+
+func (p *testDBRepo) UpdateUser(u models.User, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if u.ID > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (p *testDBRepo) UpdateUserPassword(id int, password string, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	if id > 1000 {
+		return 0, sql.ErrNoRows
+	}
+	return 2, nil
+}
+
+func (p *testDBRepo) SetUserActive(id int, active bool, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
+//Authenticate only lets "me@here.ca" in, with any password but "wrong-password"
 func (p *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
 	//correct credential
 	//we are faking what happens in db
-	if email == "me@here.ca" {
+	if email == "me@here.ca" && testPassword != "wrong-password" {
 		return 1, "", nil
 	}
 	return 0, "", errors.New("some error")
 }
 
-func (p *testDBRepo) AllReservation() ([]models.Reservation, error) {
+func (p *testDBRepo) InsertPasswordToken(t models.PasswordToken, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+//RequestPasswordToken turns user 1 down, they got a link a minute ago. links of the public form don't
+// work longer than an hour
+func (p *testDBRepo) RequestPasswordToken(t models.PasswordToken, since time.Time) (bool, error) {
+	if t.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		return false, errors.New("the link works too long")
+	}
+	return t.UserID != 1, nil
+}
+
+//GetPasswordToken knows "valid-invite-token" of user 3 and "valid-reset-token" of user 1
+func (p *testDBRepo) GetPasswordToken(tokenHash string) (models.PasswordToken, error) {
+	t := models.PasswordToken{ID: 1, TokenHash: tokenHash, ExpiresAt: time.Now().Add(time.Hour)}
+	switch tokenHash {
+	case helpers.HashToken("valid-invite-token"):
+		t.Purpose = models.PasswordInvite
+		t.User = fakeUsers[1]
+	case helpers.HashToken("valid-reset-token"):
+		t.Purpose = models.PasswordReset
+		t.User = fakeUsers[0]
+	default:
+		return models.PasswordToken{}, sql.ErrNoRows
+	}
+	t.UserID = t.User.ID
+	return t, nil
+}
+
+func (p *testDBRepo) SetPasswordWithToken(tokenHash, password string) (int, error) {
+	t, err := p.GetPasswordToken(tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	return t.UserID, nil
+}
+
+func (p *testDBRepo) InsertAPIToken(t models.APIToken, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (p *testDBRepo) GetAPITokensForUser(userID int) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	return tokens, nil
+}
+
+//GetAPITokenByHash only knows the token "valid-token", which belongs to user 1
+func (p *testDBRepo) GetAPITokenByHash(tokenHash string) (models.APIToken, error) {
+	var t models.APIToken
+	if tokenHash != helpers.HashToken("valid-token") {
+		return t, sql.ErrNoRows
+	}
+	t.ID = 1
+	t.UserID = 1
+	t.AccessLevel = 3
+	t.User = models.User{ID: 1, AccessLevel: 3}
+	return t, nil
+}
+
+func (p *testDBRepo) DeleteAPIToken(id, userID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	// tokens above 1000 don't exist
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (p *testDBRepo) AllReservation(status string) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
-func (p *testDBRepo) NewReservation() ([]models.Reservation, error) {
+func (p *testDBRepo) NewReservation(status string) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 	return reservations, nil
 }
 
+//GetReservationById knows the reservations up to 1000, 3 is checked out and all others are pending
 func (p *testDBRepo) GetReservationById(id int) (models.Reservation, error) {
 	var res models.Reservation
+	// anything above 1000 is not in our fake db
+	if id > 1000 {
+		return res, sql.ErrNoRows
+	}
+	res.ID = id
+	res.Status = models.StatusPending
+	if id == 3 {
+		res.Status = models.StatusCheckedOut
+	}
 	return res, nil
 }
 
-func (p *testDBRepo) UpdateReservation(u models.Reservation) error {
+//GetReservationByManageToken knows "valid-manage-token" and the already cancelled "cancelled-manage-token"
+func (p *testDBRepo) GetReservationByManageToken(tokenHash string) (models.Reservation, error) {
+	res := models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		Status:    models.StatusConfirmed,
+	}
+	switch tokenHash {
+	case helpers.HashToken("valid-manage-token"):
+		return res, nil
+	case helpers.HashToken("cancelled-manage-token"):
+		res.Status = models.StatusCancelled
+		res.CancelledAt = time.Date(2039, 12, 1, 0, 0, 0, 0, time.UTC)
+		return res, nil
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+//UpdateReservationDates fails for anything starting after 2049-12-31, just like SearchAvailabilityByDatesByRoomID
+func (p *testDBRepo) UpdateReservationDates(res models.Reservation, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if res.StartDate.After(time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC)) {
+		return &repository.RoomNotAvailableError{
+			RoomID:    res.RoomID,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+		}
+	}
+	return nil
+}
+
+func (p *testDBRepo) UpdateReservation(u models.Reservation, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	// reservation 1000 is deleted while it is being edited
+	if u.ID == 1000 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (p *testDBRepo) DeleteReservationById(id int) error {
+//DeleteReservationById moves everything up to 1000 to the trash, like GetReservationById
+func (p *testDBRepo) DeleteReservationById(id, userID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
-func (p *testDBRepo) UpdateProcessedFroReservation(id, processed int) error {
+//GetDeletedReservations returns reservation 7, deleted by the admin, and the cancelled reservation 8
+func (p *testDBRepo) GetDeletedReservations() ([]models.Reservation, error) {
+	reservations := []models.Reservation{
+		{ID: 7, FirstName: "Trashed", LastName: "Guest", RoomID: 1, Status: models.StatusConfirmed,
+			StartDate: time.Date(2050, 3, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 3, 3, 0, 0, 0, 0, time.UTC),
+			DeletedAt: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), DeletedBy: 1, DeletedByName: "Admin User"},
+		{ID: 8, FirstName: "Cancelled", LastName: "Guest", RoomID: 1, Status: models.StatusCancelled,
+			StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC),
+			DeletedAt: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	return reservations, nil
+}
+
+//RestoreReservation restores reservation 7 and 8, reservation 9 lost its room meanwhile, the rest is not in the trash
+func (p *testDBRepo) RestoreReservation(id, restrictionID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	switch id {
+	case 7, 8:
+		return nil
+	case 9:
+		return &repository.RoomNotAvailableError{RoomID: 1}
+	}
+	return sql.ErrNoRows
+}
+
+//PurgeDeletedReservations pretends to remove reservation 7 and to keep reservation 8, which has payments
+func (p *testDBRepo) PurgeDeletedReservations(before time.Time) ([]int, []int, error) {
+	return []int{7}, []int{8}, nil
+}
+
+//ChangeReservationStatus fails for reservation 2 as if someone else changed its status first
+func (p *testDBRepo) ChangeReservationStatus(id int, from, to string, userID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id == 2 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
+func (p *testDBRepo) GetReservationStatusChanges(id int) ([]models.StatusChange, error) {
+	changes := []models.StatusChange{
+		{ID: 1, ReservationID: id, FromStatus: models.StatusPending, ToStatus: models.StatusConfirmed, UserID: 1, UserName: "Admin User"},
+	}
+	return changes, nil
+}
+
 func (p *testDBRepo) GetAllRooms() ([]models.Room, error) {
 	var rooms []models.Room
 	return rooms, nil
 }
+//GetRestrictionsFroRoomByDate returns a reservation, an owner block and an external booking for room 1, nothing for other rooms
 func (p *testDBRepo) GetRestrictionsFroRoomByDate(roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
-	
+	if roomId == 1 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{ID: 1, RoomID: 1, ReservationID: 5, RestrictionID: 1, Restrictions: testRestrictions[0],
+				StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)},
+			models.RoomRestriction{ID: 2, RoomID: 1, RestrictionID: 4, Restrictions: testRestrictions[3], Reason: "new boiler",
+				StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)},
+			models.RoomRestriction{ID: 3, RoomID: 1, RestrictionID: 3, Restrictions: testRestrictions[2], ICalFeedID: 1, ExternalUID: "abc@airbnb.com",
+				StartDate: time.Date(2050, 1, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 12, 0, 0, 0, 0, time.UTC)},
+		)
+	}
 	return restrictions, nil
 }
 
-func (p *testDBRepo) InsertBlockForRoom(r models.RoomRestriction) error {
+//InsertBlockForRoom pretends to add block 4
+func (p *testDBRepo) InsertBlockForRoom(r models.RoomRestriction, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 4, nil
+}
+
+//GetBlockByID knows block 2 of room 1, everything else is not a block
+func (p *testDBRepo) GetBlockByID(id int) (models.RoomRestriction, error) {
+	if id != 2 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+	return models.RoomRestriction{ID: 2, RoomID: 1, RestrictionID: 4, Reason: "new boiler",
+		StartDate: time.Date(2050, 1, 5, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+//UpdateBlock fails for everything but block 2, like GetBlockByID
+func (p *testDBRepo) UpdateBlock(r models.RoomRestriction, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if r.ID != 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (p *testDBRepo) DeleteBlockByID(id int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+
+	return nil
+}
 
+//InsertOutboxMail puts a mail in the outbox
+func (p *testDBRepo) InsertOutboxMail(m models.MailData) error {
 	return nil
 }
 
-func (p *testDBRepo) DeleteBlockByID(id int) error {
+//GetDueOutboxMails returns pending mails that are due
+func (p *testDBRepo) GetDueOutboxMails(limit int) ([]models.OutboxMail, error) {
+	return []models.OutboxMail{}, nil
+}
 
+//UpdateOutboxMail stores the result of a delivery attempt
+func (p *testDBRepo) UpdateOutboxMail(m models.OutboxMail) error {
 	return nil
 }
+
+//GetFailedOutboxMails returns the mails that gave up
+func (p *testDBRepo) GetFailedOutboxMails() ([]models.OutboxMail, error) {
+	return []models.OutboxMail{
+		{
+			ID: 1,
+			Mail: models.MailData{
+				To:      "john@smith.com",
+				From:    "majedutd@gmail.com",
+				Subject: "Reservation Confirmation!",
+			},
+			Status:    models.OutboxDead,
+			Attempts:  8,
+			LastError: "connection refused",
+			UpdatedAt: time.Now(),
+		},
+	}, nil
+}
+
+//RetryOutboxMail puts a dead mail back in the queue, ids above 1000 don't exist
+func (p *testDBRepo) RetryOutboxMail(id int) error {
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//GetActiveRooms returns the rooms that are shown on the site
+func (p *testDBRepo) GetActiveRooms() ([]models.Room, error) {
+	return []models.Room{
+		{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", MaxOccupancy: 2, Active: true, SortOrder: 1},
+		{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", MaxOccupancy: 2, Active: true, SortOrder: 2},
+	}, nil
+}
+
+//GetRoomBySlug knows generals-quarters and majors-suite, every other slug does not exist
+func (p *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	rooms, _ := p.GetActiveRooms()
+	for _, r := range rooms {
+		if r.Slug == slug {
+			r.Amenities = []string{"Sea view"}
+			r.Images = []models.RoomImage{{ID: 1, RoomID: r.ID, Path: "/static/images/generals-quarters.png"}}
+			return r, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+//InsertRoom adds a room
+func (p *testDBRepo) InsertRoom(r models.Room, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 3, nil
+}
+
+//UpdateRoom stores the details of a room
+func (p *testDBRepo) UpdateRoom(r models.Room, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	// room 2 is deleted while it is being edited
+	if r.ID == 2 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//SetRoomActive puts a room on the site or takes it off, ids above 1000 don't exist
+func (p *testDBRepo) SetRoomActive(id int, active bool, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//UpdateRoomSortOrder puts the rooms in the order of ids
+func (p *testDBRepo) UpdateRoomSortOrder(ids []int) error {
+	return nil
+}
+
+//GetRoomImages returns the gallery of a room
+func (p *testDBRepo) GetRoomImages(roomID int) ([]models.RoomImage, error) {
+	return []models.RoomImage{{ID: 1, RoomID: roomID, Path: "/static/images/generals-quarters.png"}}, nil
+}
+
+//InsertRoomImage adds an image to a room
+func (p *testDBRepo) InsertRoomImage(img models.RoomImage, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 2, nil
+}
+
+//DeleteRoomImage removes an image of a room, ids above 1000 don't exist
+func (p *testDBRepo) DeleteRoomImage(id, roomID int, audit *models.AuditEntry) (models.RoomImage, error) {
+	if err := fakeAudit(audit); err != nil {
+		return models.RoomImage{}, err
+	}
+	if id > 1000 {
+		return models.RoomImage{}, sql.ErrNoRows
+	}
+	return models.RoomImage{ID: id, RoomID: roomID, Path: "/static/uploads/rooms/missing.png"}, nil
+}
+
+//GetRoomRates gives every room $100 a night, $120 on weekends, $250 on new year's eve 2049
+// and 10% off from 7 nights
+func (p *testDBRepo) GetRoomRates(roomID int) (pricing.Rates, error) {
+	overrides, _ := p.GetRateOverrides(roomID)
+	return pricing.Rates{
+		RoomID:      roomID,
+		BaseRate:    10000,
+		WeekendRate: 12000,
+		Overrides:   overrides,
+		Discounts:   []pricing.Discount{{ID: 1, RoomID: roomID, MinNights: 7, Percent: 10}},
+	}, nil
+}
+
+//GetRateOverrides returns the overrides of a room
+func (p *testDBRepo) GetRateOverrides(roomID int) ([]pricing.Override, error) {
+	return []pricing.Override{{
+		ID:          1,
+		RoomID:      roomID,
+		Name:        "New Year's Eve",
+		StartDate:   time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2049, 12, 31, 0, 0, 0, 0, time.UTC),
+		NightlyRate: 25000,
+	}}, nil
+}
+
+//InsertRateOverride adds an override to a room
+func (p *testDBRepo) InsertRateOverride(o pricing.Override, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 2, nil
+}
+
+//DeleteRateOverride removes an override of a room, ids above 1000 don't exist
+func (p *testDBRepo) DeleteRateOverride(id, roomID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//InsertStayDiscount adds a stay discount to a room
+func (p *testDBRepo) InsertStayDiscount(d pricing.Discount, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 2, nil
+}
+
+//DeleteStayDiscount removes a stay discount of a room, ids above 1000 don't exist
+func (p *testDBRepo) DeleteStayDiscount(id, roomID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	if id > 1000 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//GetPaymentByID knows payments up to 1000, payment 2 is captured and the others only authorized
+func (p *testDBRepo) GetPaymentByID(id int) (models.Payment, error) {
+	if id > 1000 {
+		return models.Payment{}, sql.ErrNoRows
+	}
+	pay := models.Payment{ID: id, ReservationID: 1, Provider: "fake", Reference: fmt.Sprintf("fake_%d", id),
+		Amount: 2000, Status: models.PaymentAuthorized}
+	if id == 2 {
+		pay.Status = models.PaymentCaptured
+	}
+	return pay, nil
+}
+
+//ApplyPaymentEvent knows every reference except fake_unknown, all captured. event evt_seen was applied before
+func (p *testDBRepo) ApplyPaymentEvent(provider, reference, eventID string, apply func(pay *models.Payment)) (bool, error) {
+	if reference == "fake_unknown" {
+		return false, sql.ErrNoRows
+	}
+	if eventID == "evt_seen" {
+		return false, nil
+	}
+	pay := models.Payment{ID: 1, ReservationID: 1, Provider: provider, Reference: reference,
+		Amount: 2000, Status: models.PaymentCaptured}
+	apply(&pay)
+	return true, nil
+}
+
+//GetPaymentsForReservation returns one captured deposit
+func (p *testDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	return []models.Payment{{ID: 2, ReservationID: reservationID, Provider: "fake", Reference: "fake_2",
+		Amount: 2000, Status: models.PaymentCaptured}}, nil
+}
+
+//UpdatePayment stores nothing
+func (p *testDBRepo) UpdatePayment(pay models.Payment, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+//GetICalFeeds returns no feeds, the sync is tested with its own store
+func (p *testDBRepo) GetICalFeeds() ([]models.ICalFeed, error) {
+	return []models.ICalFeed{}, nil
+}
+
+//GetICalFeedsForRoom returns a feed with url and an uploaded one for room 1
+func (p *testDBRepo) GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error) {
+	var feeds []models.ICalFeed
+	if roomID == 1 {
+		for _, id := range []int{1, 2} {
+			f, _ := p.GetICalFeedByID(id, roomID)
+			feeds = append(feeds, f)
+		}
+	}
+	return feeds, nil
+}
+
+//GetICalFeedByID knows feeds up to 1000 of room 1, feed 2 has no url
+func (p *testDBRepo) GetICalFeedByID(id, roomID int) (models.ICalFeed, error) {
+	if id > 1000 || roomID != 1 {
+		return models.ICalFeed{}, sql.ErrNoRows
+	}
+	f := models.ICalFeed{ID: id, RoomID: roomID, Name: "Airbnb", URL: "https://www.airbnb.com/calendar/ical/1.ics",
+		LastSyncedAt: time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if id == 2 {
+		f.Name = "Vrbo"
+		f.URL = ""
+		f.LastError = "ical: not a calendar"
+	}
+	return f, nil
+}
+
+//InsertICalFeed adds a feed
+func (p *testDBRepo) InsertICalFeed(f models.ICalFeed, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 3, nil
+}
+
+//DeleteICalFeed fails for feeds GetICalFeedByID does not know
+func (p *testDBRepo) DeleteICalFeed(id, roomID int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	_, err := p.GetICalFeedByID(id, roomID)
+	return err
+}
+
+//SyncExternalBlocks stores the blocks of a feed
+func (p *testDBRepo) SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) error {
+	return nil
+}
+
+//UpdateICalFeedStatus stores how the last sync of a feed went
+func (p *testDBRepo) UpdateICalFeedStatus(id int, syncedAt time.Time, lastError string) error {
+	return nil
+}
+
+//testRestrictions are the restrictions of the fake database, like the seeds. maintenance has a block,
+// owner stay is only a note on the calendar
+var testRestrictions = []models.Restriction{
+	{ID: 1, RestrictionName: "Reservation", Code: models.RestrictionReservation, Colour: "#dc3545", Unavailable: true, Uses: 1},
+	{ID: 2, RestrictionName: "Owner Block", Code: models.RestrictionOwnerBlock, Colour: "#6c757d", Unavailable: true},
+	{ID: 3, RestrictionName: "External Booking", Code: models.RestrictionExternalBooking, Colour: "#ffc107", Unavailable: true, Uses: 1},
+	{ID: 4, RestrictionName: "Maintenance", Colour: "#17a2b8", Unavailable: true, Uses: 1},
+	{ID: 5, RestrictionName: "Owner Stay", Colour: "#6f42c1"},
+	{ID: 6, RestrictionName: "Closed", Colour: "#343a40", Unavailable: true},
+}
+
+//AllRestrictions returns testRestrictions
+func (p *testDBRepo) AllRestrictions() ([]models.Restriction, error) {
+	return testRestrictions, nil
+}
+
+//GetRestrictionByID finds one of testRestrictions
+func (p *testDBRepo) GetRestrictionByID(id int) (models.Restriction, error) {
+	for _, r := range testRestrictions {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, sql.ErrNoRows
+}
+
+//GetRestrictionByCode finds one of testRestrictions by its code
+func (p *testDBRepo) GetRestrictionByCode(code string) (models.Restriction, error) {
+	for _, r := range testRestrictions {
+		if code != "" && r.Code == code {
+			return r, nil
+		}
+	}
+	return models.Restriction{}, sql.ErrNoRows
+}
+
+//InsertRestriction pretends to add restriction 7
+func (p *testDBRepo) InsertRestriction(r models.Restriction, audit *models.AuditEntry) (int, error) {
+	if err := fakeAudit(audit); err != nil {
+		return 0, err
+	}
+	return 7, nil
+}
+
+//UpdateRestriction fails for restrictions that are not in testRestrictions
+func (p *testDBRepo) UpdateRestriction(r models.Restriction, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	_, err := p.GetRestrictionByID(r.ID)
+	return err
+}
+
+//DeleteRestriction fails like the database for restrictions that are in use or that the code relies on
+func (p *testDBRepo) DeleteRestriction(id int, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	r, err := p.GetRestrictionByID(id)
+	if err != nil {
+		return err
+	}
+	if r.IsSystem() || r.Uses > 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+//fakeAudit fails like a broken audit log for the requests from 10.0.0.99, the change fails with it
+func fakeAudit(e *models.AuditEntry) error {
+	if e != nil && e.IP == "10.0.0.99" {
+		return errors.New("audit log is broken")
+	}
+	return nil
+}
+
+//GetAuditEntries returns an update and a delete of reservation 1, filtered by entity, entity id and action.
+// user 99 has done nothing
+func (p *testDBRepo) GetAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error) {
+	all := []models.AuditEntry{
+		{ID: 2, UserID: 1, UserName: "Admin User", Action: models.AuditDelete, Entity: models.AuditReservation, EntityID: 1,
+			Before: `{"first_name":"John"}`, IP: "10.0.0.1", CreatedAt: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{ID: 1, UserID: 1, UserName: "Admin User", Action: models.AuditUpdate, Entity: models.AuditReservation, EntityID: 1,
+			Before: `{"first_name":"Jon"}`, After: `{"first_name":"John"}`, IP: "10.0.0.1", CreatedAt: time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	var entries []models.AuditEntry
+	for _, e := range all {
+		if (f.UserID == 0 || e.UserID == f.UserID) && (f.Action == "" || e.Action == f.Action) &&
+			(f.Entity == "" || e.Entity == f.Entity) && (f.EntityID == 0 || e.EntityID == f.EntityID) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (p *testDBRepo) InsertLoginAttempt(a models.LoginAttempt, audit *models.AuditEntry) error {
+	if err := fakeAudit(audit); err != nil {
+		return err
+	}
+	return nil
+}
+
+//StartLoginAttempt knows "locked@here.ca" with 5 failures in the last 5 minutes, "slow@here.ca" with 3 failures,
+// the last one just now, and 10.0.0.66, which failed 30 times in the last 5 minutes. "broken@here.ca" can't be recorded
+func (p *testDBRepo) StartLoginAttempt(a models.LoginAttempt, emailSince, ipSince time.Time, emailLimit, ipLimit int) (int, []time.Duration, []time.Duration, error) {
+	var byEmail, byIP []time.Duration
+	switch a.Email {
+	case "locked@here.ca":
+		byEmail = []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 4 * time.Minute, 5 * time.Minute}
+	case "slow@here.ca":
+		byEmail = []time.Duration{0, 30 * time.Second, time.Minute}
+	case "broken@here.ca":
+		return 0, nil, nil, errors.New("can't record the login")
+	}
+	if len(byEmail) > emailLimit {
+		byEmail = byEmail[:emailLimit]
+	}
+	if a.IP == "10.0.0.66" {
+		for i := 0; i < 30 && i < ipLimit; i++ {
+			byIP = append(byIP, time.Duration(i)*10*time.Second)
+		}
+	}
+	return 1, byEmail, byIP, nil
+}
+
+func (p *testDBRepo) FinishLoginAttempt(id, userID int, result string) error {
+	return nil
+}
+
+//GetLoginAttempts knows a success of user 1 and a failure of locked@here.ca, filtered by f
+func (p *testDBRepo) GetLoginAttempts(f models.LoginAttemptFilter) ([]models.LoginAttempt, error) {
+	all := []models.LoginAttempt{
+		{ID: 2, UserID: 1, UserName: "Admin User", Email: "me@here.ca", IP: "10.0.0.1", UserAgent: "Firefox",
+			Result: models.LoginSuccess, CreatedAt: time.Date(2050, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{ID: 1, Email: "locked@here.ca", IP: "10.0.0.66", UserAgent: "curl/7.79.1",
+			Result: models.LoginFailed, CreatedAt: time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	var attempts []models.LoginAttempt
+	for _, a := range all {
+		if (f.Email == "" || strings.EqualFold(f.Email, a.Email)) && (f.IP == "" || f.IP == a.IP) &&
+			(f.Result == "" || f.Result == a.Result) {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts, nil
+}
+
+func (p *testDBRepo) GetLockedLogins(since time.Time, failures int) ([]models.LockedLogin, error) {
+	return []models.LockedLogin{{Email: "locked@here.ca", Failures: 5, LastFailure: time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC)}}, nil
+}
@@ -1,35 +1,143 @@
 package repository
 
 import (
+	"fmt"
 	"github.com/majedutd990/bookings/internal/models"
+	"github.com/majedutd990/bookings/internal/pricing"
 	"time"
 )
 
-type DataBaseRepo interface {
-	AllUsers() bool
+//RoomNotAvailableError is returned when a room got booked or blocked for the requested dates
+// by someone else before our reservation could be stored
+type RoomNotAvailableError struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+func (e *RoomNotAvailableError) Error() string {
+	return fmt.Sprintf("room %d is no longer available from %s to %s",
+		e.RoomID, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"))
+}
 
+//DataBaseRepo is everything the application keeps in the database. the changes an admin can make take the
+// audit log entry of the change, which is written in the same transaction: the change fails if its entry
+// can't be written. creates get the id of the new row in the entry, a nil entry writes nothing
+type DataBaseRepo interface {
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
+	InsertReservationWithRestriction(res models.Reservation, restrictionID int, userID int, audit *models.AuditEntry, mails ...models.MailData) (int, error)
+	InsertReservationWithPayment(res models.Reservation, restrictionID int, p models.Payment, mails ...models.MailData) (int, int, error)
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, rID int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetAllRooms() ([]models.Room, error)
 
+	//rooms function
+
+	GetActiveRooms() ([]models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(r models.Room, audit *models.AuditEntry) (int, error)
+	UpdateRoom(r models.Room, audit *models.AuditEntry) error
+	SetRoomActive(id int, active bool, audit *models.AuditEntry) error
+	UpdateRoomSortOrder(ids []int) error
+	GetRoomImages(roomID int) ([]models.RoomImage, error)
+	InsertRoomImage(img models.RoomImage, audit *models.AuditEntry) (int, error)
+	DeleteRoomImage(id, roomID int, audit *models.AuditEntry) (models.RoomImage, error)
+
+	//pricing function
+
+	GetRoomRates(roomID int) (pricing.Rates, error)
+	GetRateOverrides(roomID int) ([]pricing.Override, error)
+	InsertRateOverride(o pricing.Override, audit *models.AuditEntry) (int, error)
+	DeleteRateOverride(id, roomID int, audit *models.AuditEntry) error
+	InsertStayDiscount(d pricing.Discount, audit *models.AuditEntry) (int, error)
+	DeleteStayDiscount(id, roomID int, audit *models.AuditEntry) error
+
+	//payments function
+
+	GetPaymentByID(id int) (models.Payment, error)
+	ApplyPaymentEvent(provider, reference, eventID string, apply func(pay *models.Payment)) (bool, error)
+	GetPaymentsForReservation(reservationID int) ([]models.Payment, error)
+	UpdatePayment(p models.Payment, audit *models.AuditEntry) error
+
+	//ical import function
+
+	GetICalFeeds() ([]models.ICalFeed, error)
+	GetICalFeedsForRoom(roomID int) ([]models.ICalFeed, error)
+	GetICalFeedByID(id, roomID int) (models.ICalFeed, error)
+	InsertICalFeed(f models.ICalFeed, audit *models.AuditEntry) (int, error)
+	DeleteICalFeed(id, roomID int, audit *models.AuditEntry) error
+	SyncExternalBlocks(feed models.ICalFeed, blocks []models.RoomRestriction) error
+	UpdateICalFeedStatus(id int, syncedAt time.Time, lastError string) error
+
 	//users function
 
+	AllUsers() ([]models.User, error)
 	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
+	GetUserByEmail(email string) (models.User, error)
+	InsertUser(u models.User, invite models.PasswordToken, audit *models.AuditEntry) (int, error)
+	UpdateUser(u models.User, audit *models.AuditEntry) error
+	UpdateUserPassword(id int, password string, audit *models.AuditEntry) (int, error)
+	SetUserActive(id int, active bool, audit *models.AuditEntry) error
 	Authenticate(email, testPassword string) (int, string, error)
 
+	//login attempt function
+
+	InsertLoginAttempt(a models.LoginAttempt, audit *models.AuditEntry) error
+	StartLoginAttempt(a models.LoginAttempt, emailSince, ipSince time.Time, emailLimit, ipLimit int) (int, []time.Duration, []time.Duration, error)
+	FinishLoginAttempt(id, userID int, result string) error
+	GetLoginAttempts(f models.LoginAttemptFilter) ([]models.LoginAttempt, error)
+	GetLockedLogins(since time.Time, failures int) ([]models.LockedLogin, error)
+
+	//password token function
+
+	InsertPasswordToken(t models.PasswordToken, audit *models.AuditEntry) error
+	RequestPasswordToken(t models.PasswordToken, since time.Time) (bool, error)
+	GetPasswordToken(tokenHash string) (models.PasswordToken, error)
+	SetPasswordWithToken(tokenHash, password string) (int, error)
+
+	//api token function
+
+	InsertAPIToken(t models.APIToken, audit *models.AuditEntry) (int, error)
+	GetAPITokensForUser(userID int) ([]models.APIToken, error)
+	GetAPITokenByHash(tokenHash string) (models.APIToken, error)
+	DeleteAPIToken(id, userID int, audit *models.AuditEntry) error
+
+	//mail outbox function
+
+	InsertOutboxMail(m models.MailData) error
+	GetDueOutboxMails(limit int) ([]models.OutboxMail, error)
+	UpdateOutboxMail(m models.OutboxMail) error
+	GetFailedOutboxMails() ([]models.OutboxMail, error)
+	RetryOutboxMail(id int) error
+
 	//Admin function
 
-	AllReservation() ([]models.Reservation, error)
-	NewReservation() ([]models.Reservation, error)
+	AllReservation(status string) ([]models.Reservation, error)
+	NewReservation(status string) ([]models.Reservation, error)
 	GetReservationById(id int) (models.Reservation, error)
-	UpdateReservation(u models.Reservation) error
-	DeleteReservationById(id int) error
-	UpdateProcessedFroReservation(id, processed int) error
+	GetReservationByManageToken(tokenHash string) (models.Reservation, error)
+	UpdateReservationDates(res models.Reservation, audit *models.AuditEntry) error
+	UpdateReservation(u models.Reservation, audit *models.AuditEntry) error
+	DeleteReservationById(id, userID int, audit *models.AuditEntry) error
+	GetDeletedReservations() ([]models.Reservation, error)
+	RestoreReservation(id, restrictionID int, audit *models.AuditEntry) error
+	PurgeDeletedReservations(before time.Time) ([]int, []int, error)
+	ChangeReservationStatus(id int, from, to string, userID int, audit *models.AuditEntry) error
+	GetReservationStatusChanges(id int) ([]models.StatusChange, error)
 	GetRestrictionsFroRoomByDate(roomId int, startDate, endDate time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(r models.RoomRestriction) error
-	DeleteBlockByID(id int) error
+	InsertBlockForRoom(r models.RoomRestriction, audit *models.AuditEntry) (int, error)
+	GetBlockByID(id int) (models.RoomRestriction, error)
+	UpdateBlock(r models.RoomRestriction, audit *models.AuditEntry) error
+	DeleteBlockByID(id int, audit *models.AuditEntry) error
+
+	AllRestrictions() ([]models.Restriction, error)
+	GetRestrictionByID(id int) (models.Restriction, error)
+	GetRestrictionByCode(code string) (models.Restriction, error)
+	InsertRestriction(r models.Restriction, audit *models.AuditEntry) (int, error)
+	UpdateRestriction(r models.Restriction, audit *models.AuditEntry) error
+	DeleteRestriction(id int, audit *models.AuditEntry) error
+
+	GetAuditEntries(f models.AuditFilter) ([]models.AuditEntry, error)
 }
@@ -0,0 +1,3 @@
+delete
+from restrictions
+where id = 3;
@@ -0,0 +1,2 @@
+INSERT INTO public.restrictions (id, restriction_name, created_at, updated_at)
+VALUES (3, 'External Booking', '2022-01-22 00:00:00', '2022-01-22 00:00:00');
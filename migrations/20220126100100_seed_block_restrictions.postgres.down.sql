@@ -0,0 +1,3 @@
+delete
+from restrictions
+where id in (4, 5, 6);
@@ -0,0 +1,4 @@
+INSERT INTO public.restrictions (id, restriction_name, created_at, updated_at)
+VALUES (4, 'Maintenance', '2022-01-26 00:00:00', '2022-01-26 00:00:00'),
+       (5, 'Owner Stay', '2022-01-26 00:00:00', '2022-01-26 00:00:00'),
+       (6, 'Closed', '2022-01-26 00:00:00', '2022-01-26 00:00:00');
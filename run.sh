@@ -1,3 +1,4 @@
 #!/bin/bash
+# settings come from config.yml and .env (see config.example.yml and .env.example), flags override them
 go build -o bookings cmd/web/*.go
-./bookings -production=false -cache=false -dbname=bookings -dbuser=postgres -dbpass=123hj123
\ No newline at end of file
+./bookings -production=false -cache=false